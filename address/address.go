@@ -32,6 +32,7 @@ import (
 	"encoding/binary"
 	"encoding/hex"
 	"errors"
+	"strings"
 
 	"github.com/golang/crypto/blake2b"
 	"github.com/svaishnavy/nano/types"
@@ -56,9 +57,9 @@ func ValidateAddress(account types.Account) bool {
 func AddressToPub(account types.Account) (public_key []byte, err error) {
 	address := string(account)
 
-	if address[:4] == "xrb_" {
+	if strings.HasPrefix(address, "xrb_") {
 		address = address[4:]
-	} else if address[:5] == "nano_" {
+	} else if strings.HasPrefix(address, "nano_") {
 		address = address[5:]
 	} else {
 		return nil, errors.New("Invalid address format")
@@ -2,6 +2,7 @@ package address
 
 import (
 	"encoding/hex"
+	"strings"
 	"testing"
 
 	"github.com/svaishnavy/nano/types"
@@ -24,6 +25,8 @@ var invalid_addresses = []types.Account{
 	"nano8nm8t5rimw6h6j7wyokbs8jiygzs7baoha4pqzhfw1k79npyr1km8w6y7r8",
 	"nano_8nm8t5rimw6h6j7wyokbs8jiygzs7baoha4pqzhfw1k79npyr1km8w6y7r8",
 	"xrb_8nm8t5rimw6h6j7wyokbs8jiygzs7baoha4pqzhfw1k79npyr1km8w6y7r8",
+	"xrb",
+	"",
 }
 
 func TestAddressToPub(t *testing.T) {
@@ -69,6 +72,68 @@ func TestKeypairFromSeed(t *testing.T) {
 	}
 }
 
+func TestMnemonic(t *testing.T) {
+	// Test vectors from the BIP39 reference implementation
+	vectors := map[string]string{
+		"00000000000000000000000000000000":                                 "abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon about",
+		"7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f7f":                                 "legal winner thank year wave sausage worth useful legal winner thank yellow",
+		"ffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff": "zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo zoo vote",
+	}
+	for entropy, expected := range vectors {
+		entropy_bytes, _ := hex.DecodeString(entropy)
+		mnemonic, err := EntropyToMnemonic(entropy_bytes)
+		if err != nil || mnemonic != expected {
+			t.Errorf("Wrong mnemonic for %s: %s", entropy, mnemonic)
+		}
+		decoded, err := MnemonicToEntropy(mnemonic)
+		if err != nil || hex.EncodeToString(decoded) != entropy {
+			t.Errorf("Mnemonic %s decoded to %x", mnemonic, decoded)
+		}
+	}
+
+	seed, _ := MnemonicToSeed(vectors["00000000000000000000000000000000"], "TREZOR")
+	if hex.EncodeToString(seed) != "c55257c360c07c72029aebc1b53c05ed0362ada38ead3e3e9efa3708e53495531f09a6987599d18264c1e1c92f2cf141630c7a3c4ab7c81b2f001698e7463b04" {
+		t.Errorf("Wrong seed %x", seed)
+	}
+
+	invalid := []string{
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon",
+		"abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon abandon nano",
+		"abandon about",
+	}
+	for _, mnemonic := range invalid {
+		if ValidateMnemonic(mnemonic) {
+			t.Errorf("Accepted invalid mnemonic %s", mnemonic)
+		}
+	}
+
+	mnemonic, err := NewMnemonic(256)
+	if err != nil || len(strings.Fields(mnemonic)) != 24 || !ValidateMnemonic(mnemonic) {
+		t.Errorf("Generated an invalid mnemonic %s", mnemonic)
+	}
+}
+
+func TestKeypairFromBip39Seed(t *testing.T) {
+	// Test vector from the Nano documentation's key derivation examples
+	mnemonic := "edge defense waste choose enrich upon flee junk siren film clown finish luggage leader kid quick brick print evidence swap drill paddle truly occur"
+	seed, err := MnemonicToSeed(mnemonic, "some password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hex.EncodeToString(seed) != "0dc285fde768f7ff29b66ce7252d56ed92fe003b605907f7a4f683c3dc8586d34a914d3c71fc099bb38ee4a59e5b081a3497b7a323e90cc68f67b5837690310c" {
+		t.Errorf("Wrong seed %x", seed)
+	}
+
+	private := DerivePath(seed, []uint32{44, NanoCoinType, 0})
+	if hex.EncodeToString(private) != "3be4fc2ef3f3b7374e6fc4fb6e7bb153f8a2998b3b3dab50853eabe128024143" {
+		t.Errorf("Wrong private key %x", private)
+	}
+	pub, _ := KeypairFromBip39Seed(seed, 0)
+	if PubKeyToAddress(pub) != "nano_1pu7p5n3ghq1i1p4rhmek41f5add1uh34xpb94nkbxe8g4a6x1p69emk8y1d" {
+		t.Errorf("Wrong address %s", PubKeyToAddress(pub))
+	}
+}
+
 func BenchmarkGenerateAddress(b *testing.B) {
 	for n := 0; n < b.N; n++ {
 		pub, _ := GenerateKey()
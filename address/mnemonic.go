@@ -0,0 +1,165 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package address
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"strings"
+
+	"github.com/golang/crypto/pbkdf2"
+	"github.com/svaishnavy/crypto/ed25519"
+)
+
+var ErrInvalidMnemonic = errors.New("Invalid mnemonic")
+
+// Nano's registered BIP44 coin type
+const NanoCoinType = 165
+
+// Generates a random BIP39 mnemonic of 12 to 24 words. Nano wallets use
+// 24 words, from 256 bits of entropy.
+func NewMnemonic(bits int) (string, error) {
+	entropy := make([]byte, bits/8)
+	_, err := rand.Read(entropy)
+	if err != nil {
+		return "", err
+	}
+	return EntropyToMnemonic(entropy)
+}
+
+// Encodes entropy as mnemonic words, with a checksum from its sha256 hash
+// in the last word
+func EntropyToMnemonic(entropy []byte) (string, error) {
+	bits := len(entropy) * 8
+	if bits < 128 || bits > 256 || bits%32 != 0 {
+		return "", errors.New("Mnemonic entropy must be 128 to 256 bits in steps of 32")
+	}
+	checksum_bits := uint(bits / 32)
+	hash := sha256.Sum256(entropy)
+
+	// Append the checksum, then read the number off eleven bits at a time
+	value := new(big.Int).SetBytes(entropy)
+	value.Lsh(value, checksum_bits)
+	value.Or(value, big.NewInt(int64(hash[0]>>(8-checksum_bits))))
+
+	count := (bits + int(checksum_bits)) / 11
+	words := make([]string, count)
+	mask := big.NewInt(2047)
+	for i := count - 1; i >= 0; i-- {
+		index := new(big.Int).And(value, mask)
+		words[i] = mnemonicWords[index.Int64()]
+		value.Rsh(value, 11)
+	}
+	return strings.Join(words, " "), nil
+}
+
+// Decodes a mnemonic back to its entropy, checking every word is in the
+// word list and the checksum matches
+func MnemonicToEntropy(mnemonic string) ([]byte, error) {
+	words := strings.Fields(mnemonic)
+	if len(words) < 12 || len(words) > 24 || len(words)%3 != 0 {
+		return nil, ErrInvalidMnemonic
+	}
+
+	value := new(big.Int)
+	for _, word := range words {
+		index, ok := mnemonicIndexes[word]
+		if !ok {
+			return nil, ErrInvalidMnemonic
+		}
+		value.Lsh(value, 11)
+		value.Or(value, big.NewInt(int64(index)))
+	}
+
+	checksum_bits := uint(len(words) * 11 / 33)
+	checksum := new(big.Int).And(value, big.NewInt(int64(1)<<checksum_bits-1))
+	value.Rsh(value, checksum_bits)
+
+	entropy := make([]byte, int(checksum_bits)*4)
+	value_bytes := value.Bytes()
+	copy(entropy[len(entropy)-len(value_bytes):], value_bytes)
+
+	hash := sha256.Sum256(entropy)
+	if checksum.Int64() != int64(hash[0]>>(8-checksum_bits)) {
+		return nil, ErrInvalidMnemonic
+	}
+	return entropy, nil
+}
+
+func ValidateMnemonic(mnemonic string) bool {
+	_, err := MnemonicToEntropy(mnemonic)
+	return err == nil
+}
+
+// Stretches a mnemonic and optional passphrase into the 64 byte BIP39 seed
+// that keys are derived from. Passphrases should be NFKD normalised by the
+// caller if they aren't ASCII.
+func MnemonicToSeed(mnemonic string, passphrase string) ([]byte, error) {
+	if !ValidateMnemonic(mnemonic) {
+		return nil, ErrInvalidMnemonic
+	}
+	normalised := strings.Join(strings.Fields(mnemonic), " ")
+	return pbkdf2.Key([]byte(normalised), []byte("mnemonic"+passphrase), 2048, 64, sha512.New), nil
+}
+
+// Derives an ed25519 private key along a path of hardened indexes as
+// SLIP-10 describes. ed25519 only supports hardened derivation, so every
+// index has the hardened bit set whether or not it's given.
+func DerivePath(seed []byte, path []uint32) []byte {
+	mac := hmac.New(sha512.New, []byte("ed25519 seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	key, chain := sum[:32], sum[32:]
+
+	for _, index := range path {
+		data := make([]byte, 37)
+		copy(data[1:], key)
+		binary.BigEndian.PutUint32(data[33:], index|0x80000000)
+
+		mac = hmac.New(sha512.New, chain)
+		mac.Write(data)
+		sum = mac.Sum(nil)
+		key, chain = sum[:32], sum[32:]
+	}
+	return key
+}
+
+// Derives the account at index from a BIP39 seed along 44'/165'/index',
+// the path hardware wallets and most light wallets use
+func KeypairFromBip39Seed(seed []byte, index uint32) (ed25519.PublicKey, ed25519.PrivateKey) {
+	key := DerivePath(seed, []uint32{44, NanoCoinType, index})
+	pub, priv, err := ed25519.GenerateKey(bytes.NewReader(key))
+	if err != nil {
+		panic("Unable to generate ed25519 key")
+	}
+	return pub, priv
+}
@@ -27,6 +27,7 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package blocks
 
 import (
+	"context"
 	"encoding/binary"
 	"encoding/hex"
 	"encoding/json"
@@ -37,7 +38,6 @@ import (
 	"github.com/svaishnavy/nano/address"
 	"github.com/svaishnavy/nano/types"
 	"github.com/svaishnavy/nano/uint128"
-	"github.com/svaishnavy/nano/utils"
 
 	// We've forked golang's ed25519 implementation
 	// to use blake2b instead of sha3
@@ -48,7 +48,9 @@ const LiveGenesisBlockHash types.BlockHash = "991CF190094C00F0B68E2E5F75F6BEE95A
 const LiveGenesisSourceHash types.BlockHash = "E89208DD038FBB269987689621D52292AE9C35941A7484756ECCED92A65093BA"
 
 var GenesisAmount uint128.Uint128 = uint128.FromInts(0xffffffffffffffff, 0xffffffffffffffff)
-var WorkThreshold = uint64(0xffffffc000000000)
+
+// The minimum difficulty for send and change blocks on the current network
+var WorkThreshold = Difficulty(0xffffffc000000000)
 
 const TestPrivateKey string = "34F0A37AAD20F4A260F0A5B3CB3D7FB50673212263E58A380BC10474BB039CE4"
 
@@ -86,6 +88,8 @@ type Block interface {
 	RootHash() types.BlockHash
 	Hash() types.BlockHash
 	PreviousBlockHash() types.BlockHash
+	IsConfirmed() bool
+	SetConfirmed(confirmed bool)
 }
 
 type CommonBlock struct {
@@ -154,7 +158,7 @@ func (b *OpenBlock) PreviousBlockHash() types.BlockHash {
 
 func (b *OpenBlock) RootHash() types.BlockHash {
 	pub, _ := address.AddressToPub(b.Account)
-	return types.BlockHash(hex.EncodeToString(pub))
+	return types.BlockHashFromBytes(pub)
 }
 
 func (b *ReceiveBlock) RootHash() types.BlockHash {
@@ -177,6 +181,14 @@ func (b *CommonBlock) GetWork() types.Work {
 	return b.Work
 }
 
+func (b *CommonBlock) IsConfirmed() bool {
+	return b.Confirmed
+}
+
+func (b *CommonBlock) SetConfirmed(confirmed bool) {
+	b.Confirmed = confirmed
+}
+
 func (*SendBlock) Type() BlockType {
 	return Send
 }
@@ -199,6 +211,16 @@ func (b *OpenBlock) VerifySignature() (bool, error) {
 	return res, nil
 }
 
+// Verifies a block was signed by the given account. Only open blocks carry
+// their account, so for the others the caller has to look it up.
+func VerifyBlockSignature(b Block, account types.Account) bool {
+	pub, err := address.AddressToPub(account)
+	if err != nil {
+		return false
+	}
+	return ed25519.Verify(pub, b.Hash().ToBytes(), b.GetSignature().ToBytes())
+}
+
 type RawBlock struct {
 	Type           BlockType
 	Source         types.BlockHash
@@ -333,44 +355,38 @@ func ValidateWork(block_hash []byte, work []byte) bool {
 	if err != nil {
 		panic("Unable to create hash")
 	}
-	return validateWork(hash, block_hash, work)
+	return validateWork(hash, block_hash, work, uint64(WorkThreshold))
 }
 
-func validateWork(digest hash.Hash, block []byte, work []byte) bool {
+func validateWork(digest hash.Hash, block []byte, work []byte, threshold uint64) bool {
 	digest.Reset()
 	digest.Write(work)
 	digest.Write(block)
 
 	sum := digest.Sum(nil)
-	return binary.LittleEndian.Uint64(sum) >= WorkThreshold
+	return binary.LittleEndian.Uint64(sum) >= threshold
 }
 
-func validateNonce(digest hash.Hash, block []byte, nonce uint64) bool {
+func validateNonce(digest hash.Hash, block []byte, nonce uint64, threshold uint64) bool {
 	b := make([]byte, 8)
 	binary.LittleEndian.PutUint64(b, nonce)
-	return validateWork(digest, block, b)
+	return validateWork(digest, block, b, threshold)
 }
 
+// Checks a block's work against the threshold for its type
 func ValidateBlockWork(b Block) bool {
-	hash_bytes := b.RootHash().ToBytes()
-	work_bytes, _ := hex.DecodeString(string(b.GetWork()))
-
-	res := ValidateWork(hash_bytes, utils.Reversed(work_bytes))
-	return res
+	return BlockDifficulty(b) >= WorkThresholdFor(b.Type())
 }
 
 func GenerateWorkForHash(b types.BlockHash) types.Work {
-	block_hash := b.ToBytes()
-	digest, err := blake2b.New(8, nil)
-	if err != nil {
-		panic("Unable to create hash")
-	}
-	var nonce uint64
-	for ; !validateNonce(digest, block_hash, nonce); nonce++ {
-	}
-	work := make([]byte, 8)
-	binary.BigEndian.PutUint64(work, nonce)
-	return types.Work(hex.EncodeToString(work))
+	return GenerateWorkForThreshold(b, WorkThreshold)
+}
+
+// Generates work against a threshold other than the current network's,
+// e.g. when creating the genesis block for a new network.
+func GenerateWorkForThreshold(b types.BlockHash, threshold Difficulty) types.Work {
+	work, _ := GenerateWorkContext(context.Background(), b, threshold, 0)
+	return work
 }
 
 func GenerateWork(b Block) types.Work {
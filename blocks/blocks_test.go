@@ -26,11 +26,16 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package blocks
 
 import (
+	"context"
 	"encoding/hex"
+	"math"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/golang/crypto/blake2b"
 	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/uint128"
 	"github.com/svaishnavy/nano/utils"
 )
 
@@ -60,6 +65,29 @@ func TestGenerateWork(t *testing.T) {
 	GenerateWork(LiveGenesisBlock)
 }
 
+func TestGenerateWorkContext(t *testing.T) {
+	WorkThreshold = 0xffffffc000000000
+	hash := LiveGenesisBlock.Hash()
+
+	work, err := GenerateWorkContext(context.Background(), hash, 0xfff0000000000000, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work_bytes, _ := hex.DecodeString(string(work))
+	digest, _ := blake2b.New(8, nil)
+	if !validateWork(digest, hash.ToBytes(), utils.Reversed(work_bytes), 0xfff0000000000000) {
+		t.Errorf("Generated invalid work %s", work)
+	}
+
+	// Nothing meets the maximum difficulty, so only cancelling stops it
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = GenerateWorkContext(ctx, hash, math.MaxUint64, 2)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected deadline exceeded, got %v", err)
+	}
+}
+
 func BenchmarkGenerateWork(b *testing.B) {
 	WorkThreshold = 0xfff0000000000000
 	for n := 0; n < b.N; n++ {
@@ -95,3 +123,84 @@ func TestHashOpen(t *testing.T) {
 		t.Errorf("Genesis block hash is not correct, expected %s, got %s", LiveGenesisBlockHash, LiveGenesisBlock.Hash())
 	}
 }
+
+func TestParseBlock(t *testing.T) {
+	send := SendBlock{
+		PreviousHash: TestGenesisBlock.Hash(),
+		Destination:  TestGenesisBlock.Account,
+		Balance:      uint128.FromInts(1, 2),
+		CommonBlock: CommonBlock{
+			Work:      "0000000000000000",
+			Signature: TestGenesisBlock.Signature,
+		},
+	}
+
+	data, err := ToJson(&send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := ParseBlock(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parsed.Hash() != send.Hash() || parsed.(*SendBlock).Balance != send.Balance {
+		t.Errorf("Block changed when encoded and parsed: %s", data)
+	}
+
+	invalid := []string{
+		`{"type": "state"}`,
+		`{"type": "receive", "previous": "1234", "source": "1234", "work": "0000000000000000", "signature": ""}`,
+		`{"type": "change", "previous": "` + string(TestGenesisBlock.Hash()) + `", "representative": "nano_1", "work": "0000000000000000", "signature": "` + string(TestGenesisBlock.Signature) + `"}`,
+		`not json`,
+	}
+	for _, s := range invalid {
+		if _, err := ParseBlock([]byte(s)); err == nil {
+			t.Errorf("Parsed invalid block %s", s)
+		}
+	}
+}
+
+func TestDifficulty(t *testing.T) {
+	WorkThreshold = 0xffffffc000000000
+	defer func() { ReceiveWorkMultiplier = 1 }()
+
+	d := BlockDifficulty(LiveGenesisBlock)
+	if d < WorkThreshold || d != WorkDifficulty(LiveGenesisBlock.RootHash(), LiveGenesisBlock.Work) {
+		t.Errorf("Wrong genesis difficulty %s", d)
+	}
+	if WorkDifficulty(LiveGenesisBlock.RootHash(), "not work") != 0 {
+		t.Errorf("Malformed work has a difficulty")
+	}
+
+	parsed, err := ParseDifficulty(d.String())
+	if err != nil || parsed != d {
+		t.Errorf("Difficulty %s parsed as %s", d, parsed)
+	}
+	if _, err := ParseDifficulty("ffff"); err == nil {
+		t.Errorf("Parsed a short difficulty")
+	}
+
+	// Values from the reference node's epoch 2 thresholds
+	send := Difficulty(0xfffffff800000000)
+	receive := Difficulty(0xfffffe0000000000)
+	if m := send.Multiplier(WorkThreshold); m != 8 {
+		t.Errorf("Wrong multiplier %g", m)
+	}
+	if m := receive.Multiplier(send); m != 1.0/64 {
+		t.Errorf("Wrong multiplier %g", m)
+	}
+	if FromMultiplier(8, WorkThreshold) != send || FromMultiplier(1.0/64, send) != receive {
+		t.Errorf("Multipliers don't round trip")
+	}
+	if FromMultiplier(1e-30, WorkThreshold) != 0 {
+		t.Errorf("Tiny multiplier doesn't give the lowest difficulty")
+	}
+
+	ReceiveWorkMultiplier = 1.0 / 8
+	if WorkThresholdFor(Send) != WorkThreshold || WorkThresholdFor(Change) != WorkThreshold {
+		t.Errorf("Wrong send threshold")
+	}
+	if WorkThresholdFor(Open) != 0xfffffe0000000000 || WorkThresholdFor(Receive) != WorkThresholdFor(Open) {
+		t.Errorf("Wrong receive threshold %s", WorkThresholdFor(Receive))
+	}
+}
@@ -0,0 +1,129 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package blocks
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+
+	"github.com/golang/crypto/blake2b"
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/utils"
+)
+
+// The value work hashes to. Work is valid when its difficulty is at least
+// the threshold for its block.
+type Difficulty uint64
+
+// Formats the difficulty as 16 hex digits, as the reference node does
+func (d Difficulty) String() string {
+	return fmt.Sprintf("%016x", uint64(d))
+}
+
+func ParseDifficulty(s string) (Difficulty, error) {
+	d, err := strconv.ParseUint(s, 16, 64)
+	if err != nil || len(s) != 16 {
+		return 0, errors.Errorf("Invalid difficulty %s", s)
+	}
+	return Difficulty(d), nil
+}
+
+// How far the difficulty is from the maximum, 2^64 - d, which is
+// inversely proportional to how much work it takes to reach.
+func (d Difficulty) distance() float64 {
+	if d == 0 {
+		return math.Exp2(64)
+	}
+	return float64(-d)
+}
+
+// How many times harder it is to find work at this difficulty than at base
+func (d Difficulty) Multiplier(base Difficulty) float64 {
+	return base.distance() / d.distance()
+}
+
+// Returns the difficulty which is multiplier times harder to reach than
+// base. Multipliers below one give an easier difficulty.
+func FromMultiplier(multiplier float64, base Difficulty) Difficulty {
+	if multiplier == 1 {
+		return base
+	}
+	if multiplier <= 0 {
+		return 0
+	}
+	distance := base.distance() / multiplier
+	if distance >= math.Exp2(64) {
+		return 0
+	}
+	if distance < 1 {
+		distance = 1
+	}
+	return Difficulty(-uint64(distance))
+}
+
+// Computes the difficulty of work for a root. Returns zero for malformed
+// work, which never meets a threshold.
+func WorkDifficulty(root types.BlockHash, work types.Work) Difficulty {
+	work_bytes, err := hex.DecodeString(string(work))
+	if err != nil || len(work_bytes) != 8 {
+		return 0
+	}
+	digest, err := blake2b.New(8, nil)
+	if err != nil {
+		panic("Unable to create hash")
+	}
+	digest.Write(utils.Reversed(work_bytes))
+	digest.Write(root.ToBytes())
+	return Difficulty(binary.LittleEndian.Uint64(digest.Sum(nil)))
+}
+
+// The difficulty of a block's work
+func BlockDifficulty(b Block) Difficulty {
+	return WorkDifficulty(b.RootHash(), b.GetWork())
+}
+
+// Receiving is cheaper than sending, so open and receive blocks only need
+// work this many times easier than WorkThreshold. The live network doesn't
+// have a separate receive threshold.
+var ReceiveWorkMultiplier = 1.0
+
+func ReceiveWorkThreshold() Difficulty {
+	return FromMultiplier(ReceiveWorkMultiplier, WorkThreshold)
+}
+
+// The minimum difficulty for a type of block on the current network
+func WorkThresholdFor(t BlockType) Difficulty {
+	switch t {
+	case Open, Receive:
+		return ReceiveWorkThreshold()
+	default:
+		return WorkThreshold
+	}
+}
@@ -0,0 +1,153 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package blocks
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+// The JSON form of a block used by the reference node, with the balance
+// as a 32 character hex string
+type jsonBlock struct {
+	Type           BlockType       `json:"type"`
+	Previous       types.BlockHash `json:"previous,omitempty"`
+	Source         types.BlockHash `json:"source,omitempty"`
+	Representative types.Account   `json:"representative,omitempty"`
+	Account        types.Account   `json:"account,omitempty"`
+	Destination    types.Account   `json:"destination,omitempty"`
+	Balance        string          `json:"balance,omitempty"`
+	Work           types.Work      `json:"work"`
+	Signature      types.Signature `json:"signature"`
+}
+
+func validHex(s string, length int) bool {
+	_, err := hex.DecodeString(s)
+	return err == nil && len(s) == length
+}
+
+// Parses a block in the reference node's JSON format, checking every field
+// is well formed so the block can be safely hashed.
+func ParseBlock(data []byte) (Block, error) {
+	var j jsonBlock
+	err := json.Unmarshal(data, &j)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid block json")
+	}
+
+	var hashes []types.BlockHash
+	var accounts []types.Account
+	switch j.Type {
+	case Open:
+		hashes = []types.BlockHash{j.Source}
+		accounts = []types.Account{j.Representative, j.Account}
+	case Send:
+		hashes = []types.BlockHash{j.Previous}
+		accounts = []types.Account{j.Destination}
+	case Receive:
+		hashes = []types.BlockHash{j.Previous, j.Source}
+	case Change:
+		hashes = []types.BlockHash{j.Previous}
+		accounts = []types.Account{j.Representative}
+	default:
+		return nil, errors.Errorf("Unknown block type %s", j.Type)
+	}
+
+	for _, hash := range hashes {
+		if !validHex(string(hash), 64) {
+			return nil, errors.Errorf("Invalid block hash %s", hash)
+		}
+	}
+	for _, account := range accounts {
+		if !address.ValidateAddress(account) {
+			return nil, errors.Errorf("Invalid account %s", account)
+		}
+	}
+	if !validHex(string(j.Work), 16) {
+		return nil, errors.New("Invalid block work")
+	}
+	if !validHex(string(j.Signature), 128) {
+		return nil, errors.New("Invalid block signature")
+	}
+
+	common := CommonBlock{
+		Work:      j.Work,
+		Signature: types.Signature(strings.ToUpper(string(j.Signature))),
+	}
+	previous := types.BlockHash(strings.ToUpper(string(j.Previous)))
+	source := types.BlockHash(strings.ToUpper(string(j.Source)))
+
+	switch j.Type {
+	case Open:
+		return &OpenBlock{SourceHash: source, Representative: j.Representative, Account: j.Account, CommonBlock: common}, nil
+	case Send:
+		balance, err := uint128.FromString(j.Balance)
+		if err != nil || len(j.Balance) != 32 {
+			return nil, errors.Errorf("Invalid balance %s", j.Balance)
+		}
+		return &SendBlock{PreviousHash: previous, Destination: j.Destination, Balance: balance, CommonBlock: common}, nil
+	case Receive:
+		return &ReceiveBlock{PreviousHash: previous, SourceHash: source, CommonBlock: common}, nil
+	default:
+		return &ChangeBlock{PreviousHash: previous, Representative: j.Representative, CommonBlock: common}, nil
+	}
+}
+
+// Encodes a block in the reference node's JSON format
+func ToJson(block Block) ([]byte, error) {
+	j := jsonBlock{
+		Type:      block.Type(),
+		Work:      block.GetWork(),
+		Signature: block.GetSignature(),
+	}
+
+	switch b := block.(type) {
+	case *OpenBlock:
+		j.Source = b.SourceHash
+		j.Representative = b.Representative
+		j.Account = b.Account
+	case *SendBlock:
+		j.Previous = b.PreviousHash
+		j.Destination = b.Destination
+		j.Balance = strings.ToUpper(b.Balance.String())
+	case *ReceiveBlock:
+		j.Previous = b.PreviousHash
+		j.Source = b.SourceHash
+	case *ChangeBlock:
+		j.Previous = b.PreviousHash
+		j.Representative = b.Representative
+	default:
+		return nil, errors.Errorf("Unknown block type %s", block.Type())
+	}
+
+	return json.MarshalIndent(j, "", "    ")
+}
@@ -0,0 +1,104 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package blocks
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"math"
+	"runtime"
+	"time"
+
+	"github.com/golang/crypto/blake2b"
+	"github.com/svaishnavy/nano/types"
+)
+
+// Called with how long each successful work generation took, e.g. to
+// record it as a metric
+var WorkGenerated = func(time.Duration) {}
+
+// How many nonces a worker tries between checks for cancellation
+const workCheckInterval = 1 << 12
+
+// Generates work for a hash which meets the given difficulty threshold. The
+// nonce space is split between the given number of goroutines, or one per
+// CPU if workers is 0, each starting from a random offset. Returns as soon as any
+// worker finds valid work, or with ctx's error if it's done first.
+func GenerateWorkContext(ctx context.Context, hash types.BlockHash, difficulty Difficulty, workers int) (types.Work, error) {
+	if workers <= 0 {
+		workers = runtime.NumCPU()
+	}
+	start := time.Now()
+
+	var offset [8]byte
+	_, err := rand.Read(offset[:])
+	if err != nil {
+		return "", err
+	}
+	base := binary.LittleEndian.Uint64(offset[:])
+	stride := math.MaxUint64 / uint64(workers)
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	found := make(chan uint64, workers)
+	hash_bytes := hash.ToBytes()
+
+	for i := 0; i < workers; i++ {
+		go searchNonces(ctx, hash_bytes, uint64(difficulty), base+uint64(i)*stride, found)
+	}
+
+	select {
+	case nonce := <-found:
+		WorkGenerated(time.Since(start))
+		work := make([]byte, 8)
+		binary.BigEndian.PutUint64(work, nonce)
+		return types.Work(hex.EncodeToString(work)), nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+func searchNonces(ctx context.Context, block []byte, difficulty uint64, nonce uint64, found chan<- uint64) {
+	digest, err := blake2b.New(8, nil)
+	if err != nil {
+		panic("Unable to create hash")
+	}
+
+	for {
+		for i := 0; i < workCheckInterval; i++ {
+			if validateNonce(digest, block, nonce, difficulty) {
+				found <- nonce
+				return
+			}
+			nonce++
+		}
+		if ctx.Err() != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,363 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package callback
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/logging"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+)
+
+var logger = logging.New("callback")
+
+// Defaults for new callbacks
+const (
+	DefaultMaxQueued      = 10000
+	DefaultMaxAttempts    = 10
+	DefaultInitialBackoff = time.Second
+	DefaultMaxBackoff     = 5 * time.Minute
+	requestTimeout        = 30 * time.Second
+	// Confirmed blocks waiting to be written to the outbox
+	confirmedQueueSize = 1024
+)
+
+// The JSON posted for each confirmed block. As in the reference node the
+// block is sent as a JSON encoded string.
+type Payload struct {
+	Account types.Account   `json:"account"`
+	Hash    types.BlockHash `json:"hash"`
+	Block   string          `json:"block"`
+	Amount  string          `json:"amount"`
+	Subtype string          `json:"subtype"`
+}
+
+// Posts confirmed blocks to a URL. Payloads are written to an outbox
+// directory first, so they survive restarts and failed deliveries, and are
+// delivered in order. Confirmations are written out in the background, so
+// the elections reporting them never wait on the disk.
+type Callback struct {
+	URL string
+	Dir string
+	// The oldest payloads are dropped once the outbox holds this many
+	MaxQueued int
+	// A payload is dropped after failing to deliver this many times
+	MaxAttempts int
+	// Delay before retrying a failed delivery, doubling on each failure
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	Client         *http.Client
+
+	mutex    sync.Mutex
+	sequence int64
+	// The outbox oldest first, kept in step with the directory
+	names     []string
+	attempts  map[string]int
+	confirmed chan blocks.Block
+	wake      chan struct{}
+	done      chan struct{}
+	stopped   sync.WaitGroup
+	unobserve func()
+}
+
+// Creates a callback posting to url with its outbox in dir, and starts
+// observing confirmations
+func New(url string, dir string) (*Callback, error) {
+	err := os.MkdirAll(dir, 0700)
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to create callback outbox")
+	}
+
+	c := &Callback{
+		URL:            url,
+		Dir:            dir,
+		MaxQueued:      DefaultMaxQueued,
+		MaxAttempts:    DefaultMaxAttempts,
+		InitialBackoff: DefaultInitialBackoff,
+		MaxBackoff:     DefaultMaxBackoff,
+		Client:         &http.Client{Timeout: requestTimeout},
+		attempts:       make(map[string]int),
+		confirmed:      make(chan blocks.Block, confirmedQueueSize),
+		wake:           make(chan struct{}, 1),
+	}
+	c.names, err = c.queued()
+	if err != nil {
+		return nil, errors.Wrap(err, "Failed to read callback outbox")
+	}
+	c.unobserve = node.ActiveElections.Observe(c.BlockConfirmed)
+	return c, nil
+}
+
+// Queues a confirmed block to be written to the outbox once the callback is
+// started. Never blocks, as it's called while votes are being counted.
+func (c *Callback) BlockConfirmed(block blocks.Block) {
+	select {
+	case c.confirmed <- block:
+	default:
+		logger.Errorf("Callback queue is full, dropping %s", block.Hash())
+	}
+}
+
+func (c *Callback) enqueueBlock(block blocks.Block) {
+	contents, err := blocks.ToJson(block)
+	if err != nil {
+		return
+	}
+
+	amount := store.GetAmount(block)
+	err = c.Enqueue(Payload{
+		Account: store.FetchBlockAccount(block),
+		Hash:    block.Hash(),
+		Block:   string(contents),
+		Amount:  amount.Decimal(),
+		Subtype: string(block.Type()),
+	})
+	if err != nil {
+		logger.Errorf("Failed to queue callback for %s: %s", block.Hash(), err)
+	}
+}
+
+// Lists the outbox oldest first
+func (c *Callback) queued() ([]string, error) {
+	files, err := ioutil.ReadDir(c.Dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for _, f := range files {
+		if strings.HasSuffix(f.Name(), ".json") {
+			names = append(names, f.Name())
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// Writes a payload to the outbox for delivery
+func (c *Callback) Enqueue(p Payload) error {
+	data, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	// Names sort in the order payloads were queued
+	sequence := time.Now().UnixNano()
+	if sequence <= c.sequence {
+		sequence = c.sequence + 1
+	}
+	c.sequence = sequence
+	name := fmt.Sprintf("%020d.json", sequence)
+
+	tmp := filepath.Join(c.Dir, name+".tmp")
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	err = os.Rename(tmp, filepath.Join(c.Dir, name))
+	if err != nil {
+		return err
+	}
+
+	c.names = append(c.names, name)
+	for len(c.names) > c.MaxQueued {
+		logger.Warnf("Callback outbox full, dropping %s", c.names[0])
+		c.remove(c.names[0])
+	}
+
+	select {
+	case c.wake <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+func (c *Callback) remove(name string) {
+	for i, n := range c.names {
+		if n == name {
+			c.names = append(c.names[:i], c.names[i+1:]...)
+			break
+		}
+	}
+	delete(c.attempts, name)
+	os.Remove(filepath.Join(c.Dir, name))
+}
+
+// Starts delivering queued payloads in the background
+func (c *Callback) Start() {
+	if c.unobserve == nil {
+		c.unobserve = node.ActiveElections.Observe(c.BlockConfirmed)
+	}
+	c.done = make(chan struct{})
+	c.stopped.Add(2)
+	go c.write()
+	go c.run()
+}
+
+// Stops observing confirmations and delivery. Undelivered payloads stay in
+// the outbox for next time.
+func (c *Callback) Stop() {
+	if c.unobserve != nil {
+		c.unobserve()
+		c.unobserve = nil
+	}
+	if c.done == nil {
+		return
+	}
+	close(c.done)
+	c.stopped.Wait()
+	c.done = nil
+}
+
+// Writes confirmed blocks to the outbox, finishing the ones already queued
+// when stopped
+func (c *Callback) write() {
+	defer c.stopped.Done()
+	for {
+		select {
+		case block := <-c.confirmed:
+			c.enqueueBlock(block)
+		case <-c.done:
+			for {
+				select {
+				case block := <-c.confirmed:
+					c.enqueueBlock(block)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+func (c *Callback) run() {
+	defer c.stopped.Done()
+	backoff := time.Duration(0)
+
+	for {
+		select {
+		case <-c.done:
+			return
+		default:
+		}
+
+		delivered, err := c.deliverNext()
+		if delivered {
+			backoff = 0
+			continue
+		}
+
+		// Wait for something new to deliver, or after a failure for the
+		// backoff to expire
+		wake := c.wake
+		var retry <-chan time.Time
+		if err != nil {
+			logger.Warnf("Callback to %s failed: %s", c.URL, err)
+			backoff *= 2
+			if backoff < c.InitialBackoff {
+				backoff = c.InitialBackoff
+			}
+			if backoff > c.MaxBackoff {
+				backoff = c.MaxBackoff
+			}
+			wake = nil
+			retry = time.After(backoff)
+		} else {
+			backoff = 0
+		}
+
+		select {
+		case <-c.done:
+			return
+		case <-wake:
+		case <-retry:
+		}
+	}
+}
+
+// Posts the oldest queued payload, returning false if there was nothing to
+// deliver
+func (c *Callback) deliverNext() (bool, error) {
+	c.mutex.Lock()
+	if len(c.names) == 0 {
+		c.mutex.Unlock()
+		return false, nil
+	}
+	name := c.names[0]
+	c.mutex.Unlock()
+
+	data, err := ioutil.ReadFile(filepath.Join(c.Dir, name))
+	if err != nil {
+		return false, err
+	}
+
+	err = c.post(data)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if err == nil {
+		c.remove(name)
+		return true, nil
+	}
+
+	c.attempts[name]++
+	if c.attempts[name] >= c.MaxAttempts {
+		logger.Errorf("Giving up on callback %s after %d attempts", name, c.attempts[name])
+		c.remove(name)
+	}
+	return false, err
+}
+
+func (c *Callback) post(data []byte) error {
+	resp, err := c.Client.Post(c.URL, "application/json", bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	ioutil.ReadAll(resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("Unexpected status %s", resp.Status)
+	}
+	return nil
+}
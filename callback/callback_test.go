@@ -0,0 +1,220 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package callback
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+type receiver struct {
+	mutex    sync.Mutex
+	failures int
+	payloads []Payload
+	received chan bool
+}
+
+func (r *receiver) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.failures > 0 {
+		r.failures--
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+
+	var p Payload
+	json.NewDecoder(req.Body).Decode(&p)
+	r.payloads = append(r.payloads, p)
+	r.received <- true
+}
+
+func testCallback(t *testing.T, url string) (*Callback, string) {
+	dir, err := ioutil.TempDir("", "nano-callback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := New(url, dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.InitialBackoff = 10 * time.Millisecond
+	return c, dir
+}
+
+func TestDeliveryWithRetries(t *testing.T) {
+	r := &receiver{failures: 2, received: make(chan bool, 10)}
+	server := httptest.NewServer(r)
+	defer server.Close()
+
+	c, dir := testCallback(t, server.URL)
+	defer os.RemoveAll(dir)
+
+	// Queued before starting, as if left over from a previous run
+	c.Enqueue(Payload{Hash: "1"})
+	c.Start()
+	defer c.Stop()
+	c.Enqueue(Payload{Hash: "2"})
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-r.received:
+		case <-time.After(5 * time.Second):
+			t.Fatalf("Timed out waiting for callback")
+		}
+	}
+
+	c.Stop()
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.payloads[0].Hash != "1" || r.payloads[1].Hash != "2" {
+		t.Errorf("Callbacks delivered out of order: %v", r.payloads)
+	}
+	if names, _ := c.queued(); len(names) != 0 {
+		t.Errorf("Delivered callbacks left in outbox: %v", names)
+	}
+}
+
+func TestOutboxBounds(t *testing.T) {
+	c, dir := testCallback(t, "http://127.0.0.1:1")
+	defer os.RemoveAll(dir)
+	c.MaxQueued = 2
+	c.MaxAttempts = 1
+
+	for _, hash := range []types.BlockHash{"1", "2", "3"} {
+		c.Enqueue(Payload{Hash: hash})
+	}
+	names, _ := c.queued()
+	if len(names) != 2 {
+		t.Errorf("Outbox holds %d payloads", len(names))
+	}
+
+	delivered, err := c.deliverNext()
+	if delivered || err == nil {
+		t.Errorf("Delivered to an unreachable server")
+	}
+	if names, _ = c.queued(); len(names) != 1 {
+		t.Errorf("Payload wasn't dropped after its last attempt")
+	}
+
+	// The outbox is picked up again after a restart
+	if reopened, err := New(c.URL, dir); err != nil || len(reopened.names) != 1 || reopened.names[0] != names[0] {
+		t.Errorf("Outbox wasn't reloaded, %v", err)
+	}
+}
+
+func TestBlockConfirmedPayload(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	defer os.RemoveAll(store.TestConfig.Path)
+
+	r := &receiver{received: make(chan bool, 10)}
+	server := httptest.NewServer(r)
+	defer server.Close()
+	c, dir := testCallback(t, server.URL)
+	defer os.RemoveAll(dir)
+	c.Start()
+	defer c.Stop()
+
+	genesis := blocks.TestGenesisBlock
+	send := &blocks.SendBlock{
+		PreviousHash: genesis.Hash(),
+		Destination:  genesis.Account,
+		Balance:      blocks.GenesisAmount.Sub(uint128.FromInts(0, 42)),
+	}
+	send.Work = blocks.GenerateWork(genesis)
+	_, priv := address.KeypairFromPrivateKey(blocks.TestPrivateKey)
+	send.Signature = send.Hash().Sign(priv)
+	store.StoreBlock(send)
+	c.BlockConfirmed(send)
+
+	select {
+	case <-r.received:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for callback")
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	p := r.payloads[0]
+	if p.Hash != send.Hash() || p.Account != genesis.Account || p.Amount != "42" || p.Subtype != "send" {
+		t.Errorf("Wrong payload %+v", p)
+	}
+	if block, err := blocks.ParseBlock([]byte(p.Block)); err != nil || block.Hash() != send.Hash() {
+		t.Errorf("Payload block doesn't match %s", p.Block)
+	}
+}
+
+func TestStopUnobserves(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	defer os.RemoveAll(store.TestConfig.Path)
+	node.ActiveElections = node.NewElections()
+	rep := node.NewRepresentative(blocks.TestPrivateKey)
+
+	c, dir := testCallback(t, "http://127.0.0.1:1")
+	defer os.RemoveAll(dir)
+	c.Stop()
+
+	genesis := blocks.TestGenesisBlock
+	send := &blocks.SendBlock{
+		PreviousHash: genesis.Hash(),
+		Destination:  genesis.Account,
+		Balance:      blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)),
+	}
+	send.Work = blocks.GenerateWork(genesis)
+	_, priv := address.KeypairFromPrivateKey(blocks.TestPrivateKey)
+	send.Signature = send.Hash().Sign(priv)
+	store.StoreBlock(send)
+	node.ActiveElections.Start(send)
+	vote, err := rep.Vote(send)
+	if err != nil {
+		t.Fatal(err)
+	}
+	confirmed := node.ActiveElections.WaitFor(send.Hash())
+	node.ActiveElections.Vote(&vote.MessageVote)
+	if !<-confirmed {
+		t.Fatalf("Block wasn't confirmed")
+	}
+
+	if len(c.confirmed) != 0 {
+		t.Errorf("Stopped callback still observes confirmations")
+	}
+}
@@ -0,0 +1,73 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/types"
+)
+
+func accountKey(args []string, out io.Writer) error {
+	flags := newFlags("account", "key")
+	_, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Expected an account")
+	}
+
+	pub, err := address.AddressToPub(types.Account(flags.Arg(0)))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, strings.ToUpper(hex.EncodeToString(pub)))
+	return nil
+}
+
+func accountAddress(args []string, out io.Writer) error {
+	flags := newFlags("account", "address")
+	_, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Expected a public key")
+	}
+
+	pub, err := hex.DecodeString(flags.Arg(0))
+	if err != nil || len(pub) != 32 {
+		return errors.Errorf("Invalid public key %s", flags.Arg(0))
+	}
+	fmt.Fprintln(out, address.PubKeyToAddress(pub))
+	return nil
+}
@@ -0,0 +1,99 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/node"
+)
+
+// Reads a block given as JSON or as a hex encoded publish, confirm_req or
+// confirm_ack message
+func decodeBlock(input string) (blocks.Block, error) {
+	if strings.HasPrefix(input, "{") {
+		return blocks.ParseBlock([]byte(input))
+	}
+
+	data, err := hex.DecodeString(strings.Join(strings.Fields(input), ""))
+	if err != nil {
+		return nil, errors.New("Input is neither JSON nor a hex message")
+	}
+	return node.ReadBlockMessage(bytes.NewBuffer(data))
+}
+
+func blockDecode(args []string, out io.Writer) error {
+	flags := newFlags("block", "decode")
+	_, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+	input, err := readInput(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	block, err := decodeBlock(input)
+	if err != nil {
+		return err
+	}
+	data, err := blocks.ToJson(block)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Type: %s\n", block.Type())
+	fmt.Fprintf(out, "Hash: %s\n", block.Hash())
+	fmt.Fprintf(out, "Root: %s\n", block.RootHash())
+	fmt.Fprintf(out, "Valid work: %t\n", blocks.ValidateBlockWork(block))
+	fmt.Fprintf(out, "%s\n", data)
+	return nil
+}
+
+func blockHash(args []string, out io.Writer) error {
+	flags := newFlags("block", "hash")
+	_, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+	input, err := readInput(flags.Args())
+	if err != nil {
+		return err
+	}
+
+	block, err := blocks.ParseBlock([]byte(input))
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, block.Hash())
+	return nil
+}
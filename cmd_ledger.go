@@ -0,0 +1,216 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+)
+
+func ledgerStats(args []string, out io.Writer) error {
+	cfg, net, err := setup(newFlags("ledger", "stats"), args)
+	if err != nil {
+		return err
+	}
+	err = openLedger(cfg, net)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	count, unchecked := store.BlockCount()
+	fmt.Fprintf(out, "Network: %s\n", net.Name)
+	fmt.Fprintf(out, "Blocks: %d\n", count)
+	fmt.Fprintf(out, "Unchecked: %d\n", unchecked)
+	fmt.Fprintf(out, "Accounts: %d\n", len(store.Accounts()))
+	fmt.Fprintf(out, "Representatives: %d\n", len(store.Representatives()))
+	fmt.Fprintf(out, "Size: %d bytes\n", store.Size())
+	return nil
+}
+
+func sortedAccounts(accounts map[types.Account]store.AccountInfo) []types.Account {
+	sorted := make([]types.Account, 0, len(accounts))
+	for account := range accounts {
+		sorted = append(sorted, account)
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+	return sorted
+}
+
+// Returns an account's blocks from its open block to its head
+func accountChain(info store.AccountInfo) ([]blocks.Block, error) {
+	var chain []blocks.Block
+	hash := info.Head
+	for {
+		block := store.FetchBlock(hash)
+		if block == nil {
+			return chain, errors.Errorf("Missing block %s", hash)
+		}
+		chain = append(chain, block)
+		if block.Type() == blocks.Open {
+			break
+		}
+		if uint64(len(chain)) > info.BlockCount {
+			return chain, errors.Errorf("Chain is longer than its block count %d", info.BlockCount)
+		}
+		hash = block.PreviousBlockHash()
+	}
+
+	for i, j := 0, len(chain)-1; i < j; i, j = i+1, j-1 {
+		chain[i], chain[j] = chain[j], chain[i]
+	}
+	return chain, nil
+}
+
+// Checks an account chain's blocks and that it matches the account's
+// summary, returning each problem found
+func checkAccount(account types.Account, info store.AccountInfo, genesis types.BlockHash) []string {
+	chain, err := accountChain(info)
+	if err != nil {
+		return []string{err.Error()}
+	}
+
+	var problems []string
+	for _, block := range chain {
+		if !blocks.ValidateBlockWork(block) {
+			problems = append(problems, fmt.Sprintf("Invalid work for block %s", block.Hash()))
+		}
+		if !blocks.VerifyBlockSignature(block, account) {
+			problems = append(problems, fmt.Sprintf("Invalid signature for block %s", block.Hash()))
+		}
+
+		var source types.BlockHash
+		switch b := block.(type) {
+		case *blocks.OpenBlock:
+			source = b.SourceHash
+		case *blocks.ReceiveBlock:
+			source = b.SourceHash
+		}
+		if source == "" || block.Hash() == genesis {
+			continue
+		}
+		send, ok := store.FetchBlock(source).(*blocks.SendBlock)
+		if !ok || send.Destination != account {
+			problems = append(problems, fmt.Sprintf("Block %s receives an invalid source %s", block.Hash(), source))
+		}
+	}
+
+	if chain[0].Hash() != info.Open {
+		problems = append(problems, fmt.Sprintf("Chain opens with %s, expected %s", chain[0].Hash(), info.Open))
+	}
+	if uint64(len(chain)) != info.BlockCount {
+		problems = append(problems, fmt.Sprintf("Chain has %d blocks, expected %d", len(chain), info.BlockCount))
+	}
+	if balance := store.GetBalance(chain[len(chain)-1]); balance != info.Balance {
+		problems = append(problems, fmt.Sprintf("Chain balance is %s, expected %s", balance.Decimal(), info.Balance.Decimal()))
+	}
+	return problems
+}
+
+func ledgerCheck(args []string, out io.Writer) error {
+	cfg, net, err := setup(newFlags("ledger", "check"), args)
+	if err != nil {
+		return err
+	}
+	err = openLedger(cfg, net)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	accounts := store.Accounts()
+	failed := 0
+	for _, account := range sortedAccounts(accounts) {
+		problems := checkAccount(account, accounts[account], net.GenesisBlock.Hash())
+		for _, problem := range problems {
+			fmt.Fprintf(out, "%s: %s\n", account, problem)
+		}
+		if len(problems) > 0 {
+			failed++
+		}
+	}
+
+	fmt.Fprintf(out, "Checked %d accounts\n", len(accounts))
+	if failed > 0 {
+		return errors.Errorf("%d accounts have problems", failed)
+	}
+	return nil
+}
+
+func ledgerExport(args []string, out io.Writer) error {
+	flags := newFlags("ledger", "export")
+	path := flags.String("out", "", "File to write to, by default stdout")
+	cfg, net, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *path != "" {
+		file, err := os.Create(*path)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		out = file
+	}
+
+	err = openLedger(cfg, net)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	accounts := store.Accounts()
+	for _, account := range sortedAccounts(accounts) {
+		chain, err := accountChain(accounts[account])
+		if err != nil {
+			return errors.Wrapf(err, "Failed to export %s", account)
+		}
+		for _, block := range chain {
+			data, err := blocks.ToJson(block)
+			if err != nil {
+				return err
+			}
+			var line bytes.Buffer
+			json.Compact(&line, data)
+			line.WriteByte('\n')
+			_, err = out.Write(line.Bytes())
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
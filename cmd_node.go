@@ -0,0 +1,169 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package main
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/callback"
+	"github.com/svaishnavy/nano/config"
+	"github.com/svaishnavy/nano/metrics"
+	"github.com/svaishnavy/nano/network"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/rpc"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/ws"
+)
+
+// Opens the ledger and starts a node on it, which stops when ctx is
+// cancelled
+func startNode(ctx context.Context, cfg config.Config, net *network.Network) (*node.Node, error) {
+	node.PreconfiguredPeers = cfg.Peers
+	node.UseNetwork(net)
+
+	err := openLedger(cfg, net)
+	if err != nil {
+		return nil, err
+	}
+
+	nano_node := node.NewNode()
+	nano_node.ListenAddr = cfg.UdpAddr()
+	if cfg.EnablePeerCache {
+		nano_node.PeersFile = filepath.Join(cfg.DataDir, "peers.json")
+	}
+
+	if cfg.EnableVoting && cfg.RepresentativeKey != "" {
+		node.SetRepresentative(cfg.RepresentativeKey)
+	}
+
+	err = nano_node.Start(ctx)
+	if err != nil {
+		store.Close()
+		return nil, errors.Wrap(err, "Failed to start node")
+	}
+	return nano_node, nil
+}
+
+// Cancels the returned context on SIGINT or SIGTERM
+func signalContext() (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		select {
+		case sig := <-signals:
+			logger.Infof("Received %s, shutting down", sig)
+			cancel()
+		case <-ctx.Done():
+		}
+		signal.Stop(signals)
+	}()
+	return ctx, cancel
+}
+
+func runNode(args []string, out io.Writer) error {
+	cfg, net, err := setup(newFlags("node", "run"), args)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	nano_node, err := startNode(ctx, cfg, net)
+	if err != nil {
+		return err
+	}
+
+	var rpcServer *rpc.Server
+	var wsServer *ws.Server
+	var metricsServer *metrics.Server
+	var confirmationCallback *callback.Callback
+
+	// Stops whatever has been started, so the store is closed cleanly even
+	// if one of the servers fails to start
+	shutdown := func() error {
+		if rpcServer != nil {
+			rpcServer.Stop()
+		}
+		if wsServer != nil {
+			wsServer.Stop()
+		}
+		if metricsServer != nil {
+			metricsServer.Stop()
+		}
+		if confirmationCallback != nil {
+			confirmationCallback.Stop()
+		}
+		nano_node.Stop()
+		return errors.Wrap(store.Close(), "Failed to close store")
+	}
+
+	if cfg.EnableRpc {
+		rpcServer = rpc.NewServer(nano_node, cfg.RpcAddress)
+		err = rpcServer.Start()
+		if err != nil {
+			shutdown()
+			return errors.Wrap(err, "Failed to start rpc server")
+		}
+	}
+
+	if cfg.EnableWebsocket {
+		wsServer = ws.NewServer(cfg.WebsocketAddress)
+		err = wsServer.Start()
+		if err != nil {
+			shutdown()
+			return errors.Wrap(err, "Failed to start websocket server")
+		}
+	}
+
+	if cfg.EnableMetrics {
+		metricsServer = metrics.NewServer(cfg.MetricsAddress)
+		err = metricsServer.Start()
+		if err != nil {
+			shutdown()
+			return errors.Wrap(err, "Failed to start metrics server")
+		}
+	}
+
+	if cfg.CallbackUrl != "" {
+		confirmationCallback, err = callback.New(cfg.CallbackUrl, filepath.Join(cfg.DataDir, "callbacks"))
+		if err != nil {
+			shutdown()
+			return errors.Wrap(err, "Failed to create callback")
+		}
+		confirmationCallback.Start()
+	}
+
+	<-nano_node.Done()
+	return shutdown()
+}
@@ -0,0 +1,571 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package main
+
+import (
+	"bufio"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/config"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+	"github.com/svaishnavy/nano/wallet"
+)
+
+func walletPath(cfg config.Config) string {
+	return filepath.Join(cfg.DataDir, "wallet.json")
+}
+
+// Loads the work cache kept next to the wallet, so work precomputed by an
+// earlier command isn't generated again
+func loadWorkCache(cfg config.Config) error {
+	cache, err := wallet.LoadWorkCache(filepath.Join(cfg.DataDir, "work_cache.json"))
+	if err != nil {
+		return err
+	}
+	wallet.DefaultCache = cache
+	return nil
+}
+
+var stdin = bufio.NewReader(os.Stdin)
+
+// Reads a password from the environment variable, or a line of stdin if
+// it isn't set
+func readPassword(env string, prompt string) (string, error) {
+	if password := os.Getenv(env); password != "" {
+		return password, nil
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	line, err := stdin.ReadString('\n')
+	if err != nil && line == "" {
+		return "", errors.Wrap(err, "Failed to read password")
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// Opens and unlocks the wallet's keystore, creating it if create is set
+// and there isn't one yet
+func openKeystore(cfg config.Config, create bool) (*wallet.Keystore, error) {
+	path := walletPath(cfg)
+	password, err := readPassword("NANO_WALLET_PASSWORD", "Wallet password: ")
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		if !create {
+			return nil, errors.Errorf("No wallet at %s, create one with nano wallet create", path)
+		}
+		return wallet.CreateKeystore(path, password)
+	}
+	keystore, err := wallet.OpenKeystore(path)
+	if err != nil {
+		return nil, err
+	}
+	return keystore, keystore.Unlock(password, 0)
+}
+
+// Loads every account in the keystore: its ad hoc keys, then each seed's
+// accounts up to the last one used in the ledger. Heads are set to the
+// accounts' frontiers.
+func walletAccounts(keystore *wallet.Keystore) ([]*wallet.Wallet, error) {
+	keys, err := keystore.Keys()
+	if err != nil {
+		return nil, err
+	}
+	seeds, err := keystore.Seeds()
+	if err != nil {
+		return nil, err
+	}
+	mnemonics, err := keystore.Mnemonics()
+	if err != nil {
+		return nil, err
+	}
+
+	var accounts []*wallet.Wallet
+	for _, key := range keys {
+		w := wallet.New(key)
+		accounts = append(accounts, &w)
+	}
+
+	var seedWallets []*wallet.SeedWallet
+	for _, seed := range seeds {
+		s, err := wallet.NewSeedWallet(seed)
+		if err != nil {
+			return nil, err
+		}
+		seedWallets = append(seedWallets, s)
+	}
+	for _, m := range mnemonics {
+		s, err := wallet.NewMnemonicWallet(m.Words, m.Passphrase)
+		if err != nil {
+			return nil, err
+		}
+		seedWallets = append(seedWallets, s)
+	}
+	for _, s := range seedWallets {
+		s.Scan(wallet.DefaultGapLimit)
+		if s.NextIndex() == 0 {
+			s.NewAccount()
+		}
+		accounts = append(accounts, s.Accounts()...)
+	}
+	return accounts, nil
+}
+
+func findAccount(keystore *wallet.Keystore, account types.Account) (*wallet.Wallet, error) {
+	accounts, err := walletAccounts(keystore)
+	if err != nil {
+		return nil, err
+	}
+	for _, w := range accounts {
+		if w.Address() == account {
+			return w, nil
+		}
+	}
+	return nil, errors.Errorf("Account %s is not in the wallet", account)
+}
+
+func walletCreate(args []string, out io.Writer) error {
+	flags := newFlags("wallet", "create")
+	key := flags.String("key", "", "Existing private key to add, instead of generating one")
+	seed := flags.String("seed", "", "Existing seed to add, instead of a key")
+	newSeed := flags.Bool("new-seed", false, "Generate a seed rather than a key")
+	mnemonic := flags.String("mnemonic", "", "Existing BIP39 mnemonic to add, with the passphrase in NANO_MNEMONIC_PASSPHRASE")
+	newMnemonic := flags.Bool("new-mnemonic", false, "Generate a 24 word mnemonic rather than a key")
+	cfg, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+
+	given := 0
+	for _, set := range []bool{*key != "", *seed != "", *newSeed, *mnemonic != "", *newMnemonic} {
+		if set {
+			given++
+		}
+	}
+	if given > 1 {
+		return errors.New("Only one of -key, -seed, -new-seed, -mnemonic and -new-mnemonic may be given")
+	}
+
+	if *newSeed {
+		*seed, err = wallet.GenerateSeed()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Seed %s\n", *seed)
+	}
+	if *newMnemonic {
+		*mnemonic, err = address.NewMnemonic(256)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Mnemonic %s\n", *mnemonic)
+	}
+	passphrase := os.Getenv("NANO_MNEMONIC_PASSPHRASE")
+
+	// Work out the first account before touching the keystore, so bad
+	// input doesn't leave an empty wallet behind
+	var account types.Account
+	switch {
+	case *seed != "":
+		s, err := wallet.NewSeedWallet(*seed)
+		if err != nil {
+			return err
+		}
+		account = s.NewAccount().Address()
+	case *mnemonic != "":
+		if !address.ValidateMnemonic(*mnemonic) {
+			return address.ErrInvalidMnemonic
+		}
+		seed_bytes, _ := address.MnemonicToSeed(*mnemonic, passphrase)
+		pub, _ := address.KeypairFromBip39Seed(seed_bytes, 0)
+		account = address.PubKeyToAddress(pub)
+	default:
+		if *key == "" {
+			_, priv := address.GenerateKey()
+			*key = hex.EncodeToString(priv[:32])
+		}
+		if _, err := hex.DecodeString(*key); err != nil || len(*key) != 64 {
+			return errors.New("Invalid private key")
+		}
+		pub, _ := address.KeypairFromPrivateKey(*key)
+		account = address.PubKeyToAddress(pub)
+	}
+
+	keystore, err := openKeystore(cfg, true)
+	if err != nil {
+		return err
+	}
+	defer keystore.Lock()
+
+	switch {
+	case *seed != "":
+		err = keystore.AddSeed(*seed)
+	case *mnemonic != "":
+		err = keystore.AddMnemonic(*mnemonic, passphrase)
+	default:
+		err = keystore.AddKey(*key)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, account)
+	return nil
+}
+
+func walletList(args []string, out io.Writer) error {
+	cfg, net, err := setup(newFlags("wallet", "list"), args)
+	if err != nil {
+		return err
+	}
+	keystore, err := openKeystore(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer keystore.Lock()
+
+	err = openLedger(cfg, net)
+	if err != nil {
+		return err
+	}
+	defer store.Close()
+
+	accounts, err := walletAccounts(keystore)
+	if err != nil {
+		return err
+	}
+	for _, w := range accounts {
+		account := w.Address()
+		balance := uint128.FromInts(0, 0)
+		if info := store.FetchAccountInfo(account); info != nil {
+			balance = info.Balance
+		}
+		pending := uint128.FromInts(0, 0)
+		for _, p := range store.FetchPending(account, 0) {
+			pending = pending.Add(p.Amount)
+		}
+		fmt.Fprintf(out, "%s balance %s pending %s\n", account, balance.Decimal(), pending.Decimal())
+	}
+	return nil
+}
+
+func walletPassword(args []string, out io.Writer) error {
+	cfg, _, err := setup(newFlags("wallet", "password"), args)
+	if err != nil {
+		return err
+	}
+	keystore, err := wallet.OpenKeystore(walletPath(cfg))
+	if err != nil {
+		return err
+	}
+
+	old, err := readPassword("NANO_WALLET_PASSWORD", "Current password: ")
+	if err != nil {
+		return err
+	}
+	password, err := readPassword("NANO_WALLET_NEW_PASSWORD", "New password: ")
+	if err != nil {
+		return err
+	}
+	err = keystore.ChangePassword(old, password)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "Password changed")
+	return nil
+}
+
+func walletExport(args []string, out io.Writer) error {
+	flags := newFlags("wallet", "export")
+	path := flags.String("out", "", "File to write to, by default stdout")
+	cfg, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+	keystore, err := wallet.OpenKeystore(walletPath(cfg))
+	if err != nil {
+		return err
+	}
+
+	if *path == "" {
+		return keystore.Export(out)
+	}
+	f, err := os.OpenFile(*path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return err
+	}
+	err = keystore.Export(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func walletImport(args []string, out io.Writer) error {
+	flags := newFlags("wallet", "import")
+	cfg, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Expected an exported wallet file")
+	}
+
+	path := walletPath(cfg)
+	if _, err := os.Stat(path); err == nil {
+		return errors.Errorf("Wallet %s already exists", path)
+	}
+	f, err := os.Open(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	password, err := readPassword("NANO_WALLET_PASSWORD", "Wallet password: ")
+	if err != nil {
+		return err
+	}
+	_, err = wallet.ImportKeystore(path, f, password)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Imported wallet to %s\n", path)
+	return nil
+}
+
+func walletSend(args []string, out io.Writer) error {
+	flags := newFlags("wallet", "send")
+	from := flags.String("from", "", "Wallet account to send from")
+	to := flags.String("to", "", "Account to send to")
+	amount := flags.String("amount", "", "Amount to send in raw")
+	id := flags.String("id", "", "Unique id for the send, so retrying it with the same id never pays twice")
+	cfg, net, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if !address.ValidateAddress(types.Account(*to)) {
+		return errors.Errorf("Invalid destination %s", *to)
+	}
+	raw, err := uint128.FromDecimal(*amount)
+	if err != nil {
+		return errors.Errorf("Invalid amount %s", *amount)
+	}
+	keystore, err := openKeystore(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer keystore.Lock()
+	err = loadWorkCache(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	nano_node, err := startNode(ctx, cfg, net)
+	if err != nil {
+		return err
+	}
+	defer stopNode(nano_node)
+
+	w, err := findAccount(keystore, types.Account(*from))
+	if err != nil {
+		return err
+	}
+	var block *blocks.SendBlock
+	if *id != "" {
+		wallet.DefaultSends, err = wallet.LoadSendLog(filepath.Join(cfg.DataDir, "send_log.json"))
+		if err != nil {
+			return err
+		}
+		block, err = w.SendOnce(ctx, *id, types.Account(*to), raw)
+	} else {
+		_, err = w.NextWork(ctx)
+		if err == nil {
+			block, err = w.Send(types.Account(*to), raw)
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return publish(nano_node, out, block)
+}
+
+func walletReceive(args []string, out io.Writer) error {
+	flags := newFlags("wallet", "receive")
+	account := flags.String("account", "", "Wallet account to receive to")
+	source := flags.String("source", "", "Send block to receive, by default every pending send")
+	representative := flags.String("representative", "", "Representative for a new account, by default itself")
+	cfg, net, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+
+	if *representative == "" {
+		*representative = *account
+	}
+	if !address.ValidateAddress(types.Account(*representative)) {
+		return errors.Errorf("Invalid representative %s", *representative)
+	}
+	keystore, err := openKeystore(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer keystore.Lock()
+	err = loadWorkCache(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	nano_node, err := startNode(ctx, cfg, net)
+	if err != nil {
+		return err
+	}
+	defer stopNode(nano_node)
+
+	w, err := findAccount(keystore, types.Account(*account))
+	if err != nil {
+		return err
+	}
+
+	var sources []types.BlockHash
+	for _, p := range store.FetchPending(w.Address(), 0) {
+		if *source == "" || strings.ToUpper(*source) == string(p.Hash) {
+			sources = append(sources, p.Hash)
+		}
+	}
+	if len(sources) == 0 {
+		return errors.New("Nothing to receive")
+	}
+
+	var results []<-chan node.ProcessResult
+	for _, hash := range sources {
+		block, err := w.ReceivePending(ctx, hash, types.Account(*representative))
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Published %s\n", block.Hash())
+		results = append(results, nano_node.Process(block))
+	}
+
+	for _, result := range results {
+		err = printResult(out, <-result)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Runs a node which receives every pending send to the wallet's accounts,
+// and new ones as they arrive, until interrupted
+func walletWatch(args []string, out io.Writer) error {
+	flags := newFlags("wallet", "watch")
+	minimum := flags.String("minimum", "1", "Smallest send in raw to receive, smaller ones are left pending")
+	representative := flags.String("representative", "", "Representative for new accounts, by default themselves")
+	cfg, net, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+
+	raw, err := uint128.FromDecimal(*minimum)
+	if err != nil {
+		return errors.Errorf("Invalid minimum %s", *minimum)
+	}
+	if *representative != "" && !address.ValidateAddress(types.Account(*representative)) {
+		return errors.Errorf("Invalid representative %s", *representative)
+	}
+	keystore, err := openKeystore(cfg, false)
+	if err != nil {
+		return err
+	}
+	defer keystore.Lock()
+	err = loadWorkCache(cfg)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	nano_node, err := startNode(ctx, cfg, net)
+	if err != nil {
+		return err
+	}
+	defer stopNode(nano_node)
+
+	accounts, err := walletAccounts(keystore)
+	if err != nil {
+		return err
+	}
+	receiver := wallet.NewReceiver(nano_node, raw)
+	receiver.Representative = types.Account(*representative)
+	for _, w := range accounts {
+		receiver.Add(w)
+	}
+	receiver.Start()
+	fmt.Fprintf(out, "Receiving to %d accounts\n", len(accounts))
+
+	<-ctx.Done()
+	receiver.Stop()
+	return nil
+}
+
+// Processes a block with the node and waits for it to be confirmed
+func publish(n *node.Node, out io.Writer, block blocks.Block) error {
+	fmt.Fprintf(out, "Published %s\n", block.Hash())
+	return printResult(out, <-n.Process(block))
+}
+
+func printResult(out io.Writer, result node.ProcessResult) error {
+	if result.Err != nil {
+		return errors.Wrapf(result.Err, "Block %s failed", result.Hash)
+	}
+	fmt.Fprintf(out, "Confirmed %s\n", result.Hash)
+	return nil
+}
+
+func stopNode(n *node.Node) {
+	n.Stop()
+	err := store.Close()
+	if err != nil {
+		logger.Errorf("Failed to close store: %s", err)
+	}
+}
@@ -0,0 +1,139 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/work"
+)
+
+func parseHash(s string) (types.BlockHash, error) {
+	if _, err := hex.DecodeString(s); err != nil || len(s) != 64 {
+		return "", errors.Errorf("Invalid block hash %s", s)
+	}
+	return types.BlockHash(strings.ToUpper(s)), nil
+}
+
+func workGenerate(args []string, out io.Writer) error {
+	flags := newFlags("work", "generate")
+	difficulty := flags.String("difficulty", "", "Work difficulty in hex, by default the network's threshold")
+	multiplier := flags.Float64("multiplier", 0, "Work difficulty as a multiple of the network's threshold")
+	_, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("Expected a block hash")
+	}
+	hash, err := parseHash(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	d := blocks.WorkThreshold
+	switch {
+	case *difficulty != "" && *multiplier != 0:
+		return errors.New("Only one of -difficulty and -multiplier may be given")
+	case *difficulty != "":
+		d, err = blocks.ParseDifficulty(strings.TrimPrefix(*difficulty, "0x"))
+		if err != nil {
+			return err
+		}
+	case *multiplier < 0:
+		return errors.Errorf("Invalid multiplier %g", *multiplier)
+	case *multiplier > 0:
+		d = blocks.FromMultiplier(*multiplier, blocks.WorkThreshold)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+	w, err := work.Default.Generate(ctx, hash, d)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, w)
+	return nil
+}
+
+func workValidate(args []string, out io.Writer) error {
+	flags := newFlags("work", "validate")
+	_, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+	if flags.NArg() != 2 {
+		return errors.New("Expected a block hash and work")
+	}
+	hash, err := parseHash(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	if work, err := hex.DecodeString(flags.Arg(1)); err != nil || len(work) != 8 {
+		return errors.Errorf("Invalid work %s", flags.Arg(1))
+	}
+
+	d := blocks.WorkDifficulty(hash, types.Work(flags.Arg(1)))
+	fmt.Fprintf(out, "Difficulty %s, multiplier %s\n", d, strconv.FormatFloat(d.Multiplier(blocks.WorkThreshold), 'f', -1, 64))
+	if d < blocks.WorkThreshold {
+		return errors.New("Work is not valid")
+	}
+	fmt.Fprintln(out, "Work is valid")
+	return nil
+}
+
+// Runs a standalone work server, generating work for wallets on other
+// machines without joining the network
+func workServer(args []string, out io.Writer) error {
+	flags := newFlags("work", "server")
+	addr := flags.String("address", "[::1]:7076", "Address to listen for work requests on")
+	workers := flags.Int("workers", 0, "Number of work threads, by default one per CPU")
+	_, _, err := setup(flags, args)
+	if err != nil {
+		return err
+	}
+	if *workers < 0 {
+		return errors.Errorf("Invalid workers %d", *workers)
+	}
+
+	ctx, cancel := signalContext()
+	defer cancel()
+
+	server := work.NewServer(*addr, &work.Local{Workers: *workers})
+	err = server.Start()
+	if err != nil {
+		return err
+	}
+	<-ctx.Done()
+	return server.Stop()
+}
@@ -0,0 +1,491 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package config
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/network"
+)
+
+var LogLevels = []string{"debug", "info", "warn", "error"}
+var LogFormats = []string{"text", "json"}
+
+// Node configuration. Settings are read from the config file, then
+// overridden by environment variables and finally by command line flags.
+type Config struct {
+	// Directory holding the ledger and peer cache, by default a directory
+	// per network under $HOME/GoNano
+	DataDir string `json:"data_dir"`
+	// One of live, beta, test or dev
+	Network string `json:"network"`
+	// Private key owning the dev network's genesis account
+	DevGenesisKey string `json:"dev_genesis_key"`
+
+	// Address to listen on, all interfaces if empty
+	BindAddress string `json:"bind_address"`
+	// Port to listen on, defaulting to the network's port
+	UdpPort uint16 `json:"udp_port"`
+	// host:port addresses to contact in addition to the bootstrap peers
+	Peers []string `json:"peers"`
+
+	LogLevel  string `json:"log_level"`
+	LogFormat string `json:"log_format"`
+
+	// Serve the JSON RPC interface on RpcAddress
+	EnableRpc  bool   `json:"enable_rpc"`
+	RpcAddress string `json:"rpc_address"`
+
+	// Push ledger events to WebSocket subscribers on WebsocketAddress
+	EnableWebsocket  bool   `json:"enable_websocket"`
+	WebsocketAddress string `json:"websocket_address"`
+
+	// Expose Prometheus metrics on MetricsAddress
+	EnableMetrics  bool   `json:"enable_metrics"`
+	MetricsAddress string `json:"metrics_address"`
+
+	// POST confirmed blocks to this URL if set
+	CallbackUrl string `json:"callback_url"`
+
+	// Request proof of work from this work server rather than generating
+	// it locally
+	WorkServer string `json:"work_server"`
+
+	// Private key of a representative this node votes for
+	RepresentativeKey string `json:"representative_key"`
+	EnableVoting      bool   `json:"enable_voting"`
+	// Save known peers to the data directory to reconnect quickly after a
+	// restart
+	EnablePeerCache bool `json:"enable_peer_cache"`
+}
+
+func Default() Config {
+	return Config{
+		Network:          network.Live.Name,
+		LogLevel:         "info",
+		LogFormat:        "text",
+		RpcAddress:       "[::1]:7076",
+		WebsocketAddress: "[::1]:7078",
+		MetricsAddress:   "[::1]:9100",
+		EnableVoting:     true,
+		EnablePeerCache:  true,
+	}
+}
+
+// Reads a JSON config file over the defaults
+func Load(path string) (Config, error) {
+	c := Default()
+	err := c.loadFile(path)
+	return c, err
+}
+
+func (c *Config) loadFile(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return errors.Wrap(err, "Failed to read config file")
+	}
+
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return errors.Wrapf(err, "Invalid config file %s", path)
+	}
+	return nil
+}
+
+// Builds the config from command line arguments and the environment. The
+// config file is given by -config or NANO_CONFIG.
+func Parse(args []string, getenv func(string) string) (Config, error) {
+	return ParseFlags(flag.NewFlagSet("nano", flag.ContinueOnError), args, getenv)
+}
+
+// Like Parse, but adds the config flags to an existing flag set so a
+// command can accept its own flags alongside them.
+func ParseFlags(flags *flag.FlagSet, args []string, getenv func(string) string) (Config, error) {
+	c := Default()
+	var udpPort uint
+	var peers string
+
+	path := flags.String("config", getenv("NANO_CONFIG"), "Path to the JSON config file")
+	flags.StringVar(&c.DataDir, "data", "", "Data directory")
+	flags.StringVar(&c.Network, "network", "", "Network to join: live, beta, test or dev")
+	flags.StringVar(&c.BindAddress, "bind", "", "Address to listen on")
+	flags.UintVar(&udpPort, "port", 0, "UDP port")
+	flags.StringVar(&peers, "peers", "", "Comma separated host:port peers")
+	flags.StringVar(&c.LogLevel, "log-level", "", "Log level: "+strings.Join(LogLevels, ", "))
+	flags.StringVar(&c.LogFormat, "log-format", "", "Log format: "+strings.Join(LogFormats, ", "))
+	flags.BoolVar(&c.EnableRpc, "rpc", false, "Serve the JSON RPC interface")
+	flags.StringVar(&c.RpcAddress, "rpc-address", "", "Address for the RPC interface")
+	flags.BoolVar(&c.EnableWebsocket, "websocket", false, "Serve WebSocket event subscriptions")
+	flags.StringVar(&c.WebsocketAddress, "websocket-address", "", "Address for WebSocket subscriptions")
+	flags.BoolVar(&c.EnableMetrics, "metrics", false, "Expose Prometheus metrics")
+	flags.StringVar(&c.MetricsAddress, "metrics-address", "", "Address for the metrics endpoint")
+	flags.StringVar(&c.CallbackUrl, "callback-url", "", "URL to POST confirmed blocks to")
+	flags.StringVar(&c.WorkServer, "work-server", "", "URL of a work server to generate work")
+	flags.BoolVar(&c.EnableVoting, "voting", true, "Vote when a representative key is configured")
+	flags.BoolVar(&c.EnablePeerCache, "peer-cache", true, "Save known peers across restarts")
+
+	err := flags.Parse(args)
+	if err != nil {
+		return c, err
+	}
+
+	// The flags are parsed into c, so keep them aside while the file and
+	// environment are applied underneath
+	set := make(map[string]bool)
+	flags.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	parsed := c
+
+	c = Default()
+	if *path != "" {
+		err = c.loadFile(*path)
+		if err != nil {
+			return c, err
+		}
+	}
+
+	err = c.applyEnv(getenv)
+	if err != nil {
+		return c, err
+	}
+
+	if set["data"] {
+		c.DataDir = parsed.DataDir
+	}
+	if set["network"] {
+		c.Network = parsed.Network
+	}
+	if set["bind"] {
+		c.BindAddress = parsed.BindAddress
+	}
+	if set["port"] {
+		if udpPort > 0xffff {
+			return c, errors.Errorf("Invalid port %d", udpPort)
+		}
+		c.UdpPort = uint16(udpPort)
+	}
+	if set["peers"] {
+		c.Peers = splitList(peers)
+	}
+	if set["log-level"] {
+		c.LogLevel = parsed.LogLevel
+	}
+	if set["log-format"] {
+		c.LogFormat = parsed.LogFormat
+	}
+	if set["rpc"] {
+		c.EnableRpc = parsed.EnableRpc
+	}
+	if set["rpc-address"] {
+		c.RpcAddress = parsed.RpcAddress
+	}
+	if set["websocket"] {
+		c.EnableWebsocket = parsed.EnableWebsocket
+	}
+	if set["websocket-address"] {
+		c.WebsocketAddress = parsed.WebsocketAddress
+	}
+	if set["metrics"] {
+		c.EnableMetrics = parsed.EnableMetrics
+	}
+	if set["metrics-address"] {
+		c.MetricsAddress = parsed.MetricsAddress
+	}
+	if set["callback-url"] {
+		c.CallbackUrl = parsed.CallbackUrl
+	}
+	if set["work-server"] {
+		c.WorkServer = parsed.WorkServer
+	}
+	if set["voting"] {
+		c.EnableVoting = parsed.EnableVoting
+	}
+	if set["peer-cache"] {
+		c.EnablePeerCache = parsed.EnablePeerCache
+	}
+
+	return c, c.Validate()
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func parsePort(name string, value string) (uint16, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil {
+		return 0, errors.Errorf("Invalid %s %s", name, value)
+	}
+	return uint16(port), nil
+}
+
+func (c *Config) applyEnv(getenv func(string) string) error {
+	var err error
+	if v := getenv("NANO_DATA_DIR"); v != "" {
+		c.DataDir = v
+	}
+	if v := getenv("NANO_NETWORK"); v != "" {
+		c.Network = v
+	}
+	if v := getenv("NANO_DEV_GENESIS_KEY"); v != "" {
+		c.DevGenesisKey = v
+	}
+	if v := getenv("NANO_BIND_ADDRESS"); v != "" {
+		c.BindAddress = v
+	}
+	if v := getenv("NANO_UDP_PORT"); v != "" {
+		c.UdpPort, err = parsePort("NANO_UDP_PORT", v)
+		if err != nil {
+			return err
+		}
+	}
+	if v := getenv("NANO_PEERS"); v != "" {
+		c.Peers = splitList(v)
+	}
+	if v := getenv("NANO_LOG_LEVEL"); v != "" {
+		c.LogLevel = v
+	}
+	if v := getenv("NANO_LOG_FORMAT"); v != "" {
+		c.LogFormat = v
+	}
+	if v := getenv("NANO_ENABLE_RPC"); v != "" {
+		c.EnableRpc, err = strconv.ParseBool(v)
+		if err != nil {
+			return errors.Errorf("Invalid NANO_ENABLE_RPC %s", v)
+		}
+	}
+	if v := getenv("NANO_RPC_ADDRESS"); v != "" {
+		c.RpcAddress = v
+	}
+	if v := getenv("NANO_ENABLE_WEBSOCKET"); v != "" {
+		c.EnableWebsocket, err = strconv.ParseBool(v)
+		if err != nil {
+			return errors.Errorf("Invalid NANO_ENABLE_WEBSOCKET %s", v)
+		}
+	}
+	if v := getenv("NANO_WEBSOCKET_ADDRESS"); v != "" {
+		c.WebsocketAddress = v
+	}
+	if v := getenv("NANO_ENABLE_METRICS"); v != "" {
+		c.EnableMetrics, err = strconv.ParseBool(v)
+		if err != nil {
+			return errors.Errorf("Invalid NANO_ENABLE_METRICS %s", v)
+		}
+	}
+	if v := getenv("NANO_METRICS_ADDRESS"); v != "" {
+		c.MetricsAddress = v
+	}
+	if v := getenv("NANO_CALLBACK_URL"); v != "" {
+		c.CallbackUrl = v
+	}
+	if v := getenv("NANO_WORK_SERVER"); v != "" {
+		c.WorkServer = v
+	}
+	if v := getenv("NANO_REPRESENTATIVE_KEY"); v != "" {
+		c.RepresentativeKey = v
+	}
+	if v := getenv("NANO_ENABLE_VOTING"); v != "" {
+		c.EnableVoting, err = strconv.ParseBool(v)
+		if err != nil {
+			return errors.Errorf("Invalid NANO_ENABLE_VOTING %s", v)
+		}
+	}
+	if v := getenv("NANO_ENABLE_PEER_CACHE"); v != "" {
+		c.EnablePeerCache, err = strconv.ParseBool(v)
+		if err != nil {
+			return errors.Errorf("Invalid NANO_ENABLE_PEER_CACHE %s", v)
+		}
+	}
+	return nil
+}
+
+func validKey(key string) bool {
+	_, err := hex.DecodeString(key)
+	return err == nil && len(key) == 64
+}
+
+func validUrl(s string) bool {
+	u, err := url.Parse(s)
+	return err == nil && (u.Scheme == "http" || u.Scheme == "https") && u.Host != ""
+}
+
+// Checks every setting, so bad config is reported at startup rather than
+// when it's first used
+func (c *Config) Validate() error {
+	if c.Network != "dev" {
+		if _, err := network.ByName(c.Network); err != nil {
+			return err
+		}
+	}
+	if c.DevGenesisKey != "" && !validKey(c.DevGenesisKey) {
+		return errors.New("Invalid dev_genesis_key")
+	}
+
+	if c.BindAddress != "" && net.ParseIP(c.BindAddress) == nil {
+		return errors.Errorf("Invalid bind_address %s", c.BindAddress)
+	}
+
+	for _, peer := range c.Peers {
+		_, port, err := net.SplitHostPort(peer)
+		if err != nil {
+			return errors.Errorf("Invalid peer %s", peer)
+		}
+		if _, err := parsePort("peer port", port); err != nil {
+			return errors.Errorf("Invalid peer %s", peer)
+		}
+	}
+
+	validLevel := false
+	for _, level := range LogLevels {
+		validLevel = validLevel || c.LogLevel == level
+	}
+	if !validLevel {
+		return errors.Errorf("Invalid log_level %s", c.LogLevel)
+	}
+
+	validFormat := false
+	for _, format := range LogFormats {
+		validFormat = validFormat || c.LogFormat == format
+	}
+	if !validFormat {
+		return errors.Errorf("Invalid log_format %s", c.LogFormat)
+	}
+
+	if c.EnableRpc {
+		if _, _, err := net.SplitHostPort(c.RpcAddress); err != nil {
+			return errors.Errorf("Invalid rpc_address %s", c.RpcAddress)
+		}
+	}
+
+	if c.EnableWebsocket {
+		if _, _, err := net.SplitHostPort(c.WebsocketAddress); err != nil {
+			return errors.Errorf("Invalid websocket_address %s", c.WebsocketAddress)
+		}
+	}
+
+	if c.EnableMetrics {
+		if _, _, err := net.SplitHostPort(c.MetricsAddress); err != nil {
+			return errors.Errorf("Invalid metrics_address %s", c.MetricsAddress)
+		}
+	}
+
+	if c.CallbackUrl != "" && !validUrl(c.CallbackUrl) {
+		return errors.Errorf("Invalid callback_url %s", c.CallbackUrl)
+	}
+
+	if c.WorkServer != "" && !validUrl(c.WorkServer) {
+		return errors.Errorf("Invalid work_server %s", c.WorkServer)
+	}
+
+	if c.RepresentativeKey != "" && !validKey(c.RepresentativeKey) {
+		return errors.New("Invalid representative_key")
+	}
+	return nil
+}
+
+// Returns the configured network. If the dev network has no genesis key
+// the one saved in its data directory is used, so the ledger there keeps a
+// single genesis, or a new one is generated and saved there. The key is
+// stored in the config.
+func (c *Config) SelectNetwork() (*network.Network, error) {
+	if c.Network != "dev" {
+		return network.ByName(c.Network)
+	}
+
+	dir := c.DataDir
+	if dir == "" {
+		dir = DefaultDataDir(network.Dev(nil))
+	}
+	path := filepath.Join(dir, devGenesisKeyFile)
+	generate := false
+	if c.DevGenesisKey == "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+		c.DevGenesisKey = strings.TrimSpace(string(data))
+		generate = c.DevGenesisKey == ""
+	}
+
+	dev, key, err := network.NewDev(c.DevGenesisKey)
+	if err != nil {
+		return nil, err
+	}
+	if generate {
+		err = os.MkdirAll(dir, 0700)
+		if err == nil {
+			err = ioutil.WriteFile(path, []byte(key+"\n"), 0600)
+		}
+		if err != nil {
+			return nil, errors.Wrap(err, "Failed to save dev genesis key")
+		}
+	}
+	c.DevGenesisKey = key
+	return dev, nil
+}
+
+// File in a dev network's data directory holding the genesis key that was
+// generated for it
+const devGenesisKeyFile = "dev_genesis_key"
+
+// Fills in the settings which default to values from the network
+func (c *Config) ApplyNetworkDefaults(n *network.Network) {
+	if c.DataDir == "" {
+		c.DataDir = DefaultDataDir(n)
+	}
+	if c.UdpPort == 0 {
+		c.UdpPort = n.DefaultPort
+	}
+}
+
+func DefaultDataDir(n *network.Network) string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		home = "."
+	}
+	return filepath.Join(home, "GoNano", n.Name)
+}
+
+// The address the node listens for UDP messages on
+func (c *Config) UdpAddr() *net.UDPAddr {
+	return &net.UDPAddr{IP: net.ParseIP(c.BindAddress), Port: int(c.UdpPort)}
+}
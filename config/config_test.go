@@ -0,0 +1,150 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/svaishnavy/nano/network"
+)
+
+func env(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func TestParsePrecedence(t *testing.T) {
+	file, err := ioutil.TempFile("", "nano-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(file.Name())
+	file.WriteString(`{"network": "beta", "udp_port": 1000, "log_level": "debug", "peers": ["peer:1"], "enable_peer_cache": true}`)
+	file.Close()
+
+	c, err := Parse([]string{"-port", "3000"}, env(map[string]string{
+		"NANO_CONFIG":            file.Name(),
+		"NANO_UDP_PORT":          "2000",
+		"NANO_ENABLE_PEER_CACHE": "false",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Network != "beta" || c.LogLevel != "debug" || len(c.Peers) != 1 {
+		t.Errorf("Config file settings weren't applied: %+v", c)
+	}
+	if c.EnablePeerCache {
+		t.Errorf("Environment didn't override config file, peer cache enabled")
+	}
+	if c.UdpPort != 3000 {
+		t.Errorf("Flag didn't override environment, udp port %d", c.UdpPort)
+	}
+	if !c.EnableVoting {
+		t.Errorf("Defaults were lost")
+	}
+
+	c, err = Parse([]string{"-voting"}, env(map[string]string{"NANO_ENABLE_VOTING": "false"}))
+	if err != nil || !c.EnableVoting {
+		t.Errorf("Flag didn't override environment, voting disabled")
+	}
+	c, err = Parse(nil, env(map[string]string{"NANO_ENABLE_VOTING": "0"}))
+	if err != nil || c.EnableVoting {
+		t.Errorf("Environment didn't disable voting")
+	}
+}
+
+func TestValidate(t *testing.T) {
+	invalid := [][]string{
+		{"-network", "nonsense"},
+		{"-bind", "localhost"},
+		{"-peers", "no-port"},
+		{"-log-level", "loud"},
+		{"-log-format", "xml"},
+		{"-port", "70000"},
+		{"-callback-url", "localhost:8080"},
+		{"-work-server", "ftp://work"},
+		{"-metrics", "-metrics-address", "9100"},
+	}
+	for _, args := range invalid {
+		_, err := Parse(args, env(nil))
+		if err == nil {
+			t.Errorf("Accepted %v", args)
+		}
+	}
+
+	_, err := Parse(nil, env(map[string]string{"NANO_REPRESENTATIVE_KEY": "1234"}))
+	if err == nil {
+		t.Errorf("Accepted invalid representative key")
+	}
+	for _, name := range []string{"NANO_ENABLE_VOTING", "NANO_ENABLE_PEER_CACHE"} {
+		_, err = Parse(nil, env(map[string]string{name: "maybe"}))
+		if err == nil {
+			t.Errorf("Accepted invalid %s", name)
+		}
+	}
+
+	_, err = Parse([]string{"-bind", "::1", "-peers", "[::1]:7075, 127.0.0.1:7075"}, env(nil))
+	if err != nil {
+		t.Errorf("Rejected valid config: %s", err)
+	}
+}
+
+func TestNetworkDefaults(t *testing.T) {
+	c := Default()
+	c.Network = "test"
+	n, err := c.SelectNetwork()
+	if err != nil || n != network.Test {
+		t.Fatalf("Failed to select test network")
+	}
+
+	c.ApplyNetworkDefaults(n)
+	if c.UdpPort != n.DefaultPort || c.DataDir == "" {
+		t.Errorf("Network defaults weren't applied: %+v", c)
+	}
+	if c.UdpAddr().Port != int(n.DefaultPort) {
+		t.Errorf("Wrong udp address %s", c.UdpAddr())
+	}
+
+	c.Network = "dev"
+	c.DataDir = filepath.Join(os.TempDir(), "nano_config_dev_test")
+	defer os.RemoveAll(c.DataDir)
+	dev, err := c.SelectNetwork()
+	if err != nil || dev.Name != "dev" || c.DevGenesisKey == "" {
+		t.Errorf("Failed to create dev network")
+	}
+
+	// The generated key is reused for the same data directory
+	c.DevGenesisKey = ""
+	again, err := c.SelectNetwork()
+	if err != nil || again.GenesisBlock.Hash() != dev.GenesisBlock.Hash() {
+		t.Errorf("Dev network genesis changed between runs")
+	}
+}
@@ -0,0 +1,272 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+// Package logging writes levelled log lines tagged with the subsystem
+// they came from, as plain text or as JSON objects.
+package logging
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+type Level int
+
+const (
+	Debug Level = iota
+	Info
+	Warn
+	Error
+)
+
+var levelNames = []string{"debug", "info", "warn", "error"}
+
+func (l Level) String() string {
+	if l < Debug || l > Error {
+		return "unknown"
+	}
+	return levelNames[l]
+}
+
+// Parses a level name as used in the config file
+func ParseLevel(name string) (Level, error) {
+	for i, levelName := range levelNames {
+		if strings.ToLower(name) == levelName {
+			return Level(i), nil
+		}
+	}
+	return Info, errors.Errorf("Unknown log level %s", name)
+}
+
+// Extra key value pairs attached to a log line
+type Fields map[string]interface{}
+
+// Settings shared by every logger
+var (
+	mutex           sync.Mutex
+	output          io.Writer = os.Stderr
+	minLevel                  = Info
+	componentLevels           = make(map[string]Level)
+	jsonOutput      bool
+	now             = time.Now
+)
+
+// Sets the lowest level which is written
+func SetLevel(level Level) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	minLevel = level
+}
+
+// Overrides the level for one component, e.g. to debug just the network
+func SetComponentLevel(component string, level Level) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	componentLevels[component] = level
+}
+
+// Writes each line as a JSON object rather than text
+func SetJSON(enabled bool) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	jsonOutput = enabled
+}
+
+func SetOutput(w io.Writer) {
+	mutex.Lock()
+	defer mutex.Unlock()
+	output = w
+}
+
+func enabled(component string, level Level) bool {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	if min, ok := componentLevels[component]; ok {
+		return level >= min
+	}
+	return level >= minLevel
+}
+
+// Logs for a single component
+type Logger struct {
+	component string
+	fields    Fields
+	sampler   *sampler
+}
+
+func New(component string) *Logger {
+	return &Logger{component: component}
+}
+
+// Returns a logger which adds fields to every line
+func (l *Logger) With(fields Fields) *Logger {
+	merged := make(Fields, len(l.fields)+len(fields))
+	for k, v := range l.fields {
+		merged[k] = v
+	}
+	for k, v := range fields {
+		merged[k] = v
+	}
+	return &Logger{component: l.component, fields: merged, sampler: l.sampler}
+}
+
+// Returns a logger which only writes the first of every n lines with the
+// same format, for hot paths such as handling each packet. Written lines
+// carry the number of lines skipped since the last one.
+func (l *Logger) Sample(n uint64) *Logger {
+	return &Logger{component: l.component, fields: l.fields, sampler: newSampler(n)}
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	l.log(Debug, format, args)
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	l.log(Info, format, args)
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	l.log(Warn, format, args)
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	l.log(Error, format, args)
+}
+
+// Logs an error and exits
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	l.log(Error, format, args)
+	os.Exit(1)
+}
+
+func (l *Logger) log(level Level, format string, args []interface{}) {
+	if !enabled(l.component, level) {
+		return
+	}
+
+	fields := l.fields
+	if l.sampler != nil {
+		write, skipped := l.sampler.next(format)
+		if !write {
+			return
+		}
+		if skipped > 0 {
+			fields = l.With(Fields{"sampled": skipped}).fields
+		}
+	}
+
+	line := format
+	if len(args) > 0 {
+		line = fmt.Sprintf(format, args...)
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+	if jsonOutput {
+		writeJSON(output, now(), level, l.component, line, fields)
+	} else {
+		writeText(output, now(), level, l.component, line, fields)
+	}
+}
+
+func sortedKeys(fields Fields) []string {
+	keys := make([]string, 0, len(fields))
+	for k := range fields {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func writeText(w io.Writer, t time.Time, level Level, component string, msg string, fields Fields) {
+	line := fmt.Sprintf("%s %-5s [%s] %s", t.Format("2006-01-02T15:04:05.000Z07:00"), strings.ToUpper(level.String()), component, msg)
+	for _, k := range sortedKeys(fields) {
+		line += fmt.Sprintf(" %s=%v", k, fields[k])
+	}
+	fmt.Fprintln(w, line)
+}
+
+func writeJSON(w io.Writer, t time.Time, level Level, component string, msg string, fields Fields) {
+	entry := make(map[string]interface{}, len(fields)+4)
+	for k, v := range fields {
+		if err, ok := v.(error); ok {
+			v = err.Error()
+		}
+		entry[k] = v
+	}
+	entry["time"] = t.Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["component"] = component
+	entry["msg"] = msg
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		data, _ = json.Marshal(map[string]interface{}{
+			"time":      entry["time"],
+			"level":     entry["level"],
+			"component": component,
+			"msg":       msg,
+		})
+	}
+	w.Write(append(data, '\n'))
+}
+
+type sampler struct {
+	n      uint64
+	mutex  sync.Mutex
+	counts map[string]uint64
+}
+
+func newSampler(n uint64) *sampler {
+	if n == 0 {
+		n = 1
+	}
+	return &sampler{n: n, counts: make(map[string]uint64)}
+}
+
+// Returns whether to write this line, and how many were skipped before it
+func (s *sampler) next(key string) (bool, uint64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	count := s.counts[key]
+	s.counts[key] = count + 1
+	if count%s.n != 0 {
+		return false, 0
+	}
+	if count == 0 {
+		return true, 0
+	}
+	return true, s.n - 1
+}
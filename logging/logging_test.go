@@ -0,0 +1,112 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package logging
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+)
+
+func capture() *bytes.Buffer {
+	var buf bytes.Buffer
+	SetOutput(&buf)
+	SetLevel(Info)
+	SetJSON(false)
+	componentLevels = make(map[string]Level)
+	now = func() time.Time { return time.Date(2018, 1, 2, 3, 4, 5, 0, time.UTC) }
+	return &buf
+}
+
+func TestText(t *testing.T) {
+	buf := capture()
+	log := New("net")
+
+	log.Debugf("Hidden")
+	log.With(Fields{"peer": "[::1]:7075"}).Warnf("Failed to read %s", "publish")
+
+	expected := "2018-01-02T03:04:05.000Z WARN  [net] Failed to read publish peer=[::1]:7075\n"
+	if buf.String() != expected {
+		t.Errorf("Unexpected output %q", buf.String())
+	}
+}
+
+func TestLevels(t *testing.T) {
+	buf := capture()
+	SetComponentLevel("store", Debug)
+
+	New("net").Debugf("Hidden")
+	New("store").Debugf("Shown")
+
+	if strings.Contains(buf.String(), "Hidden") || !strings.Contains(buf.String(), "Shown") {
+		t.Errorf("Component level not applied: %q", buf.String())
+	}
+
+	level, err := ParseLevel("WARN")
+	if err != nil || level != Warn {
+		t.Errorf("Failed to parse level")
+	}
+	_, err = ParseLevel("loud")
+	if err == nil {
+		t.Errorf("Parsed unknown level")
+	}
+}
+
+func TestJSON(t *testing.T) {
+	buf := capture()
+	SetJSON(true)
+
+	New("elections").With(Fields{"count": 3}).Errorf("Quorum lost")
+
+	var entry map[string]interface{}
+	err := json.Unmarshal(buf.Bytes(), &entry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry["level"] != "error" || entry["component"] != "elections" || entry["msg"] != "Quorum lost" || entry["count"] != float64(3) {
+		t.Errorf("Unexpected entry %v", entry)
+	}
+}
+
+func TestSample(t *testing.T) {
+	buf := capture()
+	log := New("net").Sample(10)
+
+	for i := 0; i < 25; i++ {
+		log.Infof("Received message %d", i)
+	}
+	log.Infof("Other message")
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("Expected 4 lines, got %d: %q", len(lines), lines)
+	}
+	if !strings.HasSuffix(lines[0], "Received message 0") || !strings.HasSuffix(lines[1], "Received message 10 sampled=9") {
+		t.Errorf("Unexpected sampled lines %q", lines)
+	}
+}
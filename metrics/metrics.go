@@ -0,0 +1,322 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+// Package metrics collects counters, gauges and histograms and writes them
+// in the Prometheus text exposition format.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+type metric interface {
+	name() string
+	write(w io.Writer)
+}
+
+// A set of metrics which are exposed together
+type Registry struct {
+	mutex   sync.Mutex
+	metrics map[string]metric
+}
+
+func NewRegistry() *Registry {
+	return &Registry{metrics: make(map[string]metric)}
+}
+
+// The registry used by the package level constructors
+var DefaultRegistry = NewRegistry()
+
+func (r *Registry) register(m metric) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if r.metrics[m.name()] != nil {
+		panic("Duplicate metric " + m.name())
+	}
+	r.metrics[m.name()] = m
+}
+
+// Writes every metric, sorted by name, in the Prometheus text format
+func (r *Registry) WriteText(w io.Writer) error {
+	r.mutex.Lock()
+	names := make([]string, 0, len(r.metrics))
+	for name := range r.metrics {
+		names = append(names, name)
+	}
+	metrics := r.metrics
+	r.mutex.Unlock()
+
+	sort.Strings(names)
+	buf := bufio.NewWriter(w)
+	for _, name := range names {
+		metrics[name].write(buf)
+	}
+	return buf.Flush()
+}
+
+func writeHeader(w io.Writer, name string, help string, kind string) {
+	fmt.Fprintf(w, "# HELP %s %s\n", name, strings.Replace(strings.Replace(help, `\`, `\\`, -1), "\n", `\n`, -1))
+	fmt.Fprintf(w, "# TYPE %s %s\n", name, kind)
+}
+
+var labelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func formatLabels(names []string, values []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = fmt.Sprintf(`%s="%s"`, name, labelEscaper.Replace(values[i]))
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+// Values of a counter or gauge, one per combination of label values
+type vector struct {
+	metricName string
+	help       string
+	kind       string
+	labels     []string
+
+	mutex  sync.Mutex
+	values map[string]float64
+	keys   map[string][]string
+}
+
+func newVector(name string, help string, kind string, labels []string) *vector {
+	return &vector{
+		metricName: name,
+		help:       help,
+		kind:       kind,
+		labels:     labels,
+		values:     make(map[string]float64),
+		keys:       make(map[string][]string),
+	}
+}
+
+func (v *vector) name() string {
+	return v.metricName
+}
+
+func (v *vector) add(delta float64, set bool, labelValues []string) {
+	if len(labelValues) != len(v.labels) {
+		panic(fmt.Sprintf("Metric %s expects %d label values", v.metricName, len(v.labels)))
+	}
+	key := strings.Join(labelValues, "\xff")
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	if _, ok := v.keys[key]; !ok {
+		v.keys[key] = append([]string{}, labelValues...)
+	}
+	if set {
+		v.values[key] = delta
+	} else {
+		v.values[key] += delta
+	}
+}
+
+func (v *vector) get(labelValues []string) float64 {
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+	return v.values[strings.Join(labelValues, "\xff")]
+}
+
+func (v *vector) write(w io.Writer) {
+	writeHeader(w, v.metricName, v.help, v.kind)
+
+	v.mutex.Lock()
+	defer v.mutex.Unlock()
+
+	if len(v.labels) == 0 && len(v.values) == 0 {
+		fmt.Fprintf(w, "%s 0\n", v.metricName)
+		return
+	}
+
+	keys := make([]string, 0, len(v.values))
+	for key := range v.values {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		fmt.Fprintf(w, "%s%s %s\n", v.metricName, formatLabels(v.labels, v.keys[key]), formatValue(v.values[key]))
+	}
+}
+
+// A value which only goes up, optionally split by labels
+type Counter struct {
+	*vector
+}
+
+func NewCounter(name string, help string, labels ...string) *Counter {
+	c := &Counter{newVector(name, help, "counter", labels)}
+	DefaultRegistry.register(c)
+	return c
+}
+
+func (c *Counter) Inc(labelValues ...string) {
+	c.add(1, false, labelValues)
+}
+
+func (c *Counter) Add(delta float64, labelValues ...string) {
+	if delta < 0 {
+		panic("Counters can't decrease")
+	}
+	c.add(delta, false, labelValues)
+}
+
+func (c *Counter) Value(labelValues ...string) float64 {
+	return c.get(labelValues)
+}
+
+// A value which can go up and down, optionally split by labels
+type Gauge struct {
+	*vector
+}
+
+func NewGauge(name string, help string, labels ...string) *Gauge {
+	g := &Gauge{newVector(name, help, "gauge", labels)}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *Gauge) Set(value float64, labelValues ...string) {
+	g.add(value, true, labelValues)
+}
+
+func (g *Gauge) Add(delta float64, labelValues ...string) {
+	g.add(delta, false, labelValues)
+}
+
+func (g *Gauge) Value(labelValues ...string) float64 {
+	return g.get(labelValues)
+}
+
+// A gauge whose value is read when the metrics are written, for values
+// which are already tracked elsewhere such as the number of peers
+type GaugeFunc struct {
+	metricName string
+	help       string
+	fn         func() float64
+}
+
+func NewGaugeFunc(name string, help string, fn func() float64) *GaugeFunc {
+	g := &GaugeFunc{name, help, fn}
+	DefaultRegistry.register(g)
+	return g
+}
+
+func (g *GaugeFunc) name() string {
+	return g.metricName
+}
+
+func (g *GaugeFunc) write(w io.Writer) {
+	writeHeader(w, g.metricName, g.help, "gauge")
+	fmt.Fprintf(w, "%s %s\n", g.metricName, formatValue(g.fn()))
+}
+
+// Counts observations into cumulative buckets
+type Histogram struct {
+	metricName string
+	help       string
+	buckets    []float64
+
+	mutex  sync.Mutex
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// Buckets for durations in seconds, from a millisecond to ten minutes
+var DurationBuckets = []float64{0.001, 0.01, 0.1, 0.5, 1, 5, 10, 30, 60, 300, 600}
+
+func NewHistogram(name string, help string, buckets []float64) *Histogram {
+	h := &Histogram{
+		metricName: name,
+		help:       help,
+		buckets:    buckets,
+		counts:     make([]uint64, len(buckets)),
+	}
+	DefaultRegistry.register(h)
+	return h
+}
+
+func (h *Histogram) name() string {
+	return h.metricName
+}
+
+func (h *Histogram) Observe(value float64) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for i, bound := range h.buckets {
+		if value <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += value
+}
+
+func (h *Histogram) Count() uint64 {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return h.count
+}
+
+func (h *Histogram) write(w io.Writer) {
+	writeHeader(w, h.metricName, h.help, "histogram")
+
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for i, bound := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=\"%s\"} %d\n", h.metricName, formatValue(bound), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", h.metricName, h.count)
+	fmt.Fprintf(w, "%s_sum %s\n", h.metricName, formatValue(h.sum))
+	fmt.Fprintf(w, "%s_count %d\n", h.metricName, h.count)
+}
@@ -0,0 +1,113 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package metrics
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestWriteText(t *testing.T) {
+	r := NewRegistry()
+	received := &Counter{newVector("test_received_total", "Messages received", "counter", []string{"type"})}
+	r.register(received)
+	peers := &GaugeFunc{"test_peers", "Known peers", func() float64 { return 3 }}
+	r.register(peers)
+	pow := &Histogram{metricName: "test_pow_seconds", help: "PoW time", buckets: []float64{0.5, 1}, counts: make([]uint64, 2)}
+	r.register(pow)
+
+	received.Inc("publish")
+	received.Inc("publish")
+	received.Add(1.5, `say "hi"`)
+	pow.Observe(0.25)
+	pow.Observe(2)
+
+	var buf bytes.Buffer
+	err := r.WriteText(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `# HELP test_peers Known peers
+# TYPE test_peers gauge
+test_peers 3
+# HELP test_pow_seconds PoW time
+# TYPE test_pow_seconds histogram
+test_pow_seconds_bucket{le="0.5"} 1
+test_pow_seconds_bucket{le="1"} 1
+test_pow_seconds_bucket{le="+Inf"} 2
+test_pow_seconds_sum 2.25
+test_pow_seconds_count 2
+# HELP test_received_total Messages received
+# TYPE test_received_total counter
+test_received_total{type="publish"} 2
+test_received_total{type="say \"hi\""} 1.5
+`
+	if buf.String() != expected {
+		t.Errorf("Unexpected output:\n%s", buf.String())
+	}
+
+	if received.Value("publish") != 2 {
+		t.Errorf("Wrong counter value %f", received.Value("publish"))
+	}
+}
+
+func TestDuplicateMetric(t *testing.T) {
+	r := NewRegistry()
+	r.register(&GaugeFunc{"test_gauge", "", nil})
+
+	defer func() {
+		if recover() == nil {
+			t.Errorf("Registered a duplicate metric")
+		}
+	}()
+	r.register(&GaugeFunc{"test_gauge", "", nil})
+}
+
+func TestHandler(t *testing.T) {
+	gauge := NewGauge("test_handler_gauge", "A gauge")
+	gauge.Set(42)
+
+	server := httptest.NewServer(Handler(DefaultRegistry))
+	defer server.Close()
+
+	resp, err := server.Client().Get(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Errorf("Wrong content type %s", resp.Header.Get("Content-Type"))
+	}
+	if !strings.Contains(string(body), "test_handler_gauge 42\n") {
+		t.Errorf("Gauge missing from output:\n%s", body)
+	}
+}
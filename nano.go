@@ -26,19 +26,161 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package main
 
 import (
-	"time"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
 
-	"github.com/svaishnavy/nano/node"
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/config"
+	"github.com/svaishnavy/nano/logging"
+	"github.com/svaishnavy/nano/network"
 	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/work"
 )
 
+var logger = logging.New("node")
+
+type command struct {
+	args        string
+	description string
+	run         func(args []string, out io.Writer) error
+}
+
+// Commands are grouped by the thing they act on, e.g. "wallet send"
+var commands = map[string]map[string]command{
+	"node": {
+		"run": {"", "Run a node", runNode},
+	},
+	"wallet": {
+		"create":   {"[-key private | -seed seed | -new-seed | -mnemonic words | -new-mnemonic]", "Add a new or existing key, seed or mnemonic to the wallet", walletCreate},
+		"list":     {"", "List the wallet's accounts and balances", walletList},
+		"send":     {"-from account -to account -amount raw [-id id]", "Send from a wallet account, at most once for each id", walletSend},
+		"receive":  {"-account account [-source hash] [-representative account]", "Receive pending sends", walletReceive},
+		"watch":    {"[-minimum raw] [-representative account]", "Receive sends to the wallet's accounts as they arrive", walletWatch},
+		"password": {"", "Change the wallet's password", walletPassword},
+		"export":   {"[-out file]", "Write the encrypted wallet file", walletExport},
+		"import":   {"file", "Restore an exported wallet file", walletImport},
+	},
+	"block": {
+		"decode": {"[hex message or json]", "Show a block from a wire message or JSON", blockDecode},
+		"hash":   {"[json]", "Print the hash of a JSON block", blockHash},
+	},
+	"work": {
+		"generate": {"hash", "Generate proof of work for a block hash", workGenerate},
+		"validate": {"hash work", "Check proof of work for a block hash", workValidate},
+		"server":   {"[-address addr] [-workers n]", "Serve proof of work to wallets over HTTP", workServer},
+	},
+	"account": {
+		"key":     {"account", "Print the public key for an account", accountKey},
+		"address": {"public-key", "Print the account for a public key", accountAddress},
+	},
+	"ledger": {
+		"stats":  {"", "Show ledger statistics", ledgerStats},
+		"check":  {"", "Check every account chain in the ledger", ledgerCheck},
+		"export": {"[-out file]", "Write every block as JSON, one per line", ledgerExport},
+	},
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "Usage: nano <command> <subcommand> [flags] [args]")
+	fmt.Fprintln(w)
+
+	groups := make([]string, 0, len(commands))
+	for group := range commands {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+	for _, group := range groups {
+		names := make([]string, 0, len(commands[group]))
+		for name := range commands[group] {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			cmd := commands[group][name]
+			fmt.Fprintf(w, "  %-40s %s\n", strings.TrimSpace(group+" "+name+" "+cmd.args), cmd.description)
+		}
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Every command accepts the node's config flags, e.g. -network and -data.")
+}
+
 func main() {
-	store.Init(store.LiveConfig)
+	args := os.Args[1:]
+	if len(args) < 2 || commands[args[0]] == nil {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
+	cmd, ok := commands[args[0]][args[1]]
+	if !ok {
+		usage(os.Stderr)
+		os.Exit(2)
+	}
 
-	nano_node := node.NewNode()
+	err := cmd.run(args[2:], os.Stdout)
+	if err == flag.ErrHelp {
+		return
+	}
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+// Parses the shared config along with a command's own flags, and selects
+// the network so blocks and work are checked against its thresholds.
+func setup(flags *flag.FlagSet, args []string) (config.Config, *network.Network, error) {
+	cfg, err := config.ParseFlags(flags, args, os.Getenv)
+	if err != nil {
+		return cfg, nil, err
+	}
+	level, _ := logging.ParseLevel(cfg.LogLevel)
+	logging.SetLevel(level)
+	logging.SetJSON(cfg.LogFormat == "json")
+
+	net, err := cfg.SelectNetwork()
+	if err != nil {
+		return cfg, nil, err
+	}
+	if net.Name == "dev" {
+		logger.Infof("Dev network genesis account %s, genesis block %s", net.GenesisBlock.Account, net.GenesisBlock.Hash())
+	}
+	cfg.ApplyNetworkDefaults(net)
+	network.Select(net)
+	if cfg.WorkServer != "" {
+		work.Default = work.NewClient(cfg.WorkServer)
+	}
+	return cfg, net, nil
+}
 
-	keepAliveSender := node.NewAlarm(node.AlarmFn(node.SendKeepAlives), []interface{}{node.PeerList}, 20*time.Second)
-	nano_node.ListenForUdp()
+func newFlags(group string, name string) *flag.FlagSet {
+	return flag.NewFlagSet("nano "+group+" "+name, flag.ContinueOnError)
+}
+
+// Opens the ledger in the data directory. Badger locks the directory, so
+// this fails while a node is running on the same data.
+func openLedger(cfg config.Config, net *network.Network) error {
+	err := os.MkdirAll(cfg.DataDir, 0700)
+	if err != nil {
+		return err
+	}
+	err = store.Open(store.Config{Path: cfg.DataDir, GenesisBlock: net.GenesisBlock})
+	if err != nil {
+		return errors.Wrap(err, "Failed to open the ledger")
+	}
+	return nil
+}
 
-	keepAliveSender.Stop()
+// Reads a command's single input from its arguments or, if there are none,
+// from stdin
+func readInput(args []string) (string, error) {
+	if len(args) > 0 {
+		return strings.Join(args, " "), nil
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	return strings.TrimSpace(string(data)), err
 }
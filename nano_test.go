@@ -0,0 +1,246 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/dgraph-io/badger"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/node"
+)
+
+func runCommand(t *testing.T, group string, name string, args ...string) (string, error) {
+	var out bytes.Buffer
+	err := commands[group][name].run(append([]string{"-network", "test"}, args...), &out)
+	return out.String(), err
+}
+
+func TestAccountCommands(t *testing.T) {
+	genesis := blocks.TestGenesisBlock.Account
+
+	key, err := runCommand(t, "account", "key", string(genesis))
+	if err != nil {
+		t.Fatal(err)
+	}
+	account, err := runCommand(t, "account", "address", strings.TrimSpace(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(account) != string(genesis) {
+		t.Errorf("Round trip gave %s for %s", account, genesis)
+	}
+
+	if _, err := runCommand(t, "account", "key", "xrb_invalid"); err == nil {
+		t.Errorf("Accepted an invalid account")
+	}
+}
+
+func TestWorkCommands(t *testing.T) {
+	hash := string(blocks.TestGenesisBlock.Hash())
+
+	work, err := runCommand(t, "work", "generate", hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work = strings.TrimSpace(work)
+	if _, err := runCommand(t, "work", "validate", hash, work); err != nil {
+		t.Errorf("Generated work %s is invalid: %s", work, err)
+	}
+	if _, err := runCommand(t, "work", "validate", hash, "0000000000000000"); err == nil {
+		t.Errorf("Accepted invalid work")
+	}
+}
+
+func TestBlockCommands(t *testing.T) {
+	genesis := blocks.TestGenesisBlock
+	data, _ := blocks.ToJson(genesis)
+
+	hash, err := runCommand(t, "block", "hash", string(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.TrimSpace(hash) != string(genesis.Hash()) {
+		t.Errorf("Wrong hash %s", hash)
+	}
+
+	var buf bytes.Buffer
+	node.CreatePublish(genesis).Write(&buf)
+	decoded, err := runCommand(t, "block", "decode", hex.EncodeToString(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(decoded, "Hash: "+string(genesis.Hash())) || !strings.Contains(decoded, string(genesis.Account)) {
+		t.Errorf("Unexpected decoded block:\n%s", decoded)
+	}
+}
+
+func TestLedgerCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nano-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	stats, err := runCommand(t, "ledger", "stats", "-data", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stats, "Blocks: 1\n") || !strings.Contains(stats, "Accounts: 1\n") {
+		t.Errorf("Unexpected stats:\n%s", stats)
+	}
+
+	if _, err := runCommand(t, "ledger", "check", "-data", dir); err != nil {
+		t.Errorf("Genesis ledger failed check: %s", err)
+	}
+
+	export, err := runCommand(t, "ledger", "export", "-data", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(export), "\n")
+	if len(lines) != 1 {
+		t.Fatalf("Expected one exported block, got %d", len(lines))
+	}
+	block, err := blocks.ParseBlock([]byte(lines[0]))
+	if err != nil || block.Hash() != blocks.TestGenesisBlock.Hash() {
+		t.Errorf("Exported the wrong block %s: %v", lines[0], err)
+	}
+
+	// A ledger held by another process is reported rather than crashing
+	opts := badger.DefaultOptions
+	opts.Dir = dir
+	opts.ValueDir = dir
+	held, err := badger.Open(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	if _, err := runCommand(t, "ledger", "stats", "-data", dir); err == nil {
+		t.Errorf("Opened a ledger which was already in use")
+	}
+}
+
+func TestNodeStartFailure(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nano-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	busy, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer busy.Close()
+
+	// A server failing to start stops the node and closes the store
+	_, err = runCommand(t, "node", "run", "-data", dir, "-port", "0", "-rpc", "-rpc-address", busy.Addr().String())
+	if err == nil {
+		t.Fatalf("Started the rpc server on a busy address")
+	}
+	if _, err := runCommand(t, "ledger", "stats", "-data", dir); err != nil {
+		t.Errorf("Ledger wasn't released: %s", err)
+	}
+}
+
+func TestWalletCommands(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nano-cli")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	os.Setenv("NANO_WALLET_PASSWORD", "password")
+	defer os.Unsetenv("NANO_WALLET_PASSWORD")
+
+	created, err := runCommand(t, "wallet", "create", "-data", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommand(t, "wallet", "create", "-data", dir, "-key", "not a key"); err == nil {
+		t.Errorf("Accepted an invalid key")
+	}
+
+	list, err := runCommand(t, "wallet", "list", "-data", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if list != strings.TrimSpace(created)+" balance 0 pending 0\n" {
+		t.Errorf("Unexpected wallet list %q", list)
+	}
+
+	seeded, err := runCommand(t, "wallet", "create", "-data", dir, "-new-seed")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(seeded), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "Seed ") {
+		t.Fatalf("Unexpected seed output %q", seeded)
+	}
+	if list, _ = runCommand(t, "wallet", "list", "-data", dir); !strings.Contains(list, lines[1]+" balance 0") {
+		t.Errorf("Seed account missing from %q", list)
+	}
+
+	mnemonic, err := runCommand(t, "wallet", "create", "-data", dir, "-new-mnemonic")
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines = strings.Split(strings.TrimSpace(mnemonic), "\n")
+	if len(lines) != 2 || len(strings.Fields(lines[0])) != 25 {
+		t.Fatalf("Unexpected mnemonic output %q", mnemonic)
+	}
+	if list, _ = runCommand(t, "wallet", "list", "-data", dir); !strings.Contains(list, lines[1]+" balance 0") {
+		t.Errorf("Mnemonic account missing from %q", list)
+	}
+
+	os.Setenv("NANO_WALLET_NEW_PASSWORD", "changed")
+	defer os.Unsetenv("NANO_WALLET_NEW_PASSWORD")
+	if _, err := runCommand(t, "wallet", "password", "-data", dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommand(t, "wallet", "list", "-data", dir); err == nil {
+		t.Errorf("Old password still unlocks the wallet")
+	}
+
+	exported := filepath.Join(dir, "exported.json")
+	if _, err := runCommand(t, "wallet", "export", "-data", dir, "-out", exported); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("NANO_WALLET_PASSWORD", "changed")
+	imported := filepath.Join(dir, "imported")
+	if _, err := runCommand(t, "wallet", "import", "-data", imported, exported); err != nil {
+		t.Fatal(err)
+	}
+	if list, _ = runCommand(t, "wallet", "list", "-data", imported); !strings.Contains(list, strings.TrimSpace(created)) {
+		t.Errorf("Imported wallet is missing accounts: %q", list)
+	}
+}
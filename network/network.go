@@ -0,0 +1,180 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package network
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/types"
+)
+
+// Everything which distinguishes one nano network from another. Nodes on
+// different networks ignore each other's messages and can't share a ledger.
+type Network struct {
+	Name string
+	// The first two bytes of every message header
+	MagicNumber [2]byte
+	// The open block which creates the genesis account and its balance
+	GenesisBlock *blocks.OpenBlock
+	// Minimum difficulty of send and change blocks' work
+	WorkThreshold blocks.Difficulty
+	// Open and receive blocks need work this many times easier than
+	// WorkThreshold. Zero is treated as one, i.e. no lower threshold.
+	ReceiveWorkMultiplier float64
+	// UDP port nodes listen on unless configured otherwise
+	DefaultPort uint16
+	// host:port addresses contacted when we have no other peers
+	BootstrapPeers []string
+}
+
+var Live = &Network{
+	Name:          "live",
+	MagicNumber:   [2]byte{'R', 'C'},
+	GenesisBlock:  blocks.LiveGenesisBlock,
+	WorkThreshold: 0xffffffc000000000,
+	DefaultPort:   7075,
+	BootstrapPeers: []string{
+		"peering.nano.org:7075",
+		"94.130.105.241:7075",
+		"77.171.82.118:7075",
+	},
+}
+
+var Beta = &Network{
+	Name:          "beta",
+	MagicNumber:   [2]byte{'R', 'B'},
+	GenesisBlock:  BetaGenesisBlock,
+	WorkThreshold: 0xffffffc000000000,
+	DefaultPort:   54000,
+	BootstrapPeers: []string{
+		"peering-beta.nano.org:54000",
+	},
+}
+
+// A local network for testing, whose genesis key is blocks.TestPrivateKey
+var Test = &Network{
+	Name:           "test",
+	MagicNumber:    [2]byte{'R', 'A'},
+	GenesisBlock:   blocks.TestGenesisBlock,
+	WorkThreshold:  0xff00000000000000,
+	DefaultPort:    44000,
+	BootstrapPeers: []string{},
+
+	ReceiveWorkMultiplier: testReceiveWorkMultiplier,
+}
+
+var BetaGenesisBlock = blocks.FromJson([]byte(`{
+	"type":           "open",
+	"source":         "A59A47CC4F593E75AE9AD653FDA9358E2F7898D9ACC8C60E80D0495CE20FBA9F",
+	"representative": "nano_3betaz86ypbygpqbookmzpnmd5jhh4efmd8arr9a3n4bdmj1zgnzad7xpmfp",
+	"account":        "nano_3betaz86ypbygpqbookmzpnmd5jhh4efmd8arr9a3n4bdmj1zgnzad7xpmfp",
+	"work":           "000000000f0aaeeb",
+	"signature":      "A726490E3325E4FA59C1C900D5B6EEBB15FE13D99F49D475B93F0AACC5635929A0614CF3892764A04D1C6732A0D716FFEB254D4154C6F544D11E6630F201450B"
+}`)).(*blocks.OpenBlock)
+
+// Work threshold for dev networks, low enough to generate blocks quickly
+const DevWorkThreshold = blocks.Difficulty(0xff00000000000000)
+
+// Private networks have a receive threshold 64 times easier than sending,
+// the same ratio as the reference node's epoch 2 thresholds
+const testReceiveWorkMultiplier = 1.0 / 64
+
+const DevPort = 17075
+
+// Creates a private network whose genesis account is owned by the given
+// private key. If the key is empty a new one is generated, and the key used
+// is always returned. The genesis block is derived from the key, so other
+// nodes join the network by being given the same key.
+func NewDev(private string) (*Network, string, error) {
+	if private == "" {
+		_, priv := address.GenerateKey()
+		private = strings.ToUpper(hex.EncodeToString(priv[:32]))
+	}
+	if len(private) != 64 {
+		return nil, "", errors.New("Invalid genesis private key")
+	}
+	if _, err := hex.DecodeString(private); err != nil {
+		return nil, "", errors.New("Invalid genesis private key")
+	}
+
+	pub, priv := address.KeypairFromPrivateKey(private)
+	account := address.PubKeyToAddress(pub)
+
+	genesis := &blocks.OpenBlock{
+		SourceHash:     types.BlockHashFromBytes(pub),
+		Representative: account,
+		Account:        account,
+	}
+	genesis.Work = blocks.GenerateWorkForThreshold(genesis.RootHash(), DevWorkThreshold)
+	genesis.Signature = genesis.Hash().Sign(priv)
+
+	return Dev(genesis), private, nil
+}
+
+// Returns the private network created with the given genesis block
+func Dev(genesis *blocks.OpenBlock) *Network {
+	return &Network{
+		Name:           "dev",
+		MagicNumber:    [2]byte{'R', 'D'},
+		GenesisBlock:   genesis,
+		WorkThreshold:  DevWorkThreshold,
+		DefaultPort:    DevPort,
+		BootstrapPeers: []string{},
+
+		ReceiveWorkMultiplier: testReceiveWorkMultiplier,
+	}
+}
+
+// Returns one of the public networks by name
+func ByName(name string) (*Network, error) {
+	switch strings.ToLower(name) {
+	case Live.Name:
+		return Live, nil
+	case Beta.Name:
+		return Beta, nil
+	case Test.Name:
+		return Test, nil
+	default:
+		return nil, errors.Errorf("Unknown network %s", name)
+	}
+}
+
+// The network the node is running on
+var Active = Live
+
+// Makes n the active network, applying its work thresholds
+func Select(n *Network) {
+	Active = n
+	blocks.WorkThreshold = n.WorkThreshold
+	blocks.ReceiveWorkMultiplier = 1
+	if n.ReceiveWorkMultiplier > 0 {
+		blocks.ReceiveWorkMultiplier = n.ReceiveWorkMultiplier
+	}
+}
@@ -0,0 +1,95 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package network
+
+import (
+	"testing"
+
+	"github.com/svaishnavy/nano/blocks"
+)
+
+func validGenesis(t *testing.T, n *Network) {
+	genesis := n.GenesisBlock
+	if ok, _ := genesis.VerifySignature(); !ok {
+		t.Errorf("%s genesis signature is invalid", n.Name)
+	}
+
+	previous := blocks.WorkThreshold
+	blocks.WorkThreshold = n.WorkThreshold
+	defer func() { blocks.WorkThreshold = previous }()
+	if !blocks.ValidateBlockWork(genesis) {
+		t.Errorf("%s genesis work is invalid", n.Name)
+	}
+}
+
+func TestGenesisBlocks(t *testing.T) {
+	for _, n := range []*Network{Live, Beta, Test} {
+		validGenesis(t, n)
+	}
+}
+
+func TestNewDev(t *testing.T) {
+	dev, key, err := NewDev(blocks.TestPrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key != blocks.TestPrivateKey {
+		t.Errorf("Dev network used key %s", key)
+	}
+	validGenesis(t, dev)
+
+	// The genesis is derived entirely from the key
+	again, _, _ := NewDev(blocks.TestPrivateKey)
+	if again.GenesisBlock.Hash() != dev.GenesisBlock.Hash() {
+		t.Errorf("Dev genesis blocks differ for the same key")
+	}
+
+	generated, key, err := NewDev("")
+	if err != nil || len(key) != 64 {
+		t.Fatalf("Failed to generate dev network: %v", err)
+	}
+	validGenesis(t, generated)
+	if generated.GenesisBlock.Hash() == dev.GenesisBlock.Hash() {
+		t.Errorf("Generated dev network reused a genesis block")
+	}
+
+	_, _, err = NewDev("1234")
+	if err == nil {
+		t.Errorf("Accepted invalid genesis key")
+	}
+}
+
+func TestByName(t *testing.T) {
+	n, err := ByName("Beta")
+	if err != nil || n != Beta {
+		t.Errorf("Failed to find beta network")
+	}
+
+	_, err = ByName("nonsense")
+	if err == nil {
+		t.Errorf("Found unknown network")
+	}
+}
@@ -0,0 +1,426 @@
+package node
+
+import (
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+// How long an election may run without reaching quorum before it's dropped
+const electionTimeout = 5 * time.Minute
+
+// How long a representative counts towards the online weight after voting
+const onlineWindow = 5 * time.Minute
+
+// Percentage of the online voting weight a block needs to be confirmed
+var QuorumPercent = int64(50)
+
+// The online weight never drops below this (60 million Nano) when
+// calculating quorum, so a handful of representatives can't confirm
+// blocks on their own while the rest of the network is out of sight.
+var OnlineWeightMinimum, _ = uint128.FromString("2d239465031da91605b947c000000000")
+
+type ConfirmationObserver func(block blocks.Block)
+
+// Called with every valid vote received, whether or not it's counted
+type VoteObserver func(vote *MessageVote)
+
+type electionVote struct {
+	hash     types.BlockHash
+	sequence uint64
+}
+
+// An election decides which of the competing blocks for a root is
+// accepted into the ledger.
+type Election struct {
+	Root      types.BlockHash
+	Blocks    map[types.BlockHash]blocks.Block
+	votes     map[types.Account]electionVote
+	started   time.Time
+	confirmed bool
+}
+
+type Elections struct {
+	mutex     sync.Mutex
+	roots     map[types.BlockHash]*Election
+	online    map[types.Account]time.Time
+	observers []*ConfirmationObserver
+	voters    []*VoteObserver
+	waiters   map[types.BlockHash][]chan bool
+}
+
+var ActiveElections = NewElections()
+
+func NewElections() *Elections {
+	return &Elections{
+		roots:   make(map[types.BlockHash]*Election),
+		online:  make(map[types.Account]time.Time),
+		waiters: make(map[types.BlockHash][]chan bool),
+	}
+}
+
+// Registers a function to be called whenever an election confirms a block,
+// returning a function which removes it again
+func (e *Elections) Observe(fn ConfirmationObserver) func() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	observer := &fn
+	e.observers = append(e.observers, observer)
+
+	return func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		// Copied rather than removed in place, as confirmations may be
+		// calling the old list
+		var observers []*ConfirmationObserver
+		for _, o := range e.observers {
+			if o != observer {
+				observers = append(observers, o)
+			}
+		}
+		e.observers = observers
+	}
+}
+
+// Registers a function to be called with each valid vote, returning a
+// function which removes it again
+func (e *Elections) ObserveVotes(fn VoteObserver) func() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	voter := &fn
+	e.voters = append(e.voters, voter)
+
+	return func() {
+		e.mutex.Lock()
+		defer e.mutex.Unlock()
+		var voters []*VoteObserver
+		for _, v := range e.voters {
+			if v != voter {
+				voters = append(voters, v)
+			}
+		}
+		e.voters = voters
+	}
+}
+
+// Starts an election for the block's root, or adds the block as a new
+// candidate if an election is already running.
+func (e *Elections) Start(block blocks.Block) *Election {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.start(block)
+}
+
+func (e *Elections) start(block blocks.Block) *Election {
+	root := block.RootHash()
+	election := e.roots[root]
+	if election == nil {
+		election = &Election{
+			Root:    root,
+			Blocks:  make(map[types.BlockHash]blocks.Block),
+			votes:   make(map[types.Account]electionVote),
+			started: time.Now(),
+		}
+		e.roots[root] = election
+		electionsStarted.Inc()
+	}
+
+	if _, ok := election.Blocks[block.Hash()]; !ok {
+		election.Blocks[block.Hash()] = block
+	}
+	return election
+}
+
+// Returns a channel which receives true when the block is confirmed, or
+// false if a competing block is confirmed in its place or the block won but
+// couldn't be stored.
+func (e *Elections) WaitFor(hash types.BlockHash) <-chan bool {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	c := make(chan bool, 1)
+	e.waiters[hash] = append(e.waiters[hash], c)
+	return c
+}
+
+// Stops waiting on a channel returned by WaitFor
+func (e *Elections) StopWaiting(hash types.BlockHash, c <-chan bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	waiters := e.waiters[hash]
+	for i, waiter := range waiters {
+		if waiter == c {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(e.waiters, hash)
+	} else {
+		e.waiters[hash] = waiters
+	}
+}
+
+func (e *Elections) Get(root types.BlockHash) *Election {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.roots[root]
+}
+
+// The number of elections currently running
+func (e *Elections) Len() int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return len(e.roots)
+}
+
+// The work difficulty the network currently needs, as the reference node
+// reports it: the average multiplier over the best work in each running
+// election, never below the minimum threshold.
+func (e *Elections) Difficulty() blocks.Difficulty {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	if len(e.roots) == 0 {
+		return blocks.WorkThreshold
+	}
+
+	// Multipliers are the base distance from the maximum over each
+	// difficulty's distance, so their average comes back to a distance as
+	// the harmonic mean of the distances. That's worked out exactly, as
+	// float64 can't hold a 64 bit distance.
+	total := new(big.Rat)
+	for _, election := range e.roots {
+		best := blocks.Difficulty(0)
+		for _, block := range election.Blocks {
+			if d := blocks.BlockDifficulty(block); d > best {
+				best = d
+			}
+		}
+		total.Add(total, new(big.Rat).SetFrac(big.NewInt(1), distance(best)))
+	}
+	mean := new(big.Rat).SetInt64(int64(len(e.roots)))
+	mean.Quo(mean, total)
+	average := new(big.Int).Quo(mean.Num(), mean.Denom())
+	if average.Cmp(distance(blocks.WorkThreshold)) >= 0 {
+		return blocks.WorkThreshold
+	}
+	return blocks.Difficulty(-average.Uint64())
+}
+
+// How far a difficulty is from the maximum, 2^64
+func distance(d blocks.Difficulty) *big.Int {
+	max := new(big.Int).Lsh(big.NewInt(1), 64)
+	return max.Sub(max, new(big.Int).SetUint64(uint64(d)))
+}
+
+// Returns the block currently leading the election for a root, or nil if
+// no election is running.
+func (e *Elections) Winner(root types.BlockHash) blocks.Block {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	election := e.roots[root]
+	if election == nil {
+		return nil
+	}
+	winner, _ := election.tally()
+	return winner
+}
+
+// Validates a vote and counts it towards the election for its block's root.
+// The vote's block must already have an election.
+func (e *Elections) Vote(vote *MessageVote) error {
+	if !vote.Verify() {
+		return errors.New("Invalid vote signature")
+	}
+
+	block := vote.ToBlock()
+	if block == nil {
+		return errors.New("Invalid vote block")
+	}
+	rep := address.PubKeyToAddress(vote.Account[:])
+	sequence := vote.SequenceNumber()
+
+	e.mutex.Lock()
+	voters := e.voters
+	e.mutex.Unlock()
+	for _, fn := range voters {
+		(*fn)(vote)
+	}
+
+	e.mutex.Lock()
+	election := e.roots[block.RootHash()]
+	if election == nil || election.confirmed {
+		e.mutex.Unlock()
+		return nil
+	}
+
+	previous, ok := election.votes[rep]
+	if ok && previous.sequence >= sequence {
+		e.mutex.Unlock()
+		return nil
+	}
+
+	if _, ok := election.Blocks[block.Hash()]; !ok {
+		// Votes can carry blocks we haven't seen published, which need to
+		// be as valid as those before they can win
+		if !blocks.ValidateBlockWork(block) || validateLocalBlock(block) != nil {
+			e.mutex.Unlock()
+			return errors.New("Invalid vote block")
+		}
+		election.Blocks[block.Hash()] = block
+	}
+	election.votes[rep] = electionVote{block.Hash(), sequence}
+	e.online[rep] = time.Now()
+
+	winner, tally := election.tally()
+	if tally.Cmp(e.quorum()) < 0 {
+		e.mutex.Unlock()
+		return nil
+	}
+
+	election.confirmed = true
+	electionsConfirmed.Inc()
+	observers := e.observers
+	e.mutex.Unlock()
+
+	err := election.confirm(winner)
+	if err != nil {
+		// Later votes can try again, but anyone waiting on the winner hears
+		// that it didn't make it
+		e.mutex.Lock()
+		election.confirmed = false
+		for _, c := range e.waiters[winner.Hash()] {
+			c <- false
+		}
+		delete(e.waiters, winner.Hash())
+		e.mutex.Unlock()
+		return err
+	}
+
+	e.mutex.Lock()
+	for hash := range election.Blocks {
+		for _, c := range e.waiters[hash] {
+			c <- hash == winner.Hash()
+		}
+		delete(e.waiters, hash)
+	}
+	e.mutex.Unlock()
+
+	for _, fn := range observers {
+		(*fn)(winner)
+	}
+	return nil
+}
+
+// Drops confirmed elections and those which have run too long
+func (e *Elections) Cleanup() {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+
+	cutoff := time.Now().Add(-electionTimeout)
+	for root, election := range e.roots {
+		if election.confirmed || election.started.Before(cutoff) {
+			if !election.confirmed {
+				electionsExpired.Inc()
+			}
+			delete(e.roots, root)
+		}
+	}
+
+	cutoff = time.Now().Add(-onlineWindow)
+	for rep, seen := range e.online {
+		if seen.Before(cutoff) {
+			delete(e.online, rep)
+		}
+	}
+}
+
+func CleanupElections(params []interface{}) {
+	ActiveElections.Cleanup()
+}
+
+// The voting weight of representatives which have voted recently
+func (e *Elections) OnlineWeight() *big.Int {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	return e.onlineWeight()
+}
+
+func (e *Elections) onlineWeight() *big.Int {
+	total := new(big.Int)
+	for rep := range e.online {
+		total.Add(total, toBig(store.GetWeight(rep)))
+	}
+	return total
+}
+
+func (e *Elections) quorum() *big.Int {
+	online := e.onlineWeight()
+	minimum := toBig(OnlineWeightMinimum)
+	if online.Cmp(minimum) < 0 {
+		online = minimum
+	}
+	quorum := online.Mul(online, big.NewInt(QuorumPercent))
+	return quorum.Div(quorum, big.NewInt(100))
+}
+
+// Returns the block with the most voting weight behind it, and that weight
+func (election *Election) tally() (blocks.Block, *big.Int) {
+	tallies := make(map[types.BlockHash]*big.Int)
+	for rep, vote := range election.votes {
+		if tallies[vote.hash] == nil {
+			tallies[vote.hash] = new(big.Int)
+		}
+		tallies[vote.hash].Add(tallies[vote.hash], toBig(store.GetWeight(rep)))
+	}
+
+	var winner blocks.Block
+	highest := new(big.Int)
+	for hash, block := range election.Blocks {
+		tally := tallies[hash]
+		if tally == nil {
+			tally = new(big.Int)
+		}
+		// Ties are broken on hash so every node settles on the same winner
+		cmp := tally.Cmp(highest)
+		if winner == nil || cmp > 0 || (cmp == 0 && hash < winner.Hash()) {
+			winner = block
+			highest = tally
+		}
+	}
+	return winner, highest
+}
+
+// Replaces whichever block holds the root in the ledger with the winner and
+// marks it confirmed. The winner is checked first, so an invalid block can
+// never roll back a valid one.
+func (election *Election) confirm(winner blocks.Block) error {
+	if !blocks.ValidateBlockWork(winner) {
+		return errors.New("Invalid work for winning block")
+	}
+	err := validateLocalBlock(winner)
+	if err != nil {
+		return err
+	}
+
+	err = store.ConfirmWinner(winner)
+	if err != nil {
+		return err
+	}
+	electionLog.Debugf("Confirmed block %s", winner.Hash())
+	return nil
+}
+
+func toBig(u uint128.Uint128) *big.Int {
+	return new(big.Int).SetBytes(u.GetBytes())
+}
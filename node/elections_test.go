@@ -0,0 +1,154 @@
+package node
+
+import (
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/svaishnavy/crypto/ed25519"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+func testSend(previous blocks.Block, balance uint128.Uint128) *blocks.SendBlock {
+	_, priv := address.KeypairFromPrivateKey(blocks.TestPrivateKey)
+	send := blocks.SendBlock{
+		PreviousHash: previous.Hash(),
+		Destination:  blocks.TestGenesisBlock.Account,
+		Balance:      balance,
+	}
+	send.Work = blocks.GenerateWork(previous)
+	send.Signature = send.Hash().Sign(priv)
+	return &send
+}
+
+func testVote(b blocks.Block, sequence uint64, priv ed25519.PrivateKey) *MessageVote {
+	vote := MessageVote{MessageBlock: NewMessageBlock(b)}
+	copy(vote.Account[:], priv[32:])
+	binary.LittleEndian.PutUint64(vote.Sequence[:], sequence)
+	copy(vote.Signature[:], ed25519.Sign(priv, vote.Hash()))
+	return &vote
+}
+
+func TestElectionResolvesFork(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	ActiveElections = NewElections()
+	_, priv := address.KeypairFromPrivateKey(blocks.TestPrivateKey)
+
+	send := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	fork := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 2)))
+
+	if err := processBlock(send); err != nil {
+		t.Errorf("Failed to process send: %s", err)
+	}
+	if processBlock(fork) != store.ErrFork {
+		t.Errorf("Expected fork")
+	}
+	if len(ActiveElections.Get(send.RootHash()).Blocks) != 2 {
+		t.Errorf("Fork didn't join the election")
+	}
+
+	_, other := address.GenerateKey()
+	if ActiveElections.Vote(testVote(fork, 1, other)) != nil {
+		t.Errorf("Vote from an unweighted representative should be accepted")
+	}
+	badVote := testVote(fork, 1, priv)
+	badVote.Sequence[0]++
+	if ActiveElections.Vote(badVote) == nil {
+		t.Errorf("Vote with a bad signature was accepted")
+	}
+
+	var confirmed []blocks.Block
+	ActiveElections.Observe(func(b blocks.Block) {
+		confirmed = append(confirmed, b)
+	})
+	removed := false
+	ActiveElections.Observe(func(b blocks.Block) {
+		removed = true
+	})()
+
+	if err := ActiveElections.Vote(testVote(fork, 1, priv)); err != nil {
+		t.Errorf("Failed to count vote: %s", err)
+	}
+	if len(confirmed) != 1 || confirmed[0].Hash() != fork.Hash() {
+		t.Errorf("Election didn't confirm the fork")
+	}
+	if removed {
+		t.Errorf("Removed observer was called")
+	}
+	if store.FetchBlock(send.Hash()) != nil {
+		t.Errorf("Losing block wasn't rolled back")
+	}
+	if b := store.FetchBlock(fork.Hash()); b == nil || !b.IsConfirmed() {
+		t.Errorf("Winning block wasn't confirmed in the store")
+	}
+	os.RemoveAll(store.TestConfig.Path)
+}
+
+func TestVoteBlockValidation(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	defer os.RemoveAll(store.TestConfig.Path)
+	ActiveElections = NewElections()
+	_, priv := address.KeypairFromPrivateKey(blocks.TestPrivateKey)
+
+	send := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	processBlock(send)
+
+	// A representative can't slip in a block nobody signed
+	forged := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 2)))
+	forged.Signature = send.Signature
+	if ActiveElections.Vote(testVote(forged, 1, priv)) == nil {
+		t.Errorf("Vote for a forged block was accepted")
+	}
+	if _, ok := ActiveElections.Get(send.RootHash()).Blocks[forged.Hash()]; ok {
+		t.Errorf("Forged block joined the election")
+	}
+	if b := store.FetchBlock(send.Hash()); b == nil || b.IsConfirmed() {
+		t.Errorf("Forged block affected the ledger")
+	}
+}
+
+func TestVoteSequence(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	ActiveElections = NewElections()
+	QuorumPercent = 200
+	defer func() { QuorumPercent = 50 }()
+	_, priv := address.KeypairFromPrivateKey(blocks.TestPrivateKey)
+
+	send := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	fork := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 2)))
+	processBlock(send)
+	processBlock(fork)
+
+	ActiveElections.Vote(testVote(send, 2, priv))
+	ActiveElections.Vote(testVote(fork, 1, priv))
+	if ActiveElections.Winner(send.RootHash()).Hash() != send.Hash() {
+		t.Errorf("Older vote replaced a newer one")
+	}
+
+	ActiveElections.Vote(testVote(fork, 3, priv))
+	if ActiveElections.Winner(send.RootHash()).Hash() != fork.Hash() {
+		t.Errorf("Newer vote didn't replace the older one")
+	}
+	os.RemoveAll(store.TestConfig.Path)
+}
+
+func TestElectionsDifficulty(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	elections := NewElections()
+	if elections.Difficulty() != blocks.WorkThreshold {
+		t.Errorf("Idle network needs more than the minimum difficulty")
+	}
+
+	send := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	send.Work = blocks.GenerateWorkForThreshold(send.RootHash(), blocks.FromMultiplier(16, blocks.WorkThreshold))
+	elections.Start(send)
+	if d := elections.Difficulty(); d.Multiplier(blocks.WorkThreshold) < 16 || d != blocks.BlockDifficulty(send) {
+		t.Errorf("Wrong active difficulty %s", d)
+	}
+}
@@ -0,0 +1,92 @@
+package node
+
+import (
+	"math"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/types"
+)
+
+// How many recently flooded blocks we remember, and for how long
+const recentBlocksSize = 65536
+const recentBlocksAge = 5 * time.Minute
+
+type recentBlocks struct {
+	mutex sync.Mutex
+	seen  map[types.BlockHash]time.Time
+}
+
+var recentlySeen = newRecentBlocks()
+
+func newRecentBlocks() *recentBlocks {
+	return &recentBlocks{seen: make(map[types.BlockHash]time.Time)}
+}
+
+// Records a block as seen, returning false if it had already been seen
+func (r *recentBlocks) Add(hash types.BlockHash) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if seen, ok := r.seen[hash]; ok && now.Sub(seen) < recentBlocksAge {
+		return false
+	}
+
+	if len(r.seen) >= recentBlocksSize {
+		r.prune(now)
+	}
+	r.seen[hash] = now
+	return true
+}
+
+// Forgets a block, so it's handled again if it's republished
+func (r *recentBlocks) Remove(hash types.BlockHash) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	delete(r.seen, hash)
+}
+
+func (r *recentBlocks) prune(now time.Time) {
+	for hash, seen := range r.seen {
+		if now.Sub(seen) >= recentBlocksAge {
+			delete(r.seen, hash)
+		}
+	}
+
+	// Everything is recent, drop arbitrary entries to make room
+	for hash := range r.seen {
+		if len(r.seen) < recentBlocksSize {
+			break
+		}
+		delete(r.seen, hash)
+	}
+}
+
+func CreatePublish(block blocks.Block) *MessagePublish {
+	var m MessagePublish
+	m.MessageBlock = NewMessageBlock(block)
+	m.MessageHeader = newHeader(Message_publish, m.MessageBlock.Type)
+	return &m
+}
+
+// Picks a random subset of peers, sized to the square root of the peer list
+// so a block reaches the whole network in a few hops without every node
+// sending it to every peer.
+func floodPeers(exclude *net.UDPAddr) []Peer {
+	count := int(math.Ceil(math.Sqrt(float64(Peers.Len()))))
+	return Peers.Random(count, exclude)
+}
+
+// Republishes a block to a random subset of peers
+func FloodBlock(block blocks.Block, exclude *net.UDPAddr) {
+	m := CreatePublish(block)
+	for _, peer := range floodPeers(exclude) {
+		err := peer.SendMessage(m)
+		if err != nil {
+			netLog.Warnf("Failed to flood block to %s: %s", peer.String(), err)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package node
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+func TestCreatePublish(t *testing.T) {
+	for _, raw := range [][]byte{publishSend, publishReceive, publishOpen, publishChange} {
+		var m MessagePublish
+		if err := m.Read(bytes.NewBuffer(raw)); err != nil {
+			t.Errorf("Failed to read publish: %s", err)
+		}
+
+		var buf bytes.Buffer
+		if err := CreatePublish(m.ToBlock()).Write(&buf); err != nil {
+			t.Errorf("Failed to write publish: %s", err)
+		}
+		if !bytes.Equal(raw[8:], buf.Bytes()[8:]) {
+			t.Errorf("Republished block differs from original\n%x\n%x", raw[8:], buf.Bytes()[8:])
+		}
+	}
+}
+
+func TestRecentBlocks(t *testing.T) {
+	recent := newRecentBlocks()
+	if !recent.Add("A") || recent.Add("A") || !recent.Add("B") {
+		t.Errorf("Recently seen cache didn't filter duplicates")
+	}
+}
+
+func TestDroppedPublish(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	savedSeen, savedProcessor, savedPeers := recentlySeen, Processor, Peers
+	defer func() { recentlySeen, Processor, Peers = savedSeen, savedProcessor, savedPeers }()
+	recentlySeen = newRecentBlocks()
+	Peers = NewPeerTable()
+	Processor = NewBlockProcessor(1, 1)
+	Processor.Add(testSend(blocks.TestGenesisBlock, uint128.FromInts(0, 1)), nil)
+
+	// Publishes dropped while the processor is full are handled again
+	// when they're republished
+	block := testSend(blocks.TestGenesisBlock, uint128.FromInts(0, 2))
+	var buf bytes.Buffer
+	CreatePublish(block).Write(&buf)
+	handleMessage(&buf, &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 7075})
+	if !recentlySeen.Add(block.Hash()) {
+		t.Errorf("Dropped block was remembered as seen")
+	}
+}
+
+func TestFloodPeers(t *testing.T) {
+	saved := Peers
+	defer func() { Peers = saved }()
+
+	Peers = NewPeerTable()
+	for i := 1; i <= 9; i++ {
+		Peers.Add(Peer{IP: net.IPv4(10, 0, byte(i), 1), Port: 7075})
+	}
+
+	if len(floodPeers(nil)) != 3 {
+		t.Errorf("Should flood to the square root of the peer count")
+	}
+
+	exclude := &net.UDPAddr{IP: net.IPv4(10, 0, 0, 1), Port: 7075}
+	for i := 0; i < 20; i++ {
+		for _, peer := range floodPeers(exclude) {
+			if peer.IP.Equal(exclude.IP) {
+				t.Errorf("Flooded a block back to its sender")
+			}
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net"
+	"os"
+	"time"
+
+	"github.com/svaishnavy/nano/network"
+)
+
+// How often the node's periodic tasks run
+var (
+	KeepAliveInterval       = 20 * time.Second
+	ElectionCleanupInterval = time.Minute
+	PeerPurgeInterval       = time.Minute
+	PeerSaveInterval        = 5 * time.Minute
+)
+
+// Starts listening for messages and running the node's periodic tasks. The
+// node runs until Stop is called or ctx is cancelled, and can't be
+// restarted afterwards.
+func (node *Node) Start(ctx context.Context) error {
+	node.mutex.Lock()
+	defer node.mutex.Unlock()
+
+	if node.running {
+		return errors.New("Node already started")
+	}
+	if node.ctx.Err() != nil {
+		return errors.New("Node has been stopped")
+	}
+
+	if node.PeersFile != "" {
+		err := Peers.Load(node.PeersFile)
+		if err != nil && !os.IsNotExist(err) {
+			netLog.Warnf("Failed to load peers: %s", err)
+		}
+	}
+
+	addr := node.ListenAddr
+	if addr == nil {
+		addr = &net.UDPAddr{Port: int(network.Active.DefaultPort)}
+	}
+	udp, err := net.ListenUDP("udp", addr)
+	if err != nil {
+		return err
+	}
+	netLog.Infof("Listening for udp packets on %s", udp.LocalAddr())
+	connMutex.Lock()
+	conn = udp
+	nodeIdKey = node.privK
+	connMutex.Unlock()
+
+	node.running = true
+	Processor.Start()
+
+	node.alarms = []*Alarm{
+		NewAlarm(AlarmFn(SendKeepAlives), nil, KeepAliveInterval),
+		NewAlarm(AlarmFn(CleanupElections), nil, ElectionCleanupInterval),
+		NewAlarm(AlarmFn(PurgePeers), nil, PeerPurgeInterval),
+	}
+	if node.PeersFile != "" {
+		node.alarms = append(node.alarms, NewAlarm(AlarmFn(SavePeers), []interface{}{node.PeersFile}, PeerSaveInterval))
+	}
+
+	node.workers.Add(1)
+	go node.listen(udp)
+
+	go func() {
+		select {
+		case <-ctx.Done():
+			node.Stop()
+		case <-node.ctx.Done():
+		}
+	}()
+	return nil
+}
+
+func (node *Node) listen(udp *net.UDPConn) {
+	defer node.workers.Done()
+	buf := make([]byte, packetSize)
+
+	for {
+		n, source, err := udp.ReadFromUDP(buf)
+		if err != nil {
+			if node.ctx.Err() != nil {
+				return
+			}
+			netLog.Errorf("UDP read error: %s", err)
+			continue
+		}
+		if n > 0 {
+			packetLog.Debugf("Received message from %s", source)
+			handleMessage(bytes.NewBuffer(buf[:n]), source)
+		}
+	}
+}
+
+// Stops the listener and periodic tasks, waits for in flight work to
+// finish and saves the peer table. The store is left open for the caller to
+// close.
+func (node *Node) Stop() {
+	node.mutex.Lock()
+	if !node.running {
+		node.mutex.Unlock()
+		return
+	}
+	node.running = false
+	node.cancel()
+
+	connMutex.RLock()
+	conn.Close()
+	connMutex.RUnlock()
+	for _, alarm := range node.alarms {
+		alarm.Stop()
+	}
+	node.alarms = nil
+	node.mutex.Unlock()
+
+	// Blocks still being processed may send messages, so the socket is
+	// only forgotten once they're done
+	node.workers.Wait()
+	Processor.Stop()
+	connMutex.Lock()
+	conn = nil
+	nodeIdKey = nil
+	connMutex.Unlock()
+
+	if node.PeersFile != "" {
+		err := Peers.Save(node.PeersFile)
+		if err != nil {
+			netLog.Warnf("Failed to save peers: %s", err)
+		}
+	}
+
+	netLog.Infof("Node stopped")
+	close(node.done)
+}
+
+// Returns a channel which is closed once the node has stopped
+func (node *Node) Done() <-chan struct{} {
+	return node.done
+}
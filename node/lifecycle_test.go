@@ -0,0 +1,94 @@
+package node
+
+import (
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+func TestStartStop(t *testing.T) {
+	dir, err := ioutil.TempDir("", "nano-node")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	node := NewNode()
+	node.ListenAddr = &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	node.PeersFile = filepath.Join(dir, "peers.json")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	err = node.Start(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if node.Start(ctx) == nil {
+		t.Errorf("Node started twice")
+	}
+
+	cancel()
+	select {
+	case <-node.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Node didn't stop when its context was cancelled")
+	}
+
+	if conn != nil {
+		t.Errorf("Node left its udp connection open")
+	}
+	if _, err := os.Stat(node.PeersFile); err != nil {
+		t.Errorf("Peers weren't saved on shutdown: %s", err)
+	}
+	if node.Start(context.Background()) == nil {
+		t.Errorf("Stopped node was restarted")
+	}
+
+	// Stopping again is harmless
+	node.Stop()
+}
+
+func TestStopWithQueuedBlocks(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	defer os.RemoveAll(store.TestConfig.Path)
+	saved := Peers
+	defer func() { Peers = saved }()
+	Peers = NewPeerTable()
+	Peers.Add(Peer{IP: net.ParseIP("127.0.0.1"), Port: 7075})
+	savedProcessor := Processor
+	defer func() { Processor = savedProcessor }()
+	Processor = NewBlockProcessor(DefaultProcessorQueueSize, 1)
+
+	node := NewNode()
+	node.ListenAddr = &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	if err := node.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Blocks stored while stopping are still flooded, without racing the
+	// socket being closed
+	previous := blocks.Block(blocks.TestGenesisBlock)
+	balance := blocks.GenesisAmount
+	for i := 0; i < 200; i++ {
+		balance = balance.Sub(uint128.FromInts(0, 1))
+		send := testSend(previous, balance)
+		Processor.Add(send, func(err error) {
+			if err == nil {
+				FloodBlock(send, nil)
+			}
+		})
+		previous = send
+	}
+	for store.FetchBlock(blocks.TestGenesisBlock.Hash()) != nil && store.FetchAccountInfo(blocks.TestGenesisBlock.Account).Head == blocks.TestGenesisBlock.Hash() {
+		time.Sleep(time.Millisecond)
+	}
+	node.Stop()
+}
@@ -0,0 +1,86 @@
+package node
+
+import (
+	"time"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/metrics"
+	"github.com/svaishnavy/nano/store"
+)
+
+var (
+	messagesReceived = metrics.NewCounter("nano_messages_received_total", "Messages received from peers", "type")
+	messagesSent     = metrics.NewCounter("nano_messages_sent_total", "Messages sent to peers", "type")
+	parseFailures    = metrics.NewCounter("nano_message_parse_failures_total", "Messages which couldn't be read", "type")
+	blocksProcessed  = metrics.NewCounter("nano_blocks_processed_total", "Blocks processed, by result", "result")
+	blocksDropped    = metrics.NewCounter("nano_blocks_dropped_total", "Blocks dropped because the processor queue was full")
+
+	electionsStarted   = metrics.NewCounter("nano_elections_started_total", "Elections started")
+	electionsConfirmed = metrics.NewCounter("nano_elections_confirmed_total", "Elections which reached quorum")
+	electionsExpired   = metrics.NewCounter("nano_elections_expired_total", "Elections dropped without reaching quorum")
+
+	_ = metrics.NewGaugeFunc("nano_elections_active", "Elections currently running", func() float64 {
+		return float64(ActiveElections.Len())
+	})
+	_ = metrics.NewGaugeFunc("nano_block_queue_length", "Blocks waiting to be processed", func() float64 {
+		return float64(Processor.Len())
+	})
+	_ = metrics.NewGaugeFunc("nano_peers", "Peers in the peer table", func() float64 {
+		return float64(Peers.Len())
+	})
+
+	// The ledger and work packages don't depend on metrics, so theirs are
+	// registered here
+	workDuration = metrics.NewHistogram("nano_work_generation_seconds", "Time taken to generate proof of work", metrics.DurationBuckets)
+
+	_ = metrics.NewGaugeFunc("nano_unchecked_blocks", "Blocks waiting for their parent block", func() float64 {
+		return float64(store.UncheckedCount())
+	})
+	_ = metrics.NewGaugeFunc("nano_store_size_bytes", "Size of the database on disk", func() float64 {
+		return float64(store.Size())
+	})
+)
+
+func init() {
+	blocks.WorkGenerated = func(d time.Duration) {
+		workDuration.Observe(d.Seconds())
+	}
+}
+
+var messageTypeNames = map[byte]string{
+	Message_invalid:           "invalid",
+	Message_not_a_type:        "not_a_type",
+	Message_keepalive:         "keepalive",
+	Message_publish:           "publish",
+	Message_confirm_req:       "confirm_req",
+	Message_confirm_ack:       "confirm_ack",
+	Message_bulk_pull:         "bulk_pull",
+	Message_bulk_push:         "bulk_push",
+	Message_frontier_req:      "frontier_req",
+	Message_bulk_pull_blocks:  "bulk_pull_blocks",
+	Message_node_id_handshake: "node_id_handshake",
+	Message_bulk_pull_account: "bulk_pull_account",
+}
+
+func messageTypeName(t byte) string {
+	if name, ok := messageTypeNames[t]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// Names the outcome of storing a block, in the reference node's terms
+func processResult(err error) string {
+	switch err {
+	case nil:
+		return "progress"
+	case store.ErrBlockExists:
+		return "old"
+	case store.ErrFork:
+		return "fork"
+	case store.ErrGap:
+		return "gap"
+	default:
+		return "invalid"
+	}
+}
@@ -4,15 +4,29 @@ import (
 	"bytes"
 	"encoding/binary"
 	"errors"
-	"fmt"
-	"log"
 	"net"
+	"strconv"
+	"sync"
 	"time"
 
+	"github.com/svaishnavy/crypto/ed25519"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/logging"
+	"github.com/svaishnavy/nano/network"
 	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
 )
 
-var MagicNumber = [2]byte{'R', 'C'}
+var (
+	netLog       = logging.New("net")
+	bootstrapLog = logging.New("bootstrap")
+	electionLog  = logging.New("elections")
+
+	// Per packet messages are sampled so a busy node doesn't flood the log
+	packetLog = netLog.Sample(100)
+)
+
+var MagicNumber = network.Active.MagicNumber
 
 const VersionMax = byte(0x0f)
 const VersionUsing = byte(0x0f)
@@ -46,7 +60,12 @@ const (
 type Peer struct {
 	IP           net.IP
 	Port         uint16
-	LastReachout *time.Time
+	LastContact  time.Time
+	LastAttempt  time.Time
+	VersionMax   byte
+	VersionUsing byte
+	VersionMin   byte
+	NodeId       types.Account
 }
 
 type MessageHeader struct {
@@ -94,81 +113,260 @@ type Message interface {
 	Write(buf *bytes.Buffer) error
 }
 
+func newHeader(messageType byte, blockType byte) MessageHeader {
+	var h MessageHeader
+	h.MagicNumber = MagicNumber
+	h.VersionMax = VersionMax
+	h.VersionUsing = VersionUsing
+	h.VersionMin = VersionMin
+	h.MessageType = messageType
+	h.Extensions = 0x0
+	h.BlockType = blockType
+	return h
+}
+
 func CreateKeepAlive(peers []Peer) *MessageKeepAlive {
 	var m MessageKeepAlive
-	m.MessageHeader.MagicNumber = MagicNumber
-	m.MessageHeader.VersionMax = VersionMax
-	m.MessageHeader.VersionUsing = VersionUsing
-	m.MessageHeader.VersionMin = VersionMin
-	m.MessageHeader.MessageType = Message_keepalive
-	m.MessageHeader.Extensions = 0x0
-	m.MessageHeader.BlockType = 0x0
+	m.MessageHeader = newHeader(Message_keepalive, 0x0)
 	m.Peers = peers
 	return &m
 }
 
 func (p *Peer) Addr() *net.UDPAddr {
-	addr, _ := net.ResolveUDPAddr("udp", fmt.Sprintf("%s:%d", p.IP.String(), p.Port))
+	addr, _ := net.ResolveUDPAddr("udp", p.String())
 	return addr
 }
 
 func (p *Peer) String() string {
-	return fmt.Sprintf("%s:%d", p.IP.String(), p.Port)
+	return net.JoinHostPort(p.IP.String(), strconv.Itoa(int(p.Port)))
 }
 
-func handleMessage(buf *bytes.Buffer) {
+func handleMessage(buf *bytes.Buffer, source *net.UDPAddr) {
 	var header MessageHeader
 	header.ReadHeader(bytes.NewBuffer(buf.Bytes()))
 	if header.MagicNumber != MagicNumber {
-		log.Printf("Ignored message. Wrong magic number %s", header.MagicNumber)
+		packetLog.Debugf("Ignored message. Wrong magic number %s", header.MagicNumber)
 		return
 	}
+	messagesReceived.Inc(messageTypeName(header.MessageType))
+
+	if Peers.Contacted(source, &header) {
+		err := SendNodeIdQuery(Peer{IP: source.IP, Port: uint16(source.Port)})
+		if err != nil {
+			netLog.Debugf("Failed to send node id query: %s", err)
+		}
+	}
 
 	switch header.MessageType {
 	case Message_keepalive:
 		var m MessageKeepAlive
 		err := m.Read(buf)
 		if err != nil {
-			log.Printf("Failed to read keepalive: %s", err)
+			packetLog.Warnf("Failed to read keepalive: %s", err)
+			parseFailures.Inc(messageTypeName(header.MessageType))
 		}
-		log.Println("Read keepalive")
 		err = m.Handle()
 		if err != nil {
-			log.Printf("Failed to handle keepalive")
+			netLog.Warnf("Failed to handle keepalive: %s", err)
 		}
 	case Message_publish:
 		var m MessagePublish
 		err := m.Read(buf)
 		if err != nil {
-			log.Printf("Failed to read publish: %s", err)
+			packetLog.Warnf("Failed to read publish: %s", err)
+			parseFailures.Inc(messageTypeName(header.MessageType))
 		} else {
-			store.StoreBlock(m.ToBlock())
+			block := m.ToBlock()
+			if block != nil && recentlySeen.Add(block.Hash()) {
+				queued := Processor.Add(block, func(err error) {
+					if err == nil {
+						FloodBlock(block, source)
+					}
+				})
+				// A dropped block should be picked up when it's next
+				// republished
+				if !queued {
+					recentlySeen.Remove(block.Hash())
+				}
+			}
+		}
+	case Message_confirm_req:
+		var m MessageConfirmReq
+		err := m.Read(buf)
+		if err != nil {
+			packetLog.Warnf("Failed to read confirm req: %s", err)
+			parseFailures.Inc(messageTypeName(header.MessageType))
+		} else {
+			block := m.ToBlock()
+			if block != nil {
+				Processor.Add(block, func(error) {
+					if LocalRepresentative != nil && source != nil {
+						peer := Peer{IP: source.IP, Port: uint16(source.Port)}
+						err := LocalRepresentative.Reply(block, peer)
+						if err != nil {
+							netLog.Warnf("Failed to reply to confirm req: %s", err)
+						}
+					}
+				})
+			}
 		}
 	case Message_confirm_ack:
 		var m MessageConfirmAck
 		err := m.Read(buf)
 		if err != nil {
-			log.Printf("Failed to read confirm: %s", err)
+			packetLog.Warnf("Failed to read confirm: %s", err)
+			parseFailures.Inc(messageTypeName(header.MessageType))
 		} else {
-			store.StoreBlock(m.ToBlock())
+			vote := m.MessageVote
+			countVote := func(error) {
+				err := ActiveElections.Vote(&vote)
+				if err != nil {
+					packetLog.Debugf("Failed to handle vote: %s", err)
+				}
+			}
+			if block := m.ToBlock(); block != nil {
+				Processor.Add(block, countVote)
+			} else {
+				countVote(nil)
+			}
 		}
 	case Message_node_id_handshake:
 		var m MessageNodeIdHandshake
 		err := m.Read(buf)
 		if err != nil {
-			log.Printf("Failed to read node id handshake: %s", err)
+			packetLog.Warnf("Failed to read node id handshake: %s", err)
+			parseFailures.Inc(messageTypeName(header.MessageType))
+		} else if source != nil {
+			if m.HasQuery() {
+				err := answerNodeIdQuery(Peer{IP: source.IP, Port: uint16(source.Port)}, m.NodeIdQuery)
+				if err != nil {
+					netLog.Debugf("Failed to answer node id query: %s", err)
+				}
+			}
+			if m.HasResponse() && !Peers.SetNodeId(source, &m.NodeIdResponse) {
+				packetLog.Debugf("Ignored node id response with an invalid signature")
+			}
 		}
 	default:
-		log.Printf("Ignored message. Cannot handle message type %d\n", header.MessageType)
+		packetLog.Debugf("Ignored message. Cannot handle message type %d", header.MessageType)
+	}
+}
+
+// Reads the block carried by a publish, confirm_req or confirm_ack message,
+// e.g. one captured off the wire. The magic number isn't checked, so
+// messages from any network can be decoded.
+func ReadBlockMessage(buf *bytes.Buffer) (blocks.Block, error) {
+	var header MessageHeader
+	err := header.ReadHeader(bytes.NewBuffer(buf.Bytes()))
+	if err != nil {
+		return nil, err
+	}
+
+	var block blocks.Block
+	switch header.MessageType {
+	case Message_publish:
+		var m MessagePublish
+		err = m.Read(buf)
+		block = m.ToBlock()
+	case Message_confirm_req:
+		var m MessageConfirmReq
+		err = m.Read(buf)
+		block = m.ToBlock()
+	case Message_confirm_ack:
+		var m MessageConfirmAck
+		err = m.Read(buf)
+		block = m.ToBlock()
+	default:
+		return nil, errors.New("Message doesn't contain a block")
+	}
+	if err != nil {
+		return nil, err
+	}
+	if block == nil {
+		return nil, errors.New("Invalid block")
+	}
+	return block, nil
+}
+
+// Called with each new block stored in the ledger, before it's confirmed
+type BlockObserver func(block blocks.Block)
+
+var blockObservers []*BlockObserver
+var blockObserversMutex sync.Mutex
+
+// Registers a function to be called with each new block, returning a
+// function which removes it again
+func ObserveNewBlocks(fn BlockObserver) func() {
+	blockObserversMutex.Lock()
+	defer blockObserversMutex.Unlock()
+	observer := &fn
+	blockObservers = append(blockObservers, observer)
+
+	return func() {
+		blockObserversMutex.Lock()
+		defer blockObserversMutex.Unlock()
+		var observers []*BlockObserver
+		for _, o := range blockObservers {
+			if o != observer {
+				observers = append(observers, o)
+			}
+		}
+		blockObservers = observers
+	}
+}
+
+func notifyNewBlock(block blocks.Block) {
+	blockObserversMutex.Lock()
+	observers := blockObservers
+	blockObserversMutex.Unlock()
+
+	for _, fn := range observers {
+		(*fn)(block)
+	}
+}
+
+// Stores a block and starts an election for its root. Forks are not
+// stored, but join the election against the block already in the ledger.
+func processBlock(block blocks.Block) error {
+	if block == nil {
+		return errors.New("Invalid block")
+	}
+
+	errs, released := store.StoreBlocks([]blocks.Block{block})
+	blockProcessed(block, errs[0])
+	for _, r := range released {
+		blockProcessed(r.Block, r.Err)
+	}
+	return errs[0]
+}
+
+// Stores a block created locally, e.g. by a wallet, updating the elections
+// and observers as if it had arrived from the network
+func StoreBlock(block blocks.Block) error {
+	return processBlock(block)
+}
+
+// Updates the elections and observers once a block has been stored, or
+// found to fork with a stored block
+func blockProcessed(block blocks.Block, err error) {
+	blocksProcessed.Inc(processResult(err))
+	switch err {
+	case nil:
+		notifyNewBlock(block)
+		ActiveElections.Start(block)
+	case store.ErrFork:
+		existing := store.FetchByRoot(block)
+		if existing != nil && !existing.IsConfirmed() {
+			ActiveElections.Start(existing)
+			ActiveElections.Start(block)
+		}
 	}
 }
 
 func (m *MessageKeepAlive) Handle() error {
 	for _, peer := range m.Peers {
-		if !PeerSet[peer.String()] {
-			PeerSet[peer.String()] = true
-			PeerList = append(PeerList, peer)
-			log.Printf("Added new peer to list: %s, now %d peers", peer.String(), len(PeerList))
+		if Peers.Add(peer) {
+			netLog.Infof("Added new peer to list: %s, now %d peers", peer.String(), Peers.Len())
 		}
 	}
 	return nil
@@ -206,7 +404,7 @@ func (m *MessageKeepAlive) Read(buf *bytes.Buffer) error {
 			return errors.New("Not enough ip bytes")
 		}
 
-		m.Peers = append(m.Peers, Peer{peerIp, binary.LittleEndian.Uint16(peerPort), nil})
+		m.Peers = append(m.Peers, Peer{IP: peerIp, Port: binary.LittleEndian.Uint16(peerPort)})
 	}
 
 	return nil
@@ -219,7 +417,7 @@ func (m *MessageKeepAlive) Write(buf *bytes.Buffer) error {
 	}
 
 	for _, peer := range m.Peers {
-		_, err = buf.Write(peer.IP)
+		_, err = buf.Write(peer.IP.To16())
 		if err != nil {
 			return err
 		}
@@ -332,18 +530,100 @@ func (m *MessagePublish) Write(buf *bytes.Buffer) error {
 	return nil
 }
 
+const (
+	nodeIdQueryFlag    = 0x01
+	nodeIdResponseFlag = 0x02
+)
+
+func CreateNodeIdQuery(cookie [32]byte) *MessageNodeIdHandshake {
+	var m MessageNodeIdHandshake
+	m.MessageHeader = newHeader(Message_node_id_handshake, 0x0)
+	m.MessageHeader.Extensions = nodeIdQueryFlag
+	m.NodeIdQuery = cookie
+	return &m
+}
+
+// Asks a peer to prove its node id by signing a random cookie
+func SendNodeIdQuery(peer Peer) error {
+	cookie := Peers.Cookie(&peer)
+	if cookie == nil {
+		return errors.New("Unknown peer")
+	}
+	return peer.SendMessage(CreateNodeIdQuery(*cookie))
+}
+
+// Proves our node id to a peer by signing the cookie it sent
+func CreateNodeIdResponse(cookie [32]byte, key ed25519.PrivateKey) *MessageNodeIdHandshake {
+	var m MessageNodeIdHandshake
+	m.MessageHeader = newHeader(Message_node_id_handshake, 0x0)
+	m.MessageHeader.Extensions = nodeIdResponseFlag
+	copy(m.Account[:], key[32:])
+	copy(m.Signature[:], ed25519.Sign(key, cookie[:]))
+	return &m
+}
+
+func answerNodeIdQuery(peer Peer, cookie [32]byte) error {
+	connMutex.RLock()
+	key := nodeIdKey
+	connMutex.RUnlock()
+	if key == nil {
+		return errors.New("Not listening for udp")
+	}
+	return peer.SendMessage(CreateNodeIdResponse(cookie, key))
+}
+
+func (m *MessageNodeIdHandshake) HasQuery() bool {
+	return m.MessageHeader.Extensions&nodeIdQueryFlag != 0
+}
+
+func (m *MessageNodeIdHandshake) HasResponse() bool {
+	return m.MessageHeader.Extensions&nodeIdResponseFlag != 0
+}
+
+func (r *NodeIdResponse) Verify(cookie []byte) bool {
+	return ed25519.Verify(ed25519.PublicKey(r.Account[:]), cookie, r.Signature[:])
+}
+
 func (m *MessageNodeIdHandshake) Read(buf *bytes.Buffer) error {
 	err := m.MessageHeader.ReadHeader(buf)
 	if err != nil {
 		return err
 	}
-	log.Println("Node Id Handshake flags:  ", m.MessageHeader.Extensions, m.MessageHeader.BlockType)
-	if m.MessageHeader.Extensions&0x01 != 0 {
-		// NodeIdQuery
 
+	if m.MessageHeader.MessageType != Message_node_id_handshake {
+		return errors.New("Tried to read wrong message type")
+	}
+
+	if m.HasQuery() {
+		n, err := buf.Read(m.NodeIdQuery[:])
+		if err != nil || n != len(m.NodeIdQuery) {
+			return errors.New("Failed to read node id query")
+		}
+	}
+
+	if m.HasResponse() {
+		n1, err1 := buf.Read(m.Account[:])
+		n2, err2 := buf.Read(m.Signature[:])
+		if err1 != nil || err2 != nil || n1 != len(m.Account) || n2 != len(m.Signature) {
+			return errors.New("Failed to read node id response")
+		}
+	}
+	return nil
+}
+
+func (m *MessageNodeIdHandshake) Write(buf *bytes.Buffer) error {
+	err := m.MessageHeader.WriteHeader(buf)
+	if err != nil {
+		return err
+	}
+
+	if m.HasQuery() {
+		buf.Write(m.NodeIdQuery[:])
 	}
-	if m.MessageHeader.Extensions&0x02 != 0 {
-		// NodeIdResponse
+
+	if m.HasResponse() {
+		buf.Write(m.Account[:])
+		buf.Write(m.Signature[:])
 	}
 	return nil
 }
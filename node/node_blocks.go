@@ -83,7 +83,7 @@ func (m *MessageBlock) ToBlock() blocks.Block {
 	switch m.Type {
 	case BlockType_open:
 		block := blocks.OpenBlock{
-			types.BlockHash(hex.EncodeToString(m.SourceOrPrevious[:])),
+			types.BlockHashFromBytes(m.SourceOrPrevious[:]),
 			address.PubKeyToAddress(m.RepDestOrSource[:]),
 			address.PubKeyToAddress(m.Account[:]),
 			common,
@@ -91,7 +91,7 @@ func (m *MessageBlock) ToBlock() blocks.Block {
 		return &block
 	case BlockType_send:
 		block := blocks.SendBlock{
-			types.BlockHash(hex.EncodeToString(m.SourceOrPrevious[:])),
+			types.BlockHashFromBytes(m.SourceOrPrevious[:]),
 			address.PubKeyToAddress(m.RepDestOrSource[:]),
 			uint128.FromBytes(m.Balance[:]),
 			common,
@@ -99,14 +99,14 @@ func (m *MessageBlock) ToBlock() blocks.Block {
 		return &block
 	case BlockType_receive:
 		block := blocks.ReceiveBlock{
-			types.BlockHash(hex.EncodeToString(m.SourceOrPrevious[:])),
-			types.BlockHash(hex.EncodeToString(m.RepDestOrSource[:])),
+			types.BlockHashFromBytes(m.SourceOrPrevious[:]),
+			types.BlockHashFromBytes(m.RepDestOrSource[:]),
 			common,
 		}
 		return &block
 	case BlockType_change:
 		block := blocks.ChangeBlock{
-			types.BlockHash(hex.EncodeToString(m.SourceOrPrevious[:])),
+			types.BlockHashFromBytes(m.SourceOrPrevious[:]),
 			address.PubKeyToAddress(m.RepDestOrSource[:]),
 			common,
 		}
@@ -116,6 +116,44 @@ func (m *MessageBlock) ToBlock() blocks.Block {
 	}
 }
 
+// Builds the wire representation of a block, the inverse of ToBlock
+func NewMessageBlock(b blocks.Block) (m MessageBlock) {
+	copy(m.Signature[:], b.GetSignature().ToBytes())
+	work, _ := hex.DecodeString(string(b.GetWork()))
+	copy(m.Work[:], work)
+
+	switch b.Type() {
+	case blocks.Open:
+		block := b.(*blocks.OpenBlock)
+		m.Type = BlockType_open
+		rep, _ := address.AddressToPub(block.Representative)
+		account, _ := address.AddressToPub(block.Account)
+		copy(m.SourceOrPrevious[:], block.SourceHash.ToBytes())
+		copy(m.RepDestOrSource[:], rep)
+		copy(m.Account[:], account)
+	case blocks.Send:
+		block := b.(*blocks.SendBlock)
+		m.Type = BlockType_send
+		dest, _ := address.AddressToPub(block.Destination)
+		copy(m.SourceOrPrevious[:], block.PreviousHash.ToBytes())
+		copy(m.RepDestOrSource[:], dest)
+		copy(m.Balance[:], block.Balance.GetBytes())
+	case blocks.Receive:
+		block := b.(*blocks.ReceiveBlock)
+		m.Type = BlockType_receive
+		copy(m.SourceOrPrevious[:], block.PreviousHash.ToBytes())
+		copy(m.RepDestOrSource[:], block.SourceHash.ToBytes())
+	case blocks.Change:
+		block := b.(*blocks.ChangeBlock)
+		m.Type = BlockType_change
+		rep, _ := address.AddressToPub(block.Representative)
+		copy(m.SourceOrPrevious[:], block.PreviousHash.ToBytes())
+		copy(m.RepDestOrSource[:], rep)
+	}
+
+	return m
+}
+
 func (m *MessageBlock) Read(messageBlockType byte, buf *bytes.Buffer) error {
 	m.Type = messageBlockType
 
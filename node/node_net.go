@@ -2,14 +2,15 @@ package node
 
 import (
 	"bytes"
-	"crypto"
-	"fmt"
-	"log"
-	"math/rand"
+	"context"
+	"errors"
 	"net"
+	"sync"
 	"time"
 
+	"github.com/svaishnavy/crypto/ed25519"
 	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/network"
 	"github.com/svaishnavy/nano/types"
 )
 
@@ -17,9 +18,23 @@ const packetSize = 512
 const numberOfPeersToShare = 8
 
 type Node struct {
-	privK   crypto.PrivateKey
-	pubK    crypto.PublicKey
+	privK   ed25519.PrivateKey
+	pubK    ed25519.PublicKey
 	account types.Account
+
+	// Where to listen for UDP messages, all interfaces on the network's
+	// default port if nil
+	ListenAddr *net.UDPAddr
+	// Known peers are loaded from and periodically saved to this file
+	PeersFile string
+
+	mutex   sync.Mutex
+	running bool
+	ctx     context.Context
+	cancel  context.CancelFunc
+	alarms  []*Alarm
+	workers sync.WaitGroup
+	done    chan struct{}
 }
 
 func NewNode() *Node {
@@ -29,92 +44,88 @@ func NewNode() *Node {
 		privK:   privK,
 		pubK:    pubK,
 		account: account,
+		done:    make(chan struct{}),
 	}
+	node.ctx, node.cancel = context.WithCancel(context.Background())
 	return node
 }
 
-var conn *net.UDPConn
+// The socket of the listening node, and the key it signs node id queries
+// with. Both are nil when no node is listening.
+var (
+	connMutex sync.RWMutex
+	conn      *net.UDPConn
+	nodeIdKey ed25519.PrivateKey
+)
 
-var DefaultPeer = Peer{
-	//net.ParseIP("::ffff:192.168.0.70"),
-	net.ParseIP("::ffff:94.130.105.241"),
-	7075,
-	nil,
+// Configures the node to run on a network. This must be called before the
+// store is opened or the node starts listening.
+func UseNetwork(n *network.Network) {
+	network.Select(n)
+	MagicNumber = n.MagicNumber
+	AddBootstrapPeers()
 }
 
-var LocalPeer = Peer{
-	net.ParseIP("::ffff:77.171.82.118"),
-	7075,
-	nil,
-}
+// host:port addresses configured by the user, which are contacted along
+// with the network's bootstrap peers
+var PreconfiguredPeers []string
 
-var PeerList = []Peer{DefaultPeer, LocalPeer}
-var PeerSet = map[string]bool{DefaultPeer.String(): true}
+// Adds the network's bootstrap peers and any preconfigured peers, used at
+// startup and if every peer has expired
+func AddBootstrapPeers() {
+	addresses := append([]string{}, network.Active.BootstrapPeers...)
+	for _, hostport := range append(addresses, PreconfiguredPeers...) {
+		addr, err := net.ResolveUDPAddr("udp", hostport)
+		if err != nil {
+			bootstrapLog.Warnf("Failed to resolve bootstrap peer %s: %s", hostport, err)
+			continue
+		}
+		Peers.Add(Peer{IP: addr.IP, Port: uint16(addr.Port)})
+	}
+}
 
 func (p *Peer) SendMessage(m Message) error {
-	now := time.Now()
-	p.LastReachout = &now
+	connMutex.RLock()
+	udp := conn
+	connMutex.RUnlock()
+	if udp == nil {
+		return errors.New("Not listening for udp")
+	}
+
+	Peers.Attempted(p)
 
 	buf := bytes.NewBuffer(nil)
 	err := m.Write(buf)
 	if err != nil {
 		return err
 	}
-	_, err = conn.WriteToUDP(buf.Bytes(), &net.UDPAddr{Port: int(p.Port), IP: p.IP})
+	_, err = udp.WriteToUDP(buf.Bytes(), &net.UDPAddr{Port: int(p.Port), IP: p.IP})
 	if err != nil {
 		return err
 	}
+	messagesSent.Inc(messageTypeName(buf.Bytes()[5]))
 
 	return nil
 }
 
-func (node *Node) ListenForUdp() error {
-	log.Printf("Listening for udp packets on 7075")
-	var err error
-	conn, err = net.ListenUDP("udp", &net.UDPAddr{Port: 7075})
-	if err != nil {
-		return err
-	}
-
-	buf := make([]byte, packetSize)
-
-	for {
-		n, _, err := conn.ReadFromUDP(buf)
-		if err != nil {
-			log.Printf("Error: UDP read error: %v", err)
-			continue
-		}
-		if n > 0 {
-			log.Println("Received message")
-			handleMessage(bytes.NewBuffer(buf[:n]))
-		}
-	}
-}
-
 func SendKeepAlive(peer Peer) error {
-	randomPeers := make([]Peer, 0)
-	randIndices := rand.Perm(len(PeerList))
-	for n, i := range randIndices {
-		if n == numberOfPeersToShare {
-			break
-		}
-		randomPeers = append(randomPeers, PeerList[i])
-		fmt.Println(PeerList[i].IP, []byte(PeerList[i].IP))
-	}
-
-	m := CreateKeepAlive(randomPeers)
-	log.Println("Sending keepalive")
+	m := CreateKeepAlive(Peers.Random(numberOfPeersToShare, nil))
+	packetLog.Debugf("Sending keepalive to %s", peer.String())
 	return peer.SendMessage(m)
 }
 
 func SendKeepAlives(params []interface{}) {
 	timeCutoff := time.Now().Add(-5 * time.Minute)
 
-	for _, peer := range PeerList {
-		if peer.LastReachout == nil || peer.LastReachout.Before(timeCutoff) {
+	if Peers.Len() == 0 {
+		AddBootstrapPeers()
+	}
+
+	for _, peer := range Peers.List() {
+		if peer.LastAttempt.Before(timeCutoff) {
 			err := SendKeepAlive(peer)
 			if err != nil {
-				fmt.Println(err)
+				netLog.Debugf("Failed to send keepalive to %s: %s", peer.String(), err)
 			}
 		}
 	}
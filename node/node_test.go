@@ -196,7 +196,7 @@ func TestReadPublish(t *testing.T) {
 
 func TestHandleMessage(t *testing.T) {
 	store.Init(store.TestConfig)
-	handleMessage(bytes.NewBuffer(publishTest))
+	handleMessage(bytes.NewBuffer(publishTest), nil)
 }
 
 func TestReadWriteHeader(t *testing.T) {
@@ -2,9 +2,11 @@ package node
 
 import (
 	"bytes"
+	"encoding/binary"
 	"errors"
 
 	"github.com/golang/crypto/blake2b"
+	"github.com/svaishnavy/crypto/ed25519"
 )
 
 type MessageVote struct {
@@ -58,3 +60,15 @@ func (m *MessageVote) Write(buf *bytes.Buffer) error {
 
 	return nil
 }
+
+// Verifies the vote was signed by the representative it claims to be from
+func (m *MessageVote) Verify() bool {
+	if m.ToBlock() == nil {
+		return false
+	}
+	return ed25519.Verify(ed25519.PublicKey(m.Account[:]), m.Hash(), m.Signature[:])
+}
+
+func (m *MessageVote) SequenceNumber() uint64 {
+	return binary.LittleEndian.Uint64(m.Sequence[:])
+}
@@ -0,0 +1,297 @@
+package node
+
+import (
+	cryptorand "crypto/rand"
+	"encoding/json"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/svaishnavy/nano/address"
+)
+
+// Defaults for new peer tables
+const (
+	DefaultPeerExpiry      = 5 * time.Minute
+	DefaultMaxPeersPerIP   = 4
+	DefaultMaxPeersPerNet  = 16
+	nodeIdCookieExpiry     = time.Minute
+	ipv4SubnetPrefixLength = 24
+	ipv6SubnetPrefixLength = 64
+)
+
+type peerEntry struct {
+	Peer
+	added  time.Time
+	cookie *[32]byte
+	sentAt time.Time
+}
+
+// A concurrency safe set of the peers we know about
+type PeerTable struct {
+	mutex sync.RWMutex
+	peers map[string]*peerEntry
+
+	// Peers we haven't heard from for this long are evicted
+	Expiry time.Duration
+	// Limits on how many peers may share an IP address or subnet
+	MaxPerIP     int
+	MaxPerSubnet int
+}
+
+var Peers = NewPeerTable()
+
+func NewPeerTable() *PeerTable {
+	return &PeerTable{
+		peers:        make(map[string]*peerEntry),
+		Expiry:       DefaultPeerExpiry,
+		MaxPerIP:     DefaultMaxPeersPerIP,
+		MaxPerSubnet: DefaultMaxPeersPerNet,
+	}
+}
+
+func subnet(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4.Mask(net.CIDRMask(ipv4SubnetPrefixLength, 32)).String()
+	}
+	return ip.Mask(net.CIDRMask(ipv6SubnetPrefixLength, 128)).String()
+}
+
+// Checks the per IP and per subnet limits, the caller must hold the lock
+func (t *PeerTable) hasRoom(ip net.IP) bool {
+	sameIP, sameNet := 0, 0
+	ipSubnet := subnet(ip)
+	for _, entry := range t.peers {
+		if entry.IP.Equal(ip) {
+			sameIP++
+		}
+		if subnet(entry.IP) == ipSubnet {
+			sameNet++
+		}
+	}
+	return sameIP < t.MaxPerIP && sameNet < t.MaxPerSubnet
+}
+
+func (t *PeerTable) add(peer Peer) *peerEntry {
+	if peer.IP == nil || peer.IP.IsUnspecified() || peer.Port == 0 {
+		return nil
+	}
+	if !t.hasRoom(peer.IP) {
+		return nil
+	}
+
+	entry := &peerEntry{Peer: peer, added: time.Now()}
+	t.peers[peer.String()] = entry
+	return entry
+}
+
+// Adds a peer to the table, returning false if it was already known or
+// would exceed the per IP or subnet limits.
+func (t *PeerTable) Add(peer Peer) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if t.peers[peer.String()] != nil {
+		return false
+	}
+	return t.add(peer) != nil
+}
+
+// Records that we've received a message from a peer, adding it if it's new.
+// Returns true if the peer wasn't in the table before.
+func (t *PeerTable) Contacted(addr *net.UDPAddr, header *MessageHeader) bool {
+	if addr == nil {
+		return false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	peer := Peer{IP: addr.IP, Port: uint16(addr.Port)}
+	entry := t.peers[peer.String()]
+	isNew := entry == nil
+	if isNew {
+		entry = t.add(peer)
+		if entry == nil {
+			return false
+		}
+	}
+
+	entry.LastContact = time.Now()
+	entry.VersionMax = header.VersionMax
+	entry.VersionUsing = header.VersionUsing
+	entry.VersionMin = header.VersionMin
+	return isNew
+}
+
+// Records that we've sent a message to a peer
+func (t *PeerTable) Attempted(peer *Peer) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	if entry := t.peers[peer.String()]; entry != nil {
+		entry.LastAttempt = time.Now()
+	}
+}
+
+// Generates a node id cookie to send to a peer, which it signs with its node
+// id key to prove its identity.
+func (t *PeerTable) Cookie(peer *Peer) *[32]byte {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	entry := t.peers[peer.String()]
+	if entry == nil {
+		return nil
+	}
+
+	var cookie [32]byte
+	_, err := cryptorand.Read(cookie[:])
+	if err != nil {
+		return nil
+	}
+	entry.cookie = &cookie
+	entry.sentAt = time.Now()
+	return &cookie
+}
+
+// Sets a peer's node id if the handshake response signed the cookie we sent
+func (t *PeerTable) SetNodeId(addr *net.UDPAddr, response *NodeIdResponse) bool {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	peer := Peer{IP: addr.IP, Port: uint16(addr.Port)}
+	entry := t.peers[peer.String()]
+	if entry == nil || entry.cookie == nil || time.Since(entry.sentAt) > nodeIdCookieExpiry {
+		return false
+	}
+
+	if !response.Verify(entry.cookie[:]) {
+		return false
+	}
+
+	entry.NodeId = address.PubKeyToAddress(response.Account[:])
+	entry.cookie = nil
+	return true
+}
+
+func (t *PeerTable) Get(key string) (Peer, bool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	entry := t.peers[key]
+	if entry == nil {
+		return Peer{}, false
+	}
+	return entry.Peer, true
+}
+
+func (t *PeerTable) Len() int {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return len(t.peers)
+}
+
+// Returns a copy of every peer in the table
+func (t *PeerTable) List() []Peer {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+
+	peers := make([]Peer, 0, len(t.peers))
+	for _, entry := range t.peers {
+		peers = append(peers, entry.Peer)
+	}
+	return peers
+}
+
+// Returns up to count peers picked at random, leaving out exclude
+func (t *PeerTable) Random(count int, exclude *net.UDPAddr) []Peer {
+	all := t.List()
+	peers := make([]Peer, 0, count)
+
+	for _, i := range rand.Perm(len(all)) {
+		if len(peers) == count {
+			break
+		}
+		peer := all[i]
+		if exclude != nil && peer.IP.Equal(exclude.IP) && int(peer.Port) == exclude.Port {
+			continue
+		}
+		peers = append(peers, peer)
+	}
+	return peers
+}
+
+// Evicts peers we haven't heard from within the expiry period
+func (t *PeerTable) Purge() int {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	cutoff := time.Now().Add(-t.Expiry)
+	evicted := 0
+	for key, entry := range t.peers {
+		lastSeen := entry.LastContact
+		if entry.added.After(lastSeen) {
+			lastSeen = entry.added
+		}
+		if lastSeen.Before(cutoff) {
+			delete(t.peers, key)
+			evicted++
+		}
+	}
+	return evicted
+}
+
+func PurgePeers(params []interface{}) {
+	Peers.Purge()
+}
+
+// Saves the peer table to the path given as the first parameter
+func SavePeers(params []interface{}) {
+	err := Peers.Save(params[0].(string))
+	if err != nil {
+		netLog.Warnf("Failed to save peers: %s", err)
+	}
+}
+
+// Writes the table to disk so we can reconnect quickly after a restart
+func (t *PeerTable) Save(path string) error {
+	data, err := json.Marshal(t.List())
+	if err != nil {
+		return err
+	}
+
+	tmp := path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Adds the peers saved by Save. Loaded peers are treated as newly added,
+// so they get a full expiry period to respond before being evicted.
+func (t *PeerTable) Load(path string) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var peers []Peer
+	err = json.Unmarshal(data, &peers)
+	if err != nil {
+		return err
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	for _, peer := range peers {
+		if t.peers[peer.String()] == nil {
+			t.add(peer)
+		}
+	}
+	return nil
+}
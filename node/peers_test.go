@@ -0,0 +1,168 @@
+package node
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/svaishnavy/crypto/ed25519"
+	"github.com/svaishnavy/nano/address"
+)
+
+func TestPeerLimits(t *testing.T) {
+	table := NewPeerTable()
+	table.MaxPerIP = 2
+	table.MaxPerSubnet = 3
+
+	ip := net.ParseIP("10.0.0.1")
+	if !table.Add(Peer{IP: ip, Port: 1}) || !table.Add(Peer{IP: ip, Port: 2}) {
+		t.Errorf("Failed to add peers")
+	}
+	if table.Add(Peer{IP: ip, Port: 1}) {
+		t.Errorf("Added the same peer twice")
+	}
+	if table.Add(Peer{IP: ip, Port: 3}) {
+		t.Errorf("Exceeded the per IP limit")
+	}
+	if !table.Add(Peer{IP: net.ParseIP("10.0.0.2"), Port: 1}) {
+		t.Errorf("Failed to add peer on the same subnet")
+	}
+	if table.Add(Peer{IP: net.ParseIP("::ffff:10.0.0.3"), Port: 1}) {
+		t.Errorf("Exceeded the per subnet limit")
+	}
+	if !table.Add(Peer{IP: net.ParseIP("10.0.1.1"), Port: 1}) {
+		t.Errorf("Failed to add peer on a different subnet")
+	}
+	if table.Len() != 4 {
+		t.Errorf("Wrong number of peers %d", table.Len())
+	}
+}
+
+func TestPeerExpiry(t *testing.T) {
+	table := NewPeerTable()
+	table.Expiry = 50 * time.Millisecond
+
+	silent := Peer{IP: net.ParseIP("10.0.0.1"), Port: 7075}
+	table.Add(silent)
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.1.1"), Port: 7075}
+	header := newHeader(Message_keepalive, 0)
+	if !table.Contacted(addr, &header) {
+		t.Errorf("Contact from a new peer should add it")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	table.Contacted(addr, &header)
+	if table.Purge() != 1 {
+		t.Errorf("Should have evicted the silent peer")
+	}
+
+	peer, ok := table.Get("10.0.1.1:7075")
+	if !ok || peer.VersionUsing != VersionUsing || peer.LastContact.IsZero() {
+		t.Errorf("Contacted peer wasn't tracked")
+	}
+}
+
+func TestPeerPersistence(t *testing.T) {
+	dir, _ := ioutil.TempDir("", "peers")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers.json")
+
+	table := NewPeerTable()
+	table.Add(Peer{IP: net.ParseIP("::ffff:10.0.0.1"), Port: 7075})
+	table.Add(Peer{IP: net.ParseIP("2001:db8::1"), Port: 7076})
+	if err := table.Save(path); err != nil {
+		t.Errorf("Failed to save peers: %s", err)
+	}
+
+	loaded := NewPeerTable()
+	if err := loaded.Load(path); err != nil {
+		t.Errorf("Failed to load peers: %s", err)
+	}
+	if _, ok := loaded.Get("[2001:db8::1]:7076"); !ok || loaded.Len() != 2 {
+		t.Errorf("Loaded peers don't match saved peers")
+	}
+}
+
+func TestNodeIdHandshake(t *testing.T) {
+	saved := Peers
+	defer func() { Peers = saved }()
+	Peers = NewPeerTable()
+
+	addr := &net.UDPAddr{IP: net.ParseIP("10.0.0.1"), Port: 7075}
+	header := newHeader(Message_keepalive, 0)
+	Peers.Contacted(addr, &header)
+	peer := Peer{IP: addr.IP, Port: uint16(addr.Port)}
+	cookie := Peers.Cookie(&peer)
+
+	pub, priv := address.GenerateKey()
+	m := CreateNodeIdQuery(*cookie)
+	m.MessageHeader.Extensions |= nodeIdResponseFlag
+	copy(m.Account[:], pub)
+	copy(m.Signature[:], ed25519.Sign(priv, cookie[:]))
+
+	var buf bytes.Buffer
+	m.Write(&buf)
+	var read MessageNodeIdHandshake
+	if err := read.Read(&buf); err != nil || !read.HasQuery() || !read.HasResponse() {
+		t.Errorf("Failed to read node id handshake: %s", err)
+	}
+
+	if !Peers.SetNodeId(addr, &read.NodeIdResponse) {
+		t.Errorf("Valid node id response was rejected")
+	}
+	peer, _ = Peers.Get(peer.String())
+	if peer.NodeId != address.PubKeyToAddress(pub) {
+		t.Errorf("Node id wasn't recorded")
+	}
+	if Peers.SetNodeId(addr, &read.NodeIdResponse) {
+		t.Errorf("Node id response was accepted twice for one cookie")
+	}
+}
+
+func TestNodeIdQuery(t *testing.T) {
+	saved := Peers
+	defer func() { Peers = saved }()
+	Peers = NewPeerTable()
+
+	node := NewNode()
+	node.ListenAddr = &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	if err := node.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	defer node.Stop()
+
+	client, err := net.DialUDP("udp", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	var cookie [32]byte
+	copy(cookie[:], "cookie")
+	var buf bytes.Buffer
+	CreateNodeIdQuery(cookie).Write(&buf)
+	client.Write(buf.Bytes())
+
+	// The node also queries us as a new peer, so look for its answer
+	client.SetReadDeadline(time.Now().Add(5 * time.Second))
+	packet := make([]byte, packetSize)
+	for {
+		n, err := client.Read(packet)
+		if err != nil {
+			t.Fatalf("Node id query wasn't answered: %s", err)
+		}
+		var m MessageNodeIdHandshake
+		if m.Read(bytes.NewBuffer(packet[:n])) != nil || !m.HasResponse() {
+			continue
+		}
+		if !m.Verify(cookie[:]) || address.PubKeyToAddress(m.Account[:]) != node.account {
+			t.Errorf("Node id response didn't sign the cookie with the node's key")
+		}
+		return
+	}
+}
@@ -0,0 +1,134 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+)
+
+// How often a locally created block is republished while it's unconfirmed,
+// and how many times before we give up on it.
+var ProcessRetryInterval = 10 * time.Second
+var ProcessRetries = 30
+
+var errNodeStopped = errors.New("Node stopped")
+
+type ProcessResult struct {
+	Hash      types.BlockHash
+	Confirmed bool
+	Err       error
+}
+
+// Validates, stores and publishes a block created locally, e.g. by a
+// wallet. The block is republished until it's confirmed, and the outcome is
+// sent on the returned channel.
+func (node *Node) Process(block blocks.Block) <-chan ProcessResult {
+	result := make(chan ProcessResult, 1)
+
+	err := validateLocalBlock(block)
+	if err == nil {
+		err = processBlock(block)
+		if err == store.ErrBlockExists {
+			err = nil
+		}
+	}
+	if err != nil {
+		result <- ProcessResult{Hash: block.Hash(), Err: err}
+		return result
+	}
+
+	node.mutex.Lock()
+	ctx := node.ctx
+	if ctx.Err() != nil {
+		node.mutex.Unlock()
+		result <- ProcessResult{Hash: block.Hash(), Err: errNodeStopped}
+		return result
+	}
+	node.workers.Add(1)
+	node.mutex.Unlock()
+
+	go func() {
+		defer node.workers.Done()
+		publishUntilConfirmed(ctx, block, result)
+	}()
+	return result
+}
+
+func validateLocalBlock(block blocks.Block) error {
+	if block == nil {
+		return errors.New("Invalid block")
+	}
+
+	account := store.FetchBlockAccount(block)
+	if account == "" {
+		return errors.New("Cannot find account for block")
+	}
+
+	if !blocks.VerifyBlockSignature(block, account) {
+		return errors.New("Invalid signature for block")
+	}
+	return nil
+}
+
+func publishUntilConfirmed(ctx context.Context, block blocks.Block, result chan ProcessResult) {
+	hash := block.Hash()
+	confirmed := ActiveElections.WaitFor(hash)
+	defer ActiveElections.StopWaiting(hash, confirmed)
+
+	ticker := time.NewTicker(ProcessRetryInterval)
+	defer ticker.Stop()
+
+	for attempt := 0; attempt < ProcessRetries; attempt++ {
+		if stored := store.FetchBlock(hash); stored != nil && stored.IsConfirmed() {
+			result <- ProcessResult{Hash: hash, Confirmed: true}
+			return
+		}
+
+		// The election may have timed out since the last attempt
+		ActiveElections.Start(block)
+		FloodBlock(block, nil)
+		voteLocally(block)
+
+		select {
+		case won := <-confirmed:
+			if !won {
+				result <- ProcessResult{Hash: hash, Err: errors.New("A competing block was confirmed, or the block couldn't be stored")}
+				return
+			}
+			result <- ProcessResult{Hash: hash, Confirmed: true}
+			return
+		case <-ctx.Done():
+			result <- ProcessResult{Hash: hash, Err: errNodeStopped}
+			return
+		case <-ticker.C:
+		}
+	}
+
+	result <- ProcessResult{Hash: hash, Err: errors.New("Block was not confirmed")}
+}
+
+// Votes for a block with our own representative, if we have one, and
+// publishes the vote so the rest of the network counts it too.
+func voteLocally(block blocks.Block) {
+	if LocalRepresentative == nil || !LocalRepresentative.HasWeight() {
+		return
+	}
+
+	vote, err := LocalRepresentative.Vote(block)
+	if err != nil {
+		return
+	}
+
+	err = ActiveElections.Vote(&vote.MessageVote)
+	if err != nil {
+		electionLog.Warnf("Failed to count local vote: %s", err)
+	}
+
+	for _, peer := range floodPeers(nil) {
+		peer.SendMessage(vote)
+	}
+}
@@ -0,0 +1,42 @@
+package node
+
+import (
+	"os"
+	"testing"
+	"time"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+func TestProcess(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	ActiveElections = NewElections()
+	SetRepresentative(blocks.TestPrivateKey)
+	defer func() { LocalRepresentative = nil }()
+	node := NewNode()
+
+	unsigned := testSend(blocks.TestGenesisBlock, uint128.FromInts(0, 1))
+	unsigned.Signature = ""
+	result := <-node.Process(unsigned)
+	if result.Err == nil {
+		t.Errorf("Processed a block with an invalid signature")
+	}
+
+	send := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	select {
+	case result = <-node.Process(send):
+		if result.Err != nil || !result.Confirmed {
+			t.Errorf("Block wasn't confirmed: %s", result.Err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("Timed out waiting for confirmation")
+	}
+
+	if b := store.FetchBlock(send.Hash()); b == nil || !b.IsConfirmed() {
+		t.Errorf("Processed block wasn't confirmed in the store")
+	}
+	os.RemoveAll(store.TestConfig.Path)
+}
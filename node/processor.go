@@ -0,0 +1,284 @@
+package node
+
+import (
+	"context"
+	"errors"
+	"runtime"
+	"sync"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+)
+
+// Defaults for the node's block processor
+const (
+	DefaultProcessorQueueSize = 4096
+	DefaultProcessorBatchSize = 256
+)
+
+var ErrQueueFull = errors.New("Block processor queue is full")
+var errInvalidSignature = store.ErrInvalidSignature
+
+type processItem struct {
+	block blocks.Block
+	done  func(error)
+
+	// Set once the signature has been checked. Blocks whose account can't
+	// be found yet are checked again when they're written, as their
+	// previous block may be earlier in the same batch. Any still unknown
+	// then are gaps, which the store checks when their previous block
+	// arrives.
+	checked bool
+	valid   bool
+}
+
+// Stores blocks received from the network off the UDP read goroutine.
+// Signatures are checked by a pool of workers, then the blocks are written
+// in batches, each batch in a single transaction.
+type BlockProcessor struct {
+	BatchSize int
+	workers   int
+
+	queue    chan *processItem
+	verified chan *processItem
+
+	mutex       sync.Mutex
+	running     bool
+	stopWorkers chan struct{}
+	stopWriter  chan struct{}
+	workerGroup sync.WaitGroup
+	writerGroup sync.WaitGroup
+	waiters     map[types.BlockHash][]chan error
+}
+
+var Processor = NewBlockProcessor(DefaultProcessorQueueSize, runtime.NumCPU())
+
+func NewBlockProcessor(queueSize int, workers int) *BlockProcessor {
+	if workers < 1 {
+		workers = 1
+	}
+	return &BlockProcessor{
+		BatchSize: DefaultProcessorBatchSize,
+		workers:   workers,
+		queue:     make(chan *processItem, queueSize),
+		verified:  make(chan *processItem, queueSize),
+		waiters:   make(map[types.BlockHash][]chan error),
+	}
+}
+
+// Queues a block without blocking. If the queue is full the block is
+// dropped, false is returned and done is called with ErrQueueFull. done may
+// be nil, otherwise it's called with the block's result once it's stored.
+func (p *BlockProcessor) Add(block blocks.Block, done func(error)) bool {
+	select {
+	case p.queue <- &processItem{block: block, done: done}:
+		return true
+	default:
+		blocksDropped.Inc()
+		if done != nil {
+			done(ErrQueueFull)
+		}
+		return false
+	}
+}
+
+// Queues a block, waiting for room in the queue until ctx is done. Used by
+// producers such as bootstrapping which would rather slow down than drop
+// blocks.
+func (p *BlockProcessor) AddWait(ctx context.Context, block blocks.Block, done func(error)) error {
+	select {
+	case p.queue <- &processItem{block: block, done: done}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// The number of blocks waiting to be stored
+func (p *BlockProcessor) Len() int {
+	return len(p.queue) + len(p.verified)
+}
+
+// Returns true when the queue is three quarters full, as a signal for
+// producers to back off before blocks start being dropped
+func (p *BlockProcessor) Saturated() bool {
+	return len(p.queue) >= cap(p.queue)*3/4
+}
+
+// Returns a channel which receives the result of processing the block with
+// the given hash. Call StopWaiting if the result is no longer needed.
+func (p *BlockProcessor) WaitFor(hash types.BlockHash) <-chan error {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	c := make(chan error, 1)
+	p.waiters[hash] = append(p.waiters[hash], c)
+	return c
+}
+
+func (p *BlockProcessor) StopWaiting(hash types.BlockHash, c <-chan error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	waiters := p.waiters[hash]
+	for i, waiter := range waiters {
+		if waiter == c {
+			waiters = append(waiters[:i], waiters[i+1:]...)
+			break
+		}
+	}
+	if len(waiters) == 0 {
+		delete(p.waiters, hash)
+	} else {
+		p.waiters[hash] = waiters
+	}
+}
+
+// Starts the workers and writer. Queued blocks are kept across a Stop and
+// processed when the processor is started again.
+func (p *BlockProcessor) Start() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if p.running {
+		return
+	}
+	p.running = true
+	p.stopWorkers = make(chan struct{})
+	p.stopWriter = make(chan struct{})
+
+	for i := 0; i < p.workers; i++ {
+		p.workerGroup.Add(1)
+		go p.verify(p.stopWorkers)
+	}
+	p.writerGroup.Add(1)
+	go p.write(p.stopWriter)
+}
+
+// Stops the workers, then waits for the writer to store every block which
+// has already been verified
+func (p *BlockProcessor) Stop() {
+	p.mutex.Lock()
+	if !p.running {
+		p.mutex.Unlock()
+		return
+	}
+	p.running = false
+	close(p.stopWorkers)
+	p.mutex.Unlock()
+	p.workerGroup.Wait()
+
+	close(p.stopWriter)
+	p.writerGroup.Wait()
+}
+
+func (p *BlockProcessor) verify(stop chan struct{}) {
+	defer p.workerGroup.Done()
+	for {
+		select {
+		case item := <-p.queue:
+			checkSignature(item)
+			p.verified <- item
+		case <-stop:
+			return
+		}
+	}
+}
+
+func checkSignature(item *processItem) {
+	account := store.FetchBlockAccount(item.block)
+	if account == "" {
+		return
+	}
+	item.checked = true
+	item.valid = blocks.VerifyBlockSignature(item.block, account)
+}
+
+func (p *BlockProcessor) write(stop chan struct{}) {
+	defer p.writerGroup.Done()
+	for {
+		select {
+		case item := <-p.verified:
+			p.writeBatch(p.collect(item))
+		case <-stop:
+			for {
+				select {
+				case item := <-p.verified:
+					p.writeBatch(p.collect(item))
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// Gathers whatever else is ready, up to the batch size
+func (p *BlockProcessor) collect(first *processItem) []*processItem {
+	items := []*processItem{first}
+	for len(items) < p.BatchSize {
+		select {
+		case item := <-p.verified:
+			items = append(items, item)
+		default:
+			return items
+		}
+	}
+	return items
+}
+
+func (p *BlockProcessor) writeBatch(items []*processItem) {
+	var batch []*processItem
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		toStore := make([]blocks.Block, len(batch))
+		for i, item := range batch {
+			toStore[i] = item.block
+		}
+		errs, released := store.StoreBlocks(toStore)
+		for i, item := range batch {
+			p.finish(item, errs[i])
+		}
+		for _, r := range released {
+			p.finish(&processItem{block: r.Block}, r.Err)
+		}
+		batch = nil
+	}
+
+	for _, item := range items {
+		if !item.checked {
+			// The block's previous block may be in this batch, so store
+			// what we have before looking up its account again. Blocks
+			// whose account is still unknown are gaps, which the store
+			// holds until their previous block arrives.
+			flush()
+			checkSignature(item)
+		}
+		if item.checked && !item.valid {
+			p.finish(item, errInvalidSignature)
+			continue
+		}
+		batch = append(batch, item)
+	}
+	flush()
+}
+
+func (p *BlockProcessor) finish(item *processItem, err error) {
+	blockProcessed(item.block, err)
+	if item.done != nil {
+		item.done(err)
+	}
+
+	hash := item.block.Hash()
+	p.mutex.Lock()
+	waiters := p.waiters[hash]
+	delete(p.waiters, hash)
+	p.mutex.Unlock()
+
+	for _, c := range waiters {
+		c <- err
+	}
+}
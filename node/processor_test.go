@@ -0,0 +1,152 @@
+package node
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+func waitResult(t *testing.T, c <-chan error) error {
+	select {
+	case err := <-c:
+		return err
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for block")
+		return nil
+	}
+}
+
+func TestBlockProcessor(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	defer os.RemoveAll(store.TestConfig.Path)
+	ActiveElections = NewElections()
+
+	p := NewBlockProcessor(16, 4)
+
+	// The second send's account is only known once the first is stored,
+	// so it's checked when the batch is written
+	first := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	second := testSend(first, blocks.GenesisAmount.Sub(uint128.FromInts(0, 2)))
+	forged := testSend(second, blocks.GenesisAmount.Sub(uint128.FromInts(0, 3)))
+	forged.Signature = second.Signature
+
+	firstDone := p.WaitFor(first.Hash())
+	secondDone := p.WaitFor(second.Hash())
+	forgedDone := p.WaitFor(forged.Hash())
+	var called error = ErrQueueFull
+	p.Add(first, func(err error) { called = err })
+	p.Add(second, nil)
+	p.Add(forged, nil)
+	p.Start()
+	defer p.Stop()
+
+	if err := waitResult(t, firstDone); err != nil {
+		t.Errorf("Failed to store first send: %s", err)
+	}
+	if err := waitResult(t, secondDone); err != nil {
+		t.Errorf("Failed to store second send: %s", err)
+	}
+	if err := waitResult(t, forgedDone); err != errInvalidSignature {
+		t.Errorf("Expected invalid signature, got %v", err)
+	}
+	if called != nil {
+		t.Errorf("Callback wasn't called with the result")
+	}
+	if store.FetchBlock(second.Hash()) == nil || store.FetchBlock(forged.Hash()) != nil {
+		t.Errorf("Wrong blocks stored")
+	}
+	if ActiveElections.Get(second.RootHash()) == nil {
+		t.Errorf("No election started for processed block")
+	}
+
+	// Duplicates report the store's result
+	duplicate := p.WaitFor(first.Hash())
+	p.Add(first, nil)
+	if err := waitResult(t, duplicate); err != store.ErrBlockExists {
+		t.Errorf("Expected existing block, got %v", err)
+	}
+
+	// Gaps are checked once their previous block arrives
+	third := testSend(second, blocks.GenesisAmount.Sub(uint128.FromInts(0, 4)))
+	gap := testSend(third, blocks.GenesisAmount.Sub(uint128.FromInts(0, 5)))
+	gap.Signature = third.Signature
+	gapDone := p.WaitFor(gap.Hash())
+	p.Add(gap, nil)
+	if err := waitResult(t, gapDone); err != store.ErrGap {
+		t.Errorf("Expected gap, got %v", err)
+	}
+	if store.UncheckedCount() != 1 {
+		t.Errorf("Gap block wasn't counted as unchecked")
+	}
+	thirdDone := p.WaitFor(third.Hash())
+	p.Add(third, nil)
+	if err := waitResult(t, thirdDone); err != nil {
+		t.Errorf("Failed to store third send: %s", err)
+	}
+	if store.FetchBlock(gap.Hash()) != nil {
+		t.Errorf("Stored a forged gap block")
+	}
+	if store.UncheckedCount() != 0 {
+		t.Errorf("Unchecked count wasn't updated when the gap was filled")
+	}
+
+	// Released gaps are processed like any other block
+	var observed []blocks.Block
+	unobserve := ObserveNewBlocks(func(block blocks.Block) { observed = append(observed, block) })
+	defer unobserve()
+	fourth := testSend(third, blocks.GenesisAmount.Sub(uint128.FromInts(0, 6)))
+	fifth := testSend(fourth, blocks.GenesisAmount.Sub(uint128.FromInts(0, 7)))
+	fifthDone := p.WaitFor(fifth.Hash())
+	p.Add(fifth, nil)
+	if err := waitResult(t, fifthDone); err != store.ErrGap {
+		t.Errorf("Expected gap, got %v", err)
+	}
+	fourthDone := p.WaitFor(fourth.Hash())
+	fifthDone = p.WaitFor(fifth.Hash())
+	p.Add(fourth, nil)
+	if err := waitResult(t, fourthDone); err != nil {
+		t.Errorf("Failed to store fourth send: %s", err)
+	}
+	if err := waitResult(t, fifthDone); err != nil {
+		t.Errorf("Failed to store released gap block: %s", err)
+	}
+	if store.FetchBlock(fifth.Hash()) == nil {
+		t.Errorf("Released gap block wasn't stored")
+	}
+	if ActiveElections.Get(fifth.RootHash()) == nil {
+		t.Errorf("No election started for released gap block")
+	}
+	if len(observed) != 2 || observed[0].Hash() != fourth.Hash() || observed[1].Hash() != fifth.Hash() {
+		t.Errorf("Observers didn't see the released gap block: %v", observed)
+	}
+}
+
+func TestBlockProcessorBackpressure(t *testing.T) {
+	p := NewBlockProcessor(4, 1)
+	send := testSend(blocks.TestGenesisBlock, uint128.FromInts(0, 1))
+
+	for i := 0; i < 3; i++ {
+		p.Add(send, nil)
+	}
+	if !p.Saturated() {
+		t.Errorf("Processor isn't saturated with %d queued", p.Len())
+	}
+	p.Add(send, nil)
+
+	var dropped error
+	if p.Add(send, func(err error) { dropped = err }) || dropped != ErrQueueFull {
+		t.Errorf("Full queue accepted a block")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if p.AddWait(ctx, send, nil) != context.DeadlineExceeded {
+		t.Errorf("AddWait didn't wait for room")
+	}
+}
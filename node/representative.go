@@ -0,0 +1,144 @@
+package node
+
+import (
+	"encoding/binary"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/svaishnavy/crypto/ed25519"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+)
+
+// Maximum number of votes generated per second, and how long we wait before
+// voting on the same root again.
+var VoteRateLimit = 100
+var VoteRootInterval = 5 * time.Second
+
+var errVoteLimited = errors.New("Vote rate limit reached")
+
+// Set when this node votes on behalf of a representative account
+var LocalRepresentative *Representative
+
+// A representative whose key this node holds and votes with
+type Representative struct {
+	privateKey ed25519.PrivateKey
+	Account    types.Account
+
+	mutex     sync.Mutex
+	sequence  uint64
+	tokens    int
+	refilled  time.Time
+	lastVotes map[types.BlockHash]time.Time
+}
+
+func NewRepresentative(private string) *Representative {
+	pub, priv := address.KeypairFromPrivateKey(private)
+	account := address.PubKeyToAddress(pub)
+
+	return &Representative{
+		privateKey: priv,
+		Account:    account,
+		sequence:   store.GetVoteSequence(account),
+		tokens:     VoteRateLimit,
+		refilled:   time.Now(),
+		lastVotes:  make(map[types.BlockHash]time.Time),
+	}
+}
+
+// Configures the node to answer confirm_req messages as a representative
+func SetRepresentative(private string) *Representative {
+	LocalRepresentative = NewRepresentative(private)
+	return LocalRepresentative
+}
+
+func (r *Representative) HasWeight() bool {
+	weight := store.GetWeight(r.Account)
+	return weight.Hi != 0 || weight.Lo != 0
+}
+
+// Returns the block we consider to be winning for the requested block's
+// root, or nil if we don't know of any block for that root.
+func winningBlock(block blocks.Block) blocks.Block {
+	winner := ActiveElections.Winner(block.RootHash())
+	if winner != nil {
+		return winner
+	}
+	return store.FetchByRoot(block)
+}
+
+// Generates a signed vote for a block. The sequence number is persisted
+// before the vote is returned so it never goes backwards.
+func (r *Representative) Vote(block blocks.Block) (*MessageConfirmAck, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	now := time.Now()
+	if !r.allow(block.RootHash(), now) {
+		return nil, errVoteLimited
+	}
+
+	err := store.SetVoteSequence(r.Account, r.sequence+1)
+	if err != nil {
+		return nil, err
+	}
+	r.sequence++
+	r.lastVotes[block.RootHash()] = now
+
+	return CreateConfirmAck(block, r.sequence, r.privateKey), nil
+}
+
+func (r *Representative) allow(root types.BlockHash, now time.Time) bool {
+	elapsed := now.Sub(r.refilled)
+	if elapsed >= time.Second {
+		r.tokens = VoteRateLimit
+		r.refilled = now
+		for root, voted := range r.lastVotes {
+			if now.Sub(voted) >= VoteRootInterval {
+				delete(r.lastVotes, root)
+			}
+		}
+	}
+
+	if r.tokens <= 0 {
+		return false
+	}
+	if voted, ok := r.lastVotes[root]; ok && now.Sub(voted) < VoteRootInterval {
+		return false
+	}
+
+	r.tokens--
+	return true
+}
+
+// Answers a confirm_req by voting for the block we consider winning
+func (r *Representative) Reply(block blocks.Block, peer Peer) error {
+	if !r.HasWeight() {
+		return nil
+	}
+
+	winner := winningBlock(block)
+	if winner == nil {
+		return nil
+	}
+
+	vote, err := r.Vote(winner)
+	if err != nil {
+		return err
+	}
+	return peer.SendMessage(vote)
+}
+
+func CreateConfirmAck(block blocks.Block, sequence uint64, private ed25519.PrivateKey) *MessageConfirmAck {
+	var m MessageConfirmAck
+	m.MessageBlock = NewMessageBlock(block)
+	m.MessageHeader = newHeader(Message_confirm_ack, m.MessageBlock.Type)
+
+	copy(m.Account[:], private[32:])
+	binary.LittleEndian.PutUint64(m.Sequence[:], sequence)
+	copy(m.Signature[:], ed25519.Sign(private, m.MessageVote.Hash()))
+	return &m
+}
@@ -0,0 +1,56 @@
+package node
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+func TestRepresentativeVote(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	ActiveElections = NewElections()
+
+	rep := NewRepresentative(blocks.TestPrivateKey)
+	if !rep.HasWeight() {
+		t.Errorf("Genesis representative should have voting weight")
+	}
+
+	send := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	fork := testSend(blocks.TestGenesisBlock, blocks.GenesisAmount.Sub(uint128.FromInts(0, 2)))
+	processBlock(send)
+
+	if winningBlock(fork).Hash() != send.Hash() {
+		t.Errorf("Should vote for the block in the ledger, not the fork")
+	}
+
+	vote, err := rep.Vote(send)
+	if err != nil {
+		t.Errorf("Failed to generate vote: %s", err)
+	}
+	if !vote.Verify() || vote.SequenceNumber() != 1 {
+		t.Errorf("Generated an invalid vote")
+	}
+
+	var buf bytes.Buffer
+	vote.Write(&buf)
+	var read MessageConfirmAck
+	if read.Read(&buf) != nil || !read.Verify() {
+		t.Errorf("Failed to read back generated vote")
+	}
+
+	if _, err = rep.Vote(send); err != errVoteLimited {
+		t.Errorf("Voted twice on the same root")
+	}
+
+	restarted := NewRepresentative(blocks.TestPrivateKey)
+	vote, err = restarted.Vote(blocks.TestGenesisBlock)
+	if err != nil || vote.SequenceNumber() != 2 {
+		t.Errorf("Vote sequence wasn't persisted")
+	}
+	os.RemoveAll(store.TestConfig.Path)
+}
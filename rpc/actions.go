@@ -0,0 +1,623 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package rpc
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+	"github.com/svaishnavy/nano/work"
+)
+
+// Version reported by the version action
+const (
+	RpcVersion   = "1"
+	StoreVersion = "1"
+	NodeVendor   = "GoNano"
+)
+
+// Default number of blocks returned by account_history
+const defaultHistoryCount = 100
+
+// work_generate refuses difficulties more than this multiple of the
+// network's threshold, as the reference node does by default, and gives up
+// after WorkGenerateTimeout
+var MaxWorkMultiplier = 64.0
+var WorkGenerateTimeout = 2 * time.Minute
+
+var actions map[string]handler
+
+func init() {
+	actions = map[string]handler{
+		"account_balance":   accountBalance,
+		"account_info":      accountInfo,
+		"account_history":   accountHistory,
+		"block_info":        blockInfo,
+		"blocks_info":       blocksInfo,
+		"pending":           pending,
+		"process":           process,
+		"peers":             peers,
+		"representatives":   representatives,
+		"work_generate":     workGenerate,
+		"work_cancel":       workCancel,
+		"work_validate":     workValidate,
+		"active_difficulty": activeDifficulty,
+		"block_count":       blockCount,
+		"version":           version,
+	}
+}
+
+func hexBytes(s string, length int) ([]byte, error) {
+	bytes, err := hex.DecodeString(s)
+	if err != nil || len(bytes) != length {
+		return nil, errors.New("Invalid hex")
+	}
+	return bytes, nil
+}
+
+var errAccountNotFound = errors.New("Account not found")
+var errBlockNotFound = errors.New("Block not found")
+
+func pendingBalance(account types.Account) uint128.Uint128 {
+	total := uint128.FromInts(0, 0)
+	for _, p := range store.FetchPending(account, 0) {
+		total = total.Add(p.Amount)
+	}
+	return total
+}
+
+func accountBalance(ctx context.Context, s *Server, r request) (interface{}, error) {
+	account, err := r.account("account")
+	if err != nil {
+		return nil, err
+	}
+
+	balance := uint128.FromInts(0, 0)
+	if info := store.FetchAccountInfo(account); info != nil {
+		balance = info.Balance
+	}
+
+	return map[string]string{
+		"balance": formatAmount(balance),
+		"pending": formatAmount(pendingBalance(account)),
+	}, nil
+}
+
+// Returns the most recent block which set the account's representative
+func representativeBlock(head types.BlockHash) types.BlockHash {
+	for block := store.FetchBlock(head); block != nil; block = store.FetchBlock(block.PreviousBlockHash()) {
+		if block.Type() == blocks.Open || block.Type() == blocks.Change {
+			return block.Hash()
+		}
+	}
+	return ""
+}
+
+func accountInfo(ctx context.Context, s *Server, r request) (interface{}, error) {
+	account, err := r.account("account")
+	if err != nil {
+		return nil, err
+	}
+
+	info := store.FetchAccountInfo(account)
+	if info == nil {
+		return nil, errAccountNotFound
+	}
+
+	response := map[string]string{
+		"frontier":             string(info.Head),
+		"open_block":           string(info.Open),
+		"representative_block": string(representativeBlock(info.Head)),
+		"balance":              formatAmount(info.Balance),
+		"modified_timestamp":   "0",
+		"block_count":          strconv.FormatUint(info.BlockCount, 10),
+	}
+	if head := store.FetchBlockInfo(info.Head); head != nil {
+		response["modified_timestamp"] = strconv.FormatInt(head.Timestamp.Unix(), 10)
+	}
+	if r.flag("representative") {
+		response["representative"] = string(info.Representative)
+	}
+	if r.flag("weight") {
+		response["weight"] = formatAmount(store.GetWeight(account))
+	}
+	if r.flag("pending") {
+		response["pending"] = formatAmount(pendingBalance(account))
+	}
+	return response, nil
+}
+
+type historyEntry struct {
+	Type    string `json:"type"`
+	Account string `json:"account"`
+	Amount  string `json:"amount"`
+	Hash    string `json:"hash"`
+}
+
+func accountHistory(ctx context.Context, s *Server, r request) (interface{}, error) {
+	account, err := r.account("account")
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.count("count", defaultHistoryCount)
+	if err != nil {
+		return nil, err
+	}
+
+	info := store.FetchAccountInfo(account)
+	if info == nil {
+		return nil, errAccountNotFound
+	}
+
+	head := info.Head
+	if r.has("head") {
+		head, err = r.hash("head")
+		if err != nil {
+			return nil, err
+		}
+		if b := store.FetchBlockInfo(head); b == nil || b.Account != account {
+			return nil, errBlockNotFound
+		}
+	}
+
+	history := []historyEntry{}
+	block := store.FetchBlock(head)
+	for block != nil && len(history) < count {
+		entry := historyEntry{Hash: string(block.Hash())}
+		switch b := block.(type) {
+		case *blocks.SendBlock:
+			entry.Type = "send"
+			entry.Account = string(b.Destination)
+		case *blocks.OpenBlock:
+			entry.Type = "receive"
+			entry.Account = string(sourceAccount(b.SourceHash, b.Account))
+		case *blocks.ReceiveBlock:
+			entry.Type = "receive"
+			entry.Account = string(sourceAccount(b.SourceHash, account))
+		}
+
+		// Change blocks don't move funds, so they're left out as in the
+		// reference node
+		if entry.Type != "" {
+			entry.Amount = formatAmount(store.GetAmount(block))
+			history = append(history, entry)
+		}
+		block = store.FetchBlock(block.PreviousBlockHash())
+	}
+
+	response := map[string]interface{}{
+		"account": account,
+		"history": history,
+	}
+	if block != nil {
+		response["previous"] = block.Hash()
+	}
+	return response, nil
+}
+
+// The account which sent a block's source, or def for the genesis block
+// whose source isn't a send
+func sourceAccount(source types.BlockHash, def types.Account) types.Account {
+	info := store.FetchBlockInfo(source)
+	if info == nil {
+		return def
+	}
+	return info.Account
+}
+
+func blockContents(block blocks.Block, asJson bool) (interface{}, error) {
+	contents, err := blocks.ToJson(block)
+	if err != nil {
+		return nil, err
+	}
+	if asJson {
+		return json.RawMessage(contents), nil
+	}
+	return string(contents), nil
+}
+
+func describeBlock(hash types.BlockHash, r request) (map[string]interface{}, error) {
+	block := store.FetchBlock(hash)
+	info := store.FetchBlockInfo(hash)
+	if block == nil || info == nil {
+		return nil, errBlockNotFound
+	}
+
+	contents, err := blockContents(block, r.flag("json_block"))
+	if err != nil {
+		return nil, err
+	}
+
+	return map[string]interface{}{
+		"block_account":   info.Account,
+		"amount":          formatAmount(info.Amount),
+		"balance":         formatAmount(info.Balance),
+		"height":          strconv.FormatUint(info.Height, 10),
+		"local_timestamp": strconv.FormatInt(info.Timestamp.Unix(), 10),
+		"confirmed":       strconv.FormatBool(block.IsConfirmed()),
+		"contents":        contents,
+	}, nil
+}
+
+func blockInfo(ctx context.Context, s *Server, r request) (interface{}, error) {
+	hash, err := r.hash("hash")
+	if err != nil {
+		return nil, err
+	}
+	return describeBlock(hash, r)
+}
+
+func blocksInfo(ctx context.Context, s *Server, r request) (interface{}, error) {
+	result := make(map[types.BlockHash]interface{})
+	for _, h := range r.list("hashes") {
+		hash, err := parseHash(h)
+		if err != nil {
+			return nil, err
+		}
+
+		description, err := describeBlock(hash, r)
+		if err != nil {
+			return nil, err
+		}
+
+		block := store.FetchBlock(hash)
+		if r.flag("pending") {
+			description["pending"] = "0"
+			if send, ok := block.(*blocks.SendBlock); ok && isPending(send) {
+				description["pending"] = "1"
+			}
+		}
+		if r.flag("source") {
+			description["source_account"] = "0"
+			switch b := block.(type) {
+			case *blocks.OpenBlock:
+				description["source_account"] = sourceAccount(b.SourceHash, b.Account)
+			case *blocks.ReceiveBlock:
+				description["source_account"] = sourceAccount(b.SourceHash, "0")
+			}
+		}
+		result[hash] = description
+	}
+
+	return map[string]interface{}{"blocks": result}, nil
+}
+
+func isPending(send *blocks.SendBlock) bool {
+	for _, p := range store.FetchPending(send.Destination, 0) {
+		if p.Hash == send.Hash() {
+			return true
+		}
+	}
+	return false
+}
+
+func pending(ctx context.Context, s *Server, r request) (interface{}, error) {
+	account, err := r.account("account")
+	if err != nil {
+		return nil, err
+	}
+	count, err := r.count("count", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	threshold := uint128.FromInts(0, 0)
+	if r.has("threshold") {
+		threshold, err = parseAmount(r.str("threshold"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var matching []store.PendingBlock
+	for _, p := range store.FetchPending(account, 0) {
+		if count > 0 && len(matching) == count {
+			break
+		}
+		if p.Amount.Compare(threshold) >= 0 {
+			matching = append(matching, p)
+		}
+	}
+
+	// The shape of the response depends on which options were given
+	if r.flag("source") {
+		result := make(map[types.BlockHash]interface{})
+		for _, p := range matching {
+			result[p.Hash] = map[string]string{
+				"amount": formatAmount(p.Amount),
+				"source": string(p.Source),
+			}
+		}
+		return map[string]interface{}{"blocks": result}, nil
+	}
+	if r.has("threshold") {
+		result := make(map[types.BlockHash]string)
+		for _, p := range matching {
+			result[p.Hash] = formatAmount(p.Amount)
+		}
+		return map[string]interface{}{"blocks": result}, nil
+	}
+
+	hashes := []types.BlockHash{}
+	for _, p := range matching {
+		hashes = append(hashes, p.Hash)
+	}
+	return map[string]interface{}{"blocks": hashes}, nil
+}
+
+func process(ctx context.Context, s *Server, r request) (interface{}, error) {
+	var data []byte
+	switch b := r["block"].(type) {
+	case string:
+		data = []byte(b)
+	case map[string]interface{}:
+		data, _ = json.Marshal(b)
+	default:
+		return nil, errors.New("Block is invalid")
+	}
+
+	block, err := blocks.ParseBlock(data)
+	if err != nil {
+		return nil, errors.New("Block is invalid")
+	}
+	if !blocks.ValidateBlockWork(block) {
+		return nil, errors.New("Block work is less than threshold")
+	}
+	if store.FetchBlock(block.Hash()) != nil {
+		return nil, errors.New("Old block")
+	}
+
+	// Errors from validating and storing the block are sent straight away,
+	// anything later is about confirmation which we don't wait for
+	select {
+	case result := <-s.Node.Process(block):
+		if result.Err != nil {
+			return nil, processError(result.Err)
+		}
+	default:
+	}
+
+	return map[string]types.BlockHash{"hash": block.Hash()}, nil
+}
+
+func processError(err error) error {
+	switch err {
+	case store.ErrFork:
+		return errors.New("Fork")
+	default:
+		return err
+	}
+}
+
+// Peer addresses are written as IPv6, as the reference node does
+func formatPeer(peer node.Peer) string {
+	ip := peer.IP
+	host := ip.String()
+	if ip.To4() != nil {
+		host = "::ffff:" + ip.To4().String()
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(peer.Port)))
+}
+
+func peers(ctx context.Context, s *Server, r request) (interface{}, error) {
+	result := make(map[string]string)
+	for _, peer := range node.Peers.List() {
+		result[formatPeer(peer)] = strconv.Itoa(int(peer.VersionUsing))
+	}
+	return map[string]interface{}{"peers": result}, nil
+}
+
+// A JSON object which keeps its keys in the order they were added
+type orderedObject struct {
+	keys   []string
+	values map[string]string
+}
+
+func (o orderedObject) MarshalJSON() ([]byte, error) {
+	buf := []byte{'{'}
+	for i, key := range o.keys {
+		if i > 0 {
+			buf = append(buf, ',')
+		}
+		k, _ := json.Marshal(key)
+		v, _ := json.Marshal(o.values[key])
+		buf = append(buf, k...)
+		buf = append(buf, ':')
+		buf = append(buf, v...)
+	}
+	return append(buf, '}'), nil
+}
+
+func representatives(ctx context.Context, s *Server, r request) (interface{}, error) {
+	count, err := r.count("count", 0)
+	if err != nil {
+		return nil, err
+	}
+
+	weights := store.Representatives()
+	reps := make([]types.Account, 0, len(weights))
+	for rep := range weights {
+		reps = append(reps, rep)
+	}
+
+	if r.flag("sorting") {
+		sort.Slice(reps, func(i, j int) bool {
+			return weights[reps[i]].Compare(weights[reps[j]]) > 0
+		})
+	} else {
+		sort.Slice(reps, func(i, j int) bool { return reps[i] < reps[j] })
+	}
+	if count > 0 && len(reps) > count {
+		reps = reps[:count]
+	}
+
+	result := orderedObject{values: make(map[string]string)}
+	for _, rep := range reps {
+		result.keys = append(result.keys, string(rep))
+		result.values[string(rep)] = formatAmount(weights[rep])
+	}
+	return map[string]interface{}{"representatives": result}, nil
+}
+
+func formatMultiplier(d blocks.Difficulty, base blocks.Difficulty) string {
+	return strconv.FormatFloat(d.Multiplier(base), 'f', -1, 64)
+}
+
+func workGenerate(ctx context.Context, s *Server, r request) (interface{}, error) {
+	hash, err := r.hash("hash")
+	if err != nil {
+		return nil, err
+	}
+	threshold, err := r.difficulty(blocks.WorkThreshold)
+	if err != nil {
+		return nil, err
+	}
+	if threshold.Multiplier(blocks.WorkThreshold) > MaxWorkMultiplier {
+		return nil, errors.New("Difficulty out of valid range")
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, WorkGenerateTimeout)
+	defer s.finishWork(hash, s.startWork(hash, cancel))
+	w, err := work.Default.Generate(ctx, hash, threshold)
+	switch {
+	case ctx.Err() == context.DeadlineExceeded:
+		return nil, errors.New("Work generation timed out")
+	case ctx.Err() != nil:
+		return nil, errors.New("Work generation cancelled")
+	case err != nil:
+		return nil, err
+	}
+	difficulty := blocks.WorkDifficulty(hash, w)
+	return map[string]string{
+		"work":       string(w),
+		"difficulty": difficulty.String(),
+		"multiplier": formatMultiplier(difficulty, blocks.WorkThreshold),
+	}, nil
+}
+
+// Stops generating work for a hash, for every request asking for it
+func workCancel(ctx context.Context, s *Server, r request) (interface{}, error) {
+	hash, err := r.hash("hash")
+	if err != nil {
+		return nil, err
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, j := range s.jobs[hash] {
+		j.cancel()
+	}
+	return map[string]string{"success": ""}, nil
+}
+
+// Tracks work being generated so work_cancel can stop it
+func (s *Server) startWork(hash types.BlockHash, cancel context.CancelFunc) *workJob {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j := &workJob{cancel: cancel}
+	s.jobs[hash] = append(s.jobs[hash], j)
+	return j
+}
+
+func (s *Server) finishWork(hash types.BlockHash, j *workJob) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	j.cancel()
+	jobs := s.jobs[hash]
+	for i, other := range jobs {
+		if other == j {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			break
+		}
+	}
+	if len(jobs) == 0 {
+		delete(s.jobs, hash)
+	} else {
+		s.jobs[hash] = jobs
+	}
+}
+
+func workValidate(ctx context.Context, s *Server, r request) (interface{}, error) {
+	hash, err := r.hash("hash")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := hexBytes(r.str("work"), 8); err != nil {
+		return nil, errors.New("Bad work")
+	}
+	threshold, err := r.difficulty(blocks.WorkThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	difficulty := blocks.WorkDifficulty(hash, types.Work(r.str("work")))
+	valid := "0"
+	if difficulty >= threshold {
+		valid = "1"
+	}
+	return map[string]string{
+		"valid":      valid,
+		"difficulty": difficulty.String(),
+		"multiplier": formatMultiplier(difficulty, blocks.WorkThreshold),
+	}, nil
+}
+
+func activeDifficulty(ctx context.Context, s *Server, r request) (interface{}, error) {
+	current := node.ActiveElections.Difficulty()
+	return map[string]string{
+		"network_minimum":         blocks.WorkThreshold.String(),
+		"network_receive_minimum": blocks.ReceiveWorkThreshold().String(),
+		"network_current":         current.String(),
+		"multiplier":              formatMultiplier(current, blocks.WorkThreshold),
+	}, nil
+}
+
+func blockCount(ctx context.Context, s *Server, r request) (interface{}, error) {
+	count, unchecked := store.BlockCount()
+	return map[string]string{
+		"count":     strconv.FormatUint(count, 10),
+		"unchecked": strconv.FormatUint(unchecked, 10),
+	}, nil
+}
+
+func version(ctx context.Context, s *Server, r request) (interface{}, error) {
+	return map[string]string{
+		"rpc_version":      RpcVersion,
+		"store_version":    StoreVersion,
+		"protocol_version": strconv.Itoa(int(node.VersionUsing)),
+		"node_vendor":      NodeVendor,
+	}, nil
+}
@@ -0,0 +1,268 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package rpc
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/logging"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+var logger = logging.New("rpc")
+
+// Requests larger than this are rejected
+const maxRequestSize = 1 << 20
+
+// An RPC request. Clients of the reference node send numbers and booleans
+// both as JSON values and as strings, so fields are read with the helpers
+// below rather than decoded into structs.
+type request map[string]interface{}
+
+// Handlers are given the HTTP request's context, which is cancelled if the
+// client goes away
+type handler func(ctx context.Context, s *Server, r request) (interface{}, error)
+
+// An HTTP server speaking the reference node's JSON RPC protocol
+type Server struct {
+	Node *node.Node
+	Addr string
+
+	http     *http.Server
+	listener net.Listener
+
+	// Work being generated for each hash
+	mutex sync.Mutex
+	jobs  map[types.BlockHash][]*workJob
+}
+
+type workJob struct {
+	cancel context.CancelFunc
+}
+
+func NewServer(n *node.Node, addr string) *Server {
+	return &Server{Node: n, Addr: addr, jobs: make(map[types.BlockHash][]*workJob)}
+}
+
+// Starts serving requests in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.http = &http.Server{Handler: s}
+
+	logger.Infof("Listening for rpc requests on %s", listener.Addr())
+	go func() {
+		err := s.http.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Errorf("RPC server failed: %s", err)
+		}
+	}()
+	return nil
+}
+
+// Stops accepting requests and waits up to a few seconds for those in
+// flight to finish
+func (s *Server) Stop() error {
+	if s.http == nil {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.http.Shutdown(ctx)
+}
+
+// The address the server is listening on, once started
+func (s *Server) ListenAddr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "RPC requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxRequestSize))
+	if err != nil {
+		writeResponse(w, errorResponse("Unable to read request"))
+		return
+	}
+
+	var r request
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		writeResponse(w, errorResponse("Unable to parse JSON"))
+		return
+	}
+
+	writeResponse(w, s.handle(req.Context(), r))
+}
+
+func (s *Server) handle(ctx context.Context, r request) interface{} {
+	action, _ := r["action"].(string)
+	fn := actions[action]
+	if fn == nil {
+		return errorResponse("Unknown command")
+	}
+
+	response, err := fn(ctx, s, r)
+	if err != nil {
+		return errorResponse(err.Error())
+	}
+	return response
+}
+
+func errorResponse(message string) interface{} {
+	return map[string]string{"error": message}
+}
+
+func writeResponse(w http.ResponseWriter, response interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(response)
+	if err != nil {
+		logger.Warnf("Failed to write rpc response: %s", err)
+	}
+}
+
+func (r request) has(key string) bool {
+	_, ok := r[key]
+	return ok
+}
+
+func (r request) str(key string) string {
+	switch v := r[key].(type) {
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	default:
+		return ""
+	}
+}
+
+func (r request) flag(key string) bool {
+	switch strings.ToLower(r.str(key)) {
+	case "true", "1":
+		return true
+	default:
+		return false
+	}
+}
+
+// Reads an optional count, returning def if it's missing
+func (r request) count(key string, def int) (int, error) {
+	if !r.has(key) {
+		return def, nil
+	}
+	count, err := strconv.Atoi(r.str(key))
+	if err != nil || count < 0 {
+		return 0, errors.Errorf("Invalid %s parameter", key)
+	}
+	return count, nil
+}
+
+// Reads the work difficulty a request asks for, either as hex or as a
+// multiplier of base, returning base if neither is given
+func (r request) difficulty(base blocks.Difficulty) (blocks.Difficulty, error) {
+	switch {
+	case r.has("difficulty"):
+		d, err := blocks.ParseDifficulty(r.str("difficulty"))
+		if err != nil {
+			return 0, errors.New("Bad difficulty")
+		}
+		return d, nil
+	case r.has("multiplier"):
+		multiplier, err := strconv.ParseFloat(r.str("multiplier"), 64)
+		if err != nil || multiplier <= 0 {
+			return 0, errors.New("Bad multiplier")
+		}
+		return blocks.FromMultiplier(multiplier, base), nil
+	default:
+		return base, nil
+	}
+}
+
+func (r request) account(key string) (types.Account, error) {
+	account := types.Account(r.str(key))
+	if !address.ValidateAddress(account) {
+		return "", errors.New("Bad account number")
+	}
+	return account, nil
+}
+
+func parseHash(s string) (types.BlockHash, error) {
+	bytes, err := hexBytes(s, 32)
+	if err != nil {
+		return "", errors.New("Bad hash number")
+	}
+	return types.BlockHashFromBytes(bytes), nil
+}
+
+func (r request) hash(key string) (types.BlockHash, error) {
+	return parseHash(r.str(key))
+}
+
+func (r request) list(key string) []string {
+	values, _ := r[key].([]interface{})
+	var result []string
+	for _, v := range values {
+		if s, ok := v.(string); ok {
+			result = append(result, s)
+		}
+	}
+	return result
+}
+
+// Amounts are sent as decimal strings of raw
+func formatAmount(u uint128.Uint128) string {
+	return u.Decimal()
+}
+
+func parseAmount(s string) (uint128.Uint128, error) {
+	amount, err := uint128.FromDecimal(s)
+	if err != nil {
+		return uint128.Uint128{}, errors.New("Bad amount number")
+	}
+	return amount, nil
+}
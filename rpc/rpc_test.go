@@ -0,0 +1,196 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package rpc
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+func call(t *testing.T, url string, req map[string]interface{}) map[string]interface{} {
+	body, _ := json.Marshal(req)
+	resp, err := http.Post(url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var result map[string]interface{}
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result
+}
+
+func TestRpc(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	defer os.RemoveAll(store.TestConfig.Path)
+
+	server := httptest.NewServer(NewServer(node.NewNode(), ""))
+	defer server.Close()
+	genesis := blocks.TestGenesisBlock
+
+	version := call(t, server.URL, map[string]interface{}{"action": "version"})
+	if version["rpc_version"] != RpcVersion || version["node_vendor"] != NodeVendor {
+		t.Errorf("Unexpected version %v", version)
+	}
+
+	if r := call(t, server.URL, map[string]interface{}{"action": "nonsense"}); r["error"] != "Unknown command" {
+		t.Errorf("Unknown action gave %v", r)
+	}
+	if r := call(t, server.URL, map[string]interface{}{"action": "account_balance", "account": "xrb"}); r["error"] != "Bad account number" {
+		t.Errorf("Bad account gave %v", r)
+	}
+
+	pub, _ := address.GenerateKey()
+	destination := address.PubKeyToAddress(pub)
+	_, priv := address.KeypairFromPrivateKey(blocks.TestPrivateKey)
+	send := blocks.SendBlock{
+		PreviousHash: genesis.Hash(),
+		Destination:  destination,
+		Balance:      blocks.GenesisAmount.Sub(uint128.FromInts(0, 1000)),
+	}
+	send.Work = blocks.GenerateWork(genesis)
+	send.Signature = send.Hash().Sign(priv)
+	contents, _ := blocks.ToJson(&send)
+
+	processed := call(t, server.URL, map[string]interface{}{"action": "process", "block": string(contents)})
+	if processed["hash"] != string(send.Hash()) {
+		t.Fatalf("Failed to process block: %v", processed)
+	}
+	processed = call(t, server.URL, map[string]interface{}{"action": "process", "block": string(contents)})
+	if processed["error"] != "Old block" {
+		t.Errorf("Expected old block, got %v", processed)
+	}
+
+	count := call(t, server.URL, map[string]interface{}{"action": "block_count"})
+	if count["count"] != "2" || count["unchecked"] != "0" {
+		t.Errorf("Wrong block count %v", count)
+	}
+
+	balance := call(t, server.URL, map[string]interface{}{"action": "account_balance", "account": destination})
+	if balance["balance"] != "0" || balance["pending"] != "1000" {
+		t.Errorf("Wrong balance %v", balance)
+	}
+
+	pending := call(t, server.URL, map[string]interface{}{"action": "pending", "account": destination, "count": "1"})
+	if hashes, _ := pending["blocks"].([]interface{}); len(hashes) != 1 || hashes[0] != string(send.Hash()) {
+		t.Errorf("Wrong pending blocks %v", pending)
+	}
+	pending = call(t, server.URL, map[string]interface{}{"action": "pending", "account": destination, "threshold": "1001"})
+	if amounts, _ := pending["blocks"].(map[string]interface{}); len(amounts) != 0 {
+		t.Errorf("Pending ignored threshold %v", pending)
+	}
+
+	info := call(t, server.URL, map[string]interface{}{"action": "account_info", "account": genesis.Account, "representative": "true"})
+	if info["frontier"] != string(send.Hash()) || info["block_count"] != "2" || info["representative"] != string(genesis.Representative) {
+		t.Errorf("Wrong account info %v", info)
+	}
+	if r := call(t, server.URL, map[string]interface{}{"action": "account_info", "account": destination}); r["error"] != "Account not found" {
+		t.Errorf("Unopened account gave %v", r)
+	}
+
+	history := call(t, server.URL, map[string]interface{}{"action": "account_history", "account": genesis.Account, "count": 1})
+	entries, _ := history["history"].([]interface{})
+	if len(entries) != 1 || history["previous"] != string(genesis.Hash()) {
+		t.Fatalf("Wrong history %v", history)
+	}
+	if entry := entries[0].(map[string]interface{}); entry["type"] != "send" || entry["amount"] != "1000" || entry["account"] != string(destination) {
+		t.Errorf("Wrong history entry %v", entry)
+	}
+
+	block := call(t, server.URL, map[string]interface{}{"action": "block_info", "hash": send.Hash(), "json_block": "true"})
+	if block["amount"] != "1000" || block["height"] != "2" || block["block_account"] != string(genesis.Account) {
+		t.Errorf("Wrong block info %v", block)
+	}
+	if c, _ := block["contents"].(map[string]interface{}); c["type"] != "send" {
+		t.Errorf("Wrong block contents %v", block["contents"])
+	}
+
+	multiple := call(t, server.URL, map[string]interface{}{"action": "blocks_info", "hashes": []string{string(send.Hash())}, "pending": "true"})
+	if b, _ := multiple["blocks"].(map[string]interface{}); b == nil || b[string(send.Hash())].(map[string]interface{})["pending"] != "1" {
+		t.Errorf("Wrong blocks info %v", multiple)
+	}
+
+	reps := call(t, server.URL, map[string]interface{}{"action": "representatives"})
+	if r, _ := reps["representatives"].(map[string]interface{}); r[string(genesis.Representative)] == nil {
+		t.Errorf("Wrong representatives %v", reps)
+	}
+
+	valid := call(t, server.URL, map[string]interface{}{"action": "work_validate", "hash": genesis.Hash(), "work": send.Work})
+	if valid["valid"] != "1" {
+		t.Errorf("Valid work was rejected")
+	}
+	work := call(t, server.URL, map[string]interface{}{"action": "work_generate", "hash": send.Hash()})
+	valid = call(t, server.URL, map[string]interface{}{"action": "work_validate", "hash": send.Hash(), "work": work["work"]})
+	if valid["valid"] != "1" || valid["difficulty"] != work["difficulty"] {
+		t.Errorf("Generated work was invalid %v", work)
+	}
+
+	work = call(t, server.URL, map[string]interface{}{"action": "work_generate", "hash": send.Hash(), "multiplier": "4"})
+	if m, _ := strconv.ParseFloat(work["multiplier"].(string), 64); m < 4 {
+		t.Errorf("Work is easier than requested %v", work)
+	}
+	valid = call(t, server.URL, map[string]interface{}{"action": "work_validate", "hash": send.Hash(), "work": work["work"], "difficulty": "ffffffffffffffff"})
+	if valid["valid"] != "0" {
+		t.Errorf("Work passed an impossible difficulty %v", valid)
+	}
+
+	// Work is limited in difficulty and time
+	work = call(t, server.URL, map[string]interface{}{"action": "work_generate", "hash": send.Hash(), "difficulty": "ffffffffffffffff"})
+	if work["error"] != "Difficulty out of valid range" {
+		t.Errorf("Expected difficulty out of range, got %v", work)
+	}
+	MaxWorkMultiplier, WorkGenerateTimeout = math.Inf(1), 50*time.Millisecond
+	defer func() { MaxWorkMultiplier, WorkGenerateTimeout = 64, 2*time.Minute }()
+	work = call(t, server.URL, map[string]interface{}{"action": "work_generate", "hash": send.Hash(), "difficulty": "ffffffffffffffff"})
+	if work["error"] != "Work generation timed out" {
+		t.Errorf("Expected a timeout, got %v", work)
+	}
+	if cancelled := call(t, server.URL, map[string]interface{}{"action": "work_cancel", "hash": send.Hash()}); cancelled["error"] != nil {
+		t.Errorf("Failed to cancel work %v", cancelled)
+	}
+
+	active := call(t, server.URL, map[string]interface{}{"action": "active_difficulty"})
+	if m, _ := strconv.ParseFloat(active["multiplier"].(string), 64); active["network_minimum"] != blocks.WorkThreshold.String() || m < 1 {
+		t.Errorf("Wrong active difficulty %v", active)
+	}
+}
@@ -0,0 +1,593 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package store
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"time"
+
+	"github.com/dgraph-io/badger"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+// Blocks and open blocks are keyed directly on their 32 byte hash or
+// account, so every index key is prefixed to keep it out of that space.
+const (
+	prefixAccount   byte = 'a'
+	prefixHeight    byte = 'h'
+	prefixOwner     byte = 'o'
+	prefixPending   byte = 'p'
+	prefixReceiver  byte = 'r'
+	prefixSequence  byte = 'q'
+	prefixSuccessor byte = 's'
+	prefixWeight    byte = 'w'
+)
+
+// The current state of an account chain
+type AccountInfo struct {
+	Head           types.BlockHash
+	Open           types.BlockHash
+	Representative types.Account
+	Balance        uint128.Uint128
+	BlockCount     uint64
+}
+
+func indexKey(prefix byte, key []byte) []byte {
+	return append([]byte{prefix}, key...)
+}
+
+func getIndex(conn *badger.Txn, prefix byte, key []byte) []byte {
+	item, err := conn.Get(indexKey(prefix, key))
+	if err != nil {
+		return nil
+	}
+	value, err := item.Value()
+	if err != nil {
+		return nil
+	}
+	return value
+}
+
+func setIndex(conn *badger.Txn, prefix byte, key []byte, value []byte) {
+	err := conn.Set(indexKey(prefix, key), value)
+	if err != nil {
+		panic(err)
+	}
+}
+
+func deleteIndex(conn *badger.Txn, prefix byte, key []byte) {
+	err := conn.Delete(indexKey(prefix, key))
+	if err != nil {
+		panic(err)
+	}
+}
+
+func FetchAccountInfo(account types.Account) *AccountInfo {
+	conn := getConn()
+	defer releaseConn(conn)
+	return fetchAccountInfo(conn, account)
+}
+
+func fetchAccountInfo(conn *badger.Txn, account types.Account) *AccountInfo {
+	account_bytes, err := address.AddressToPub(account)
+	if err != nil {
+		return nil
+	}
+
+	value := getIndex(conn, prefixAccount, account_bytes)
+	if value == nil {
+		return nil
+	}
+
+	var info AccountInfo
+	err = gob.NewDecoder(bytes.NewBuffer(value)).Decode(&info)
+	if err != nil {
+		return nil
+	}
+	return &info
+}
+
+func putAccountInfo(conn *badger.Txn, account types.Account, info *AccountInfo) {
+	var buf bytes.Buffer
+	err := gob.NewEncoder(&buf).Encode(info)
+	if err != nil {
+		panic(err)
+	}
+
+	account_bytes, _ := address.AddressToPub(account)
+	setIndex(conn, prefixAccount, account_bytes, buf.Bytes())
+}
+
+// Returns the voting weight delegated to a representative
+func GetWeight(representative types.Account) uint128.Uint128 {
+	conn := getConn()
+	defer releaseConn(conn)
+	return getWeight(conn, representative)
+}
+
+func getWeight(conn *badger.Txn, representative types.Account) uint128.Uint128 {
+	rep_bytes, err := address.AddressToPub(representative)
+	if err != nil {
+		return uint128.FromInts(0, 0)
+	}
+
+	value := getIndex(conn, prefixWeight, rep_bytes)
+	if value == nil {
+		return uint128.FromInts(0, 0)
+	}
+	return uint128.FromBytes(value)
+}
+
+func addWeight(conn *badger.Txn, representative types.Account, amount uint128.Uint128) {
+	rep_bytes, _ := address.AddressToPub(representative)
+	weight := getWeight(conn, representative).Add(amount)
+	setIndex(conn, prefixWeight, rep_bytes, weight.GetBytes())
+}
+
+func subWeight(conn *badger.Txn, representative types.Account, amount uint128.Uint128) {
+	rep_bytes, _ := address.AddressToPub(representative)
+	weight := getWeight(conn, representative).Sub(amount)
+	setIndex(conn, prefixWeight, rep_bytes, weight.GetBytes())
+}
+
+// Returns the block in the ledger which occupies the same root as the given
+// block, i.e. the block it would fork with. This may be the block itself.
+func FetchByRoot(block blocks.Block) blocks.Block {
+	conn := getConn()
+	defer releaseConn(conn)
+	return fetchByRoot(conn, block)
+}
+
+func fetchByRoot(conn *badger.Txn, block blocks.Block) blocks.Block {
+	if block.Type() == blocks.Open {
+		open := fetchOpen(conn, block.(*blocks.OpenBlock).Account)
+		if open == nil {
+			return nil
+		}
+		return open
+	}
+
+	return fetchSuccessor(conn, block.PreviousBlockHash())
+}
+
+func fetchSuccessor(conn *badger.Txn, hash types.BlockHash) blocks.Block {
+	value := getIndex(conn, prefixSuccessor, hash.ToBytes())
+	if value == nil {
+		return nil
+	}
+	return fetchBlock(conn, types.BlockHashFromBytes(value))
+}
+
+// Returns the open or receive block which pocketed a send
+func fetchReceiver(conn *badger.Txn, source types.BlockHash) blocks.Block {
+	value := getIndex(conn, prefixReceiver, source.ToBytes())
+	if value == nil {
+		return nil
+	}
+	return fetchBlock(conn, types.BlockHashFromBytes(value))
+}
+
+func sourceHash(block blocks.Block) types.BlockHash {
+	switch block.Type() {
+	case blocks.Open:
+		return block.(*blocks.OpenBlock).SourceHash
+	case blocks.Receive:
+		return block.(*blocks.ReceiveBlock).SourceHash
+	default:
+		return ""
+	}
+}
+
+// Returns the account whose chain the block belongs to, or an empty account
+// if the block's previous block isn't stored.
+func FetchBlockAccount(block blocks.Block) types.Account {
+	conn := getConn()
+	defer releaseConn(conn)
+	return blockAccount(conn, block)
+}
+
+func blockAccount(conn *badger.Txn, block blocks.Block) types.Account {
+	if block.Type() == blocks.Open {
+		return block.(*blocks.OpenBlock).Account
+	}
+
+	value := getIndex(conn, prefixOwner, block.PreviousBlockHash().ToBytes())
+	if value == nil {
+		return ""
+	}
+	return address.PubKeyToAddress(value)
+}
+
+func getRepresentative(conn *badger.Txn, block blocks.Block) types.Account {
+	for block != nil {
+		switch block.Type() {
+		case blocks.Open:
+			return block.(*blocks.OpenBlock).Representative
+		case blocks.Change:
+			return block.(*blocks.ChangeBlock).Representative
+		}
+		block = fetchBlock(conn, block.PreviousBlockHash())
+	}
+	return ""
+}
+
+// Update the account state, representative weights and indexes for a
+// block which has just been written.
+func applyBlock(conn *badger.Txn, block blocks.Block) {
+	hash := block.Hash()
+	account := blockAccount(conn, block)
+	account_bytes, _ := address.AddressToPub(account)
+
+	info := fetchAccountInfo(conn, account)
+	if info == nil {
+		info = &AccountInfo{Open: hash}
+	} else {
+		subWeight(conn, info.Representative, info.Balance)
+	}
+
+	info.Head = hash
+	info.Representative = getRepresentative(conn, block)
+	info.Balance = getBalance(conn, block)
+	info.BlockCount++
+
+	addWeight(conn, info.Representative, info.Balance)
+	putAccountInfo(conn, account, info)
+
+	setIndex(conn, prefixOwner, hash.ToBytes(), account_bytes)
+	setIndex(conn, prefixHeight, hash.ToBytes(), encodeHeight(info.BlockCount, time.Now()))
+	if block.Type() != blocks.Open {
+		setIndex(conn, prefixSuccessor, block.PreviousBlockHash().ToBytes(), hash.ToBytes())
+	}
+	if source := sourceHash(block); source != "" && hash != Conf.GenesisBlock.Hash() {
+		setIndex(conn, prefixReceiver, source.ToBytes(), hash.ToBytes())
+		deleteIndex(conn, prefixPending, append(account_bytes, source.ToBytes()...))
+	}
+	if block.Type() == blocks.Send {
+		addPending(conn, block.(*blocks.SendBlock), account_bytes)
+	}
+}
+
+// Records a send as pending until its destination receives it. The key is
+// the destination followed by the send's hash, so an account's pending
+// blocks can be found with a prefix scan.
+func addPending(conn *badger.Txn, send *blocks.SendBlock, source_bytes []byte) {
+	destination_bytes, _ := address.AddressToPub(send.Destination)
+	amount := getSendAmount(conn, send)
+	value := append(amount.GetBytes(), source_bytes...)
+	setIndex(conn, prefixPending, append(destination_bytes, send.Hash().ToBytes()...), value)
+}
+
+func encodeHeight(height uint64, timestamp time.Time) []byte {
+	value := make([]byte, 16)
+	binary.BigEndian.PutUint64(value, height)
+	binary.BigEndian.PutUint64(value[8:], uint64(timestamp.Unix()))
+	return value
+}
+
+// Replaces whichever block holds the winner's root with the winner and marks
+// it confirmed, in a single transaction so the ledger is left as it was if
+// any of it fails
+func ConfirmWinner(winner blocks.Block) error {
+	conn := getConn()
+	err := confirmWinner(conn, winner)
+	if err != nil {
+		discardConn(conn)
+		return err
+	}
+	releaseConn(conn)
+	return nil
+}
+
+func confirmWinner(conn *badger.Txn, winner blocks.Block) error {
+	existing := fetchByRoot(conn, winner)
+	if existing != nil && existing.Hash() != winner.Hash() {
+		logger.Infof("Rolling back fork %s in favour of %s", existing.Hash(), winner.Hash())
+		err := rollbackBlock(conn, existing.Hash())
+		if err != nil {
+			return err
+		}
+		existing = nil
+	}
+
+	if existing == nil {
+		err := storeBlock(conn, winner, nil)
+		if err != nil && err != ErrBlockExists {
+			return err
+		}
+	}
+	return confirmBlock(conn, winner.Hash())
+}
+
+// Marks a block as confirmed by the network
+func ConfirmBlock(hash types.BlockHash) error {
+	conn := getConn()
+	defer releaseConn(conn)
+	return confirmBlock(conn, hash)
+}
+
+func confirmBlock(conn *badger.Txn, hash types.BlockHash) error {
+	block := fetchBlock(conn, hash)
+	if block == nil {
+		return errors.New("Cannot confirm missing block")
+	}
+
+	if !block.IsConfirmed() {
+		block.SetConfirmed(true)
+		writeBlock(conn, block)
+	}
+	return nil
+}
+
+// Removes a block from the ledger, along with every block which depends on
+// it: its successors in the account chain and any receives of a rolled
+// back send.
+func RollbackBlock(hash types.BlockHash) error {
+	conn := getConn()
+	defer releaseConn(conn)
+	return rollbackBlock(conn, hash)
+}
+
+func rollbackBlock(conn *badger.Txn, hash types.BlockHash) error {
+	block := fetchBlock(conn, hash)
+	if block == nil {
+		return errors.New("Cannot roll back missing block")
+	}
+
+	if block.IsConfirmed() {
+		return errors.New("Cannot roll back confirmed block")
+	}
+
+	account := blockAccount(conn, block)
+	for {
+		info := fetchAccountInfo(conn, account)
+		if info == nil {
+			return errors.New("Cannot find account for block")
+		}
+
+		head := info.Head
+		err := rollbackHead(conn, account, info)
+		if err != nil {
+			return err
+		}
+		if head == hash {
+			return nil
+		}
+	}
+}
+
+func rollbackHead(conn *badger.Txn, account types.Account, info *AccountInfo) error {
+	head := fetchBlock(conn, info.Head)
+	if head.IsConfirmed() {
+		return errors.New("Cannot roll back confirmed block")
+	}
+
+	if head.Type() == blocks.Send {
+		receiver := fetchReceiver(conn, head.Hash())
+		if receiver != nil {
+			err := rollbackBlock(conn, receiver.Hash())
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	account_bytes, _ := address.AddressToPub(account)
+	subWeight(conn, info.Representative, info.Balance)
+
+	if head.Type() == blocks.Open {
+		deleteIndex(conn, prefixAccount, account_bytes)
+		err := conn.Delete(account_bytes)
+		if err != nil {
+			return err
+		}
+	} else {
+		previous := fetchBlock(conn, head.PreviousBlockHash())
+		info.Head = previous.Hash()
+		info.Representative = getRepresentative(conn, previous)
+		info.Balance = getBalance(conn, previous)
+		info.BlockCount--
+
+		addWeight(conn, info.Representative, info.Balance)
+		putAccountInfo(conn, account, info)
+		deleteIndex(conn, prefixSuccessor, previous.Hash().ToBytes())
+	}
+
+	if source := sourceHash(head); source != "" && head.Hash() != Conf.GenesisBlock.Hash() {
+		deleteIndex(conn, prefixReceiver, source.ToBytes())
+		// The send becomes pending again
+		addPending(conn, fetchBlock(conn, source).(*blocks.SendBlock), getIndex(conn, prefixOwner, source.ToBytes()))
+	}
+	if head.Type() == blocks.Send {
+		destination_bytes, _ := address.AddressToPub(head.(*blocks.SendBlock).Destination)
+		deleteIndex(conn, prefixPending, append(destination_bytes, head.Hash().ToBytes()...))
+	}
+	deleteIndex(conn, prefixOwner, head.Hash().ToBytes())
+	deleteIndex(conn, prefixHeight, head.Hash().ToBytes())
+
+	return conn.Delete(head.Hash().ToBytes())
+}
+
+// Calls fn for each index entry with the given prefix until it returns
+// false. Blocks share the key space, so only keys of the index's length
+// are passed on.
+func scanIndex(conn *badger.Txn, prefix []byte, length int, fn func(key []byte, value []byte) bool) {
+	it := conn.NewIterator(badger.DefaultIteratorOptions)
+	defer it.Close()
+
+	for it.Seek(prefix); it.ValidForPrefix(prefix); it.Next() {
+		item := it.Item()
+		value, err := item.Value()
+		if err != nil {
+			continue
+		}
+		if len(item.Key()) != length {
+			continue
+		}
+		key := append([]byte{}, item.Key()[len(prefix):]...)
+		if !fn(key, value) {
+			return
+		}
+	}
+}
+
+// A send which its destination hasn't received yet
+type PendingBlock struct {
+	Hash   types.BlockHash
+	Source types.Account
+	Amount uint128.Uint128
+}
+
+// Returns up to count of an account's pending sends, or every one if count
+// is 0
+func FetchPending(account types.Account, count int) []PendingBlock {
+	account_bytes, err := address.AddressToPub(account)
+	if err != nil {
+		return nil
+	}
+
+	conn := getConn()
+	defer releaseConn(conn)
+
+	var pending []PendingBlock
+	scanIndex(conn, indexKey(prefixPending, account_bytes), 65, func(key []byte, value []byte) bool {
+		pending = append(pending, PendingBlock{
+			Hash:   types.BlockHashFromBytes(key),
+			Source: address.PubKeyToAddress(value[16:]),
+			Amount: uint128.FromBytes(value[:16]),
+		})
+		return count == 0 || len(pending) < count
+	})
+	return pending
+}
+
+// Returns the amount a block sent or received
+func GetAmount(block blocks.Block) uint128.Uint128 {
+	conn := getConn()
+	defer releaseConn(conn)
+	return getAmount(conn, block)
+}
+
+func getAmount(conn *badger.Txn, block blocks.Block) uint128.Uint128 {
+	switch block.Type() {
+	case blocks.Send:
+		return getSendAmount(conn, block.(*blocks.SendBlock))
+	case blocks.Open, blocks.Receive:
+		source := fetchBlock(conn, sourceHash(block))
+		if source == nil || source.Type() != blocks.Send {
+			return getBalance(conn, block)
+		}
+		return getSendAmount(conn, source.(*blocks.SendBlock))
+	default:
+		return uint128.FromInts(0, 0)
+	}
+}
+
+// Details of a stored block which aren't part of the block itself
+type BlockInfo struct {
+	Account   types.Account
+	Height    uint64
+	Timestamp time.Time
+	Balance   uint128.Uint128
+	Amount    uint128.Uint128
+}
+
+func FetchBlockInfo(hash types.BlockHash) *BlockInfo {
+	conn := getConn()
+	defer releaseConn(conn)
+
+	block := fetchBlock(conn, hash)
+	if block == nil {
+		return nil
+	}
+
+	info := &BlockInfo{
+		Account: blockAccount(conn, block),
+		Balance: getBalance(conn, block),
+		Amount:  getAmount(conn, block),
+	}
+	if value := getIndex(conn, prefixHeight, hash.ToBytes()); value != nil {
+		info.Height = binary.BigEndian.Uint64(value)
+		info.Timestamp = time.Unix(int64(binary.BigEndian.Uint64(value[8:])), 0)
+	}
+	return info
+}
+
+// Returns every representative with voting weight delegated to it
+func Representatives() map[types.Account]uint128.Uint128 {
+	conn := getConn()
+	defer releaseConn(conn)
+
+	reps := make(map[types.Account]uint128.Uint128)
+	zero := uint128.FromInts(0, 0)
+	scanIndex(conn, []byte{prefixWeight}, 33, func(key []byte, value []byte) bool {
+		weight := uint128.FromBytes(value)
+		if !weight.Equal(zero) {
+			reps[address.PubKeyToAddress(key)] = weight
+		}
+		return true
+	})
+	return reps
+}
+
+// Returns the number of blocks in the ledger, and the number waiting for
+// their previous block to arrive
+func BlockCount() (count uint64, unchecked uint64) {
+	conn := getConn()
+	defer releaseConn(conn)
+
+	scanIndex(conn, []byte{prefixAccount}, 33, func(key []byte, value []byte) bool {
+		var info AccountInfo
+		err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&info)
+		if err == nil {
+			count += info.BlockCount
+		}
+		return true
+	})
+	return count, uint64(len(unconnectedBlockPool))
+}
+
+// Returns the state of every account in the ledger
+func Accounts() map[types.Account]AccountInfo {
+	conn := getConn()
+	defer releaseConn(conn)
+
+	accounts := make(map[types.Account]AccountInfo)
+	scanIndex(conn, []byte{prefixAccount}, 33, func(key []byte, value []byte) bool {
+		var info AccountInfo
+		err := gob.NewDecoder(bytes.NewBuffer(value)).Decode(&info)
+		if err == nil {
+			accounts[address.PubKeyToAddress(key)] = info
+		}
+		return true
+	})
+	return accounts
+}
@@ -29,16 +29,19 @@ import (
 	"bytes"
 	"encoding/gob"
 	"errors"
-	"log"
 	"sync"
+	"sync/atomic"
 
 	"github.com/dgraph-io/badger"
 	"github.com/svaishnavy/nano/address"
 	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/logging"
 	"github.com/svaishnavy/nano/types"
 	"github.com/svaishnavy/nano/uint128"
 )
 
+var logger = logging.New("store")
+
 type Config struct {
 	Path         string
 	GenesisBlock *blocks.OpenBlock
@@ -99,10 +102,29 @@ var TestConfigLive = Config{
 	blocks.LiveGenesisBlock,
 }
 
+var (
+	ErrBlockExists = errors.New("Block already exists")
+	ErrFork        = errors.New("Block forks with an existing block")
+	ErrGap         = errors.New("Cannot find parent block")
+
+	ErrInvalidSignature = errors.New("Invalid signature for block")
+)
+
+// A block from the unconnected pool, and the result of storing it once
+// its previous block arrived
+type Released struct {
+	Block blocks.Block
+	Err   error
+}
+
 // Blocks that we cannot store due to not having their parent
 // block stored
 var unconnectedBlockPool map[types.BlockHash]blocks.Block
 
+// The size of the pool, kept separately so it can be read without waiting
+// for the store
+var uncheckedCount int64
+
 var Conf *Config
 var globalConn *badger.DB
 var currentTxn *badger.Txn
@@ -116,43 +138,91 @@ func getConn() *badger.Txn {
 	}
 
 	if globalConn == nil {
-		opts := badger.DefaultOptions
-		opts.Dir = Conf.Path
-		opts.ValueDir = Conf.Path
-		conn, err := badger.Open(opts)
+		err := openDB()
 		if err != nil {
 			panic(err)
 		}
-		globalConn = conn
 	}
 
 	currentTxn = globalConn.NewTransaction(true)
 	return currentTxn
 }
 
+func openDB() error {
+	opts := badger.DefaultOptions
+	opts.Dir = Conf.Path
+	opts.ValueDir = Conf.Path
+	conn, err := badger.Open(opts)
+	if err != nil {
+		return err
+	}
+	globalConn = conn
+	return nil
+}
+
 func releaseConn(conn *badger.Txn) {
 	currentTxn.Commit(nil)
 	currentTxn = nil
 	connLock.Unlock()
 }
 
+// Releases the connection without committing, so nothing written in the
+// transaction reaches the database
+func discardConn(conn *badger.Txn) {
+	currentTxn.Discard()
+	currentTxn = nil
+	connLock.Unlock()
+}
+
 func Init(config Config) {
-	var err error
+	err := Open(config)
+	if err != nil {
+		panic(err)
+	}
+}
+
+// Opens the store, adding the genesis block if it's new. Unlike Init this
+// returns an error if the database can't be opened, e.g. because another
+// process has it locked.
+func Open(config Config) error {
 	unconnectedBlockPool = make(map[types.BlockHash]blocks.Block)
+	atomic.StoreInt64(&uncheckedCount, 0)
 
+	connLock.Lock()
 	if globalConn != nil {
 		globalConn.Close()
 		globalConn = nil
 	}
 	Conf = &config
+	err := openDB()
+	connLock.Unlock()
+	if err != nil {
+		return err
+	}
+
 	conn := getConn()
 	defer releaseConn(conn)
 
-	_, err = conn.Get(blocks.LiveGenesisBlockHash.ToBytes())
+	_, err = conn.Get(config.GenesisBlock.Hash().ToBytes())
 
 	if err != nil {
 		uncheckedStoreBlock(conn, config.GenesisBlock)
 	}
+	return nil
+}
+
+// Closes the database, so it doesn't need recovering when it's next opened.
+// It will be reopened if the store is used again.
+func Close() error {
+	connLock.Lock()
+	defer connLock.Unlock()
+
+	if globalConn == nil {
+		return nil
+	}
+	err := globalConn.Close()
+	globalConn = nil
+	return err
 }
 
 func FetchOpen(account types.Account) (b *blocks.OpenBlock) {
@@ -236,14 +306,30 @@ func getBalance(conn *badger.Txn, block blocks.Block) uint128.Uint128 {
 }
 
 // Validate and store a block
-// TODO: Validate signature and balance
+// TODO: Validate signature
 func StoreBlock(block blocks.Block) error {
 	conn := getConn()
 	defer releaseConn(conn)
-	return storeBlock(conn, block)
+	return storeBlock(conn, block, nil)
 }
 
-func storeBlock(conn *badger.Txn, block blocks.Block) error {
+// Stores a batch of blocks in a single transaction, returning the result
+// for each block in order. Later blocks may build on earlier ones. Blocks
+// released from the unconnected pool along the way are returned with
+// their own results.
+func StoreBlocks(batch []blocks.Block) ([]error, []Released) {
+	conn := getConn()
+	defer releaseConn(conn)
+
+	errs := make([]error, len(batch))
+	var released []Released
+	for i, block := range batch {
+		errs[i] = storeBlock(conn, block, &released)
+	}
+	return errs, released
+}
+
+func storeBlock(conn *badger.Txn, block blocks.Block, released *[]Released) error {
 	if !blocks.ValidateBlockWork(block) {
 		return errors.New("Invalid work for block")
 	}
@@ -252,12 +338,32 @@ func storeBlock(conn *badger.Txn, block blocks.Block) error {
 		return errors.New("Unknown block type")
 	}
 
+	if fetchBlock(conn, block.Hash()) != nil {
+		return ErrBlockExists
+	}
+
 	if fetchBlock(conn, block.PreviousBlockHash()) == nil {
 		if unconnectedBlockPool[block.PreviousBlockHash()] == nil {
 			unconnectedBlockPool[block.PreviousBlockHash()] = block
-			log.Printf("Added block to unconnected pool, now %d", len(unconnectedBlockPool))
+			atomic.AddInt64(&uncheckedCount, 1)
+			logger.Debugf("Added block to unconnected pool, now %d", len(unconnectedBlockPool))
 		}
-		return errors.New("Cannot find parent block")
+		return ErrGap
+	}
+
+	if fetchByRoot(conn, block) != nil {
+		return ErrFork
+	}
+
+	if block.Type() == blocks.Open || block.Type() == blocks.Receive {
+		if fetchReceiver(conn, sourceHash(block)) != nil {
+			return errors.New("Source block already received")
+		}
+	}
+
+	err := checkBalance(conn, block)
+	if err != nil {
+		return err
 	}
 
 	uncheckedStoreBlock(conn, block)
@@ -265,18 +371,67 @@ func storeBlock(conn *badger.Txn, block blocks.Block) error {
 
 	if dependentBlock != nil {
 		// We have an unconnected block dependent on this: Store it now that
-		// it's connected
+		// it's connected. Its signature couldn't be checked while its
+		// account was unknown, so it's checked here.
 		delete(unconnectedBlockPool, block.Hash())
-		storeBlock(conn, dependentBlock)
+		atomic.AddInt64(&uncheckedCount, -1)
+		i := -1
+		if released != nil {
+			// Reported ahead of any blocks its own storing releases
+			i = len(*released)
+			*released = append(*released, Released{Block: dependentBlock})
+		}
+		if blocks.VerifyBlockSignature(dependentBlock, blockAccount(conn, dependentBlock)) {
+			err = storeBlock(conn, dependentBlock, released)
+		} else {
+			logger.Warnf("Dropped unconnected block %s with an invalid signature", dependentBlock.Hash())
+			err = ErrInvalidSignature
+		}
+		if i >= 0 {
+			(*released)[i].Err = err
+		}
 	}
 
 	return nil
 }
 
+// Checks that a block only moves funds it's entitled to: opens and receives
+// must claim a send to their account, and sends can't add to the balance.
+// The block's previous block must already be stored.
+func checkBalance(conn *badger.Txn, block blocks.Block) error {
+	switch block.Type() {
+	case blocks.Open, blocks.Receive:
+		source := fetchBlock(conn, sourceHash(block))
+		if source == nil {
+			return errors.New("Source block not found")
+		}
+		send, ok := source.(*blocks.SendBlock)
+		if !ok {
+			return errors.New("Source block is not a send")
+		}
+		if send.Destination != blockAccount(conn, block) {
+			return errors.New("Send is not for this account")
+		}
+
+	case blocks.Send:
+		b := block.(*blocks.SendBlock)
+		if b.Balance.Compare(getBalance(conn, fetchBlock(conn, b.PreviousHash))) > 0 {
+			return errors.New("Send increases the balance")
+		}
+	}
+	return nil
+}
+
 // Store a block without checking whether it's valid
 // The block should be pre-checked to ensure it has a valid signature,
 // parent block, balance, etc.
 func uncheckedStoreBlock(conn *badger.Txn, block blocks.Block) {
+	writeBlock(conn, block)
+	applyBlock(conn, block)
+}
+
+// Serialise a block into the database, keyed on its hash
+func writeBlock(conn *badger.Txn, block blocks.Block) {
 	var buf bytes.Buffer
 	var meta byte
 	enc := gob.NewEncoder(&buf)
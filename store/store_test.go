@@ -27,9 +27,13 @@ package store
 
 import (
 	"os"
+	"strings"
 	"testing"
 
+	"github.com/svaishnavy/nano/address"
 	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
 )
 
 func TestInit(t *testing.T) {
@@ -59,3 +63,217 @@ func TestMissingBlock(t *testing.T) {
 	}
 	os.RemoveAll(TestConfig.Path)
 }
+
+func testSend(previous blocks.Block, balance uint128.Uint128) *blocks.SendBlock {
+	send := blocks.SendBlock{
+		PreviousHash: previous.Hash(),
+		Destination:  blocks.TestGenesisBlock.Account,
+		Balance:      balance,
+	}
+	send.Work = blocks.GenerateWork(previous)
+	return &send
+}
+
+func TestForkAndRollback(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	Init(TestConfig)
+	genesis := blocks.TestGenesisBlock
+	rep := genesis.Representative
+
+	send := testSend(genesis, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	fork := testSend(genesis, blocks.GenesisAmount.Sub(uint128.FromInts(0, 2)))
+
+	if err := StoreBlock(send); err != nil {
+		t.Errorf("Failed to store send: %s", err)
+	}
+	if StoreBlock(send) != ErrBlockExists {
+		t.Errorf("Expected error storing block twice")
+	}
+	if GetWeight(rep) != send.Balance {
+		t.Errorf("Representative weight not updated after send")
+	}
+	if StoreBlock(fork) != ErrFork {
+		t.Errorf("Expected fork to be rejected")
+	}
+	if FetchByRoot(fork).Hash() != send.Hash() {
+		t.Errorf("Fetched wrong block by root")
+	}
+
+	if err := RollbackBlock(send.Hash()); err != nil {
+		t.Errorf("Failed to roll back send: %s", err)
+	}
+	if FetchBlock(send.Hash()) != nil {
+		t.Errorf("Rolled back block still in store")
+	}
+	if GetWeight(rep) != blocks.GenesisAmount {
+		t.Errorf("Representative weight not restored after rollback")
+	}
+	if FetchAccountInfo(genesis.Account).Head != genesis.Hash() {
+		t.Errorf("Account head not restored after rollback")
+	}
+
+	if err := StoreBlock(fork); err != nil {
+		t.Errorf("Failed to store fork after rollback: %s", err)
+	}
+	if err := ConfirmBlock(fork.Hash()); err != nil {
+		t.Errorf("Failed to confirm block: %s", err)
+	}
+	if !FetchBlock(fork.Hash()).IsConfirmed() {
+		t.Errorf("Block not marked confirmed")
+	}
+	if RollbackBlock(fork.Hash()) == nil {
+		t.Errorf("Rolled back a confirmed block")
+	}
+	os.RemoveAll(TestConfig.Path)
+}
+
+func TestInvalidSources(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	Init(TestConfig)
+	defer os.RemoveAll(TestConfig.Path)
+	genesis := blocks.TestGenesisBlock
+
+	pub, _ := address.GenerateKey()
+	destination := address.PubKeyToAddress(pub)
+	send := testSend(genesis, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	send.Destination = destination
+	if err := StoreBlock(send); err != nil {
+		t.Fatal(err)
+	}
+
+	receive := func(source types.BlockHash) *blocks.ReceiveBlock {
+		b := &blocks.ReceiveBlock{PreviousHash: send.Hash(), SourceHash: source}
+		b.Work = blocks.GenerateWork(send)
+		return b
+	}
+	if StoreBlock(receive(types.BlockHash(strings.Repeat("1", 64)))) == nil {
+		t.Errorf("Received an unknown source")
+	}
+	if StoreBlock(receive(genesis.Hash())) == nil {
+		t.Errorf("Received a source which isn't a send")
+	}
+	if StoreBlock(receive(send.Hash())) == nil {
+		t.Errorf("Received a send to another account")
+	}
+
+	// Nor can another account open with it
+	otherPub, _ := address.GenerateKey()
+	open := &blocks.OpenBlock{SourceHash: send.Hash(), Representative: genesis.Account, Account: address.PubKeyToAddress(otherPub)}
+	open.Work = blocks.GenerateWorkForHash(open.RootHash())
+	if StoreBlock(open) == nil {
+		t.Errorf("Opened with a send to another account")
+	}
+
+	if StoreBlock(testSend(send, send.Balance.Add(uint128.FromInts(0, 1)))) == nil {
+		t.Errorf("Stored a send which increases the balance")
+	}
+	if FetchAccountInfo(genesis.Account).Balance != send.Balance {
+		t.Errorf("Rejected blocks changed the balance")
+	}
+}
+
+func TestConfirmWinner(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	Init(TestConfig)
+	defer os.RemoveAll(TestConfig.Path)
+	genesis := blocks.TestGenesisBlock
+
+	send := testSend(genesis, blocks.GenesisAmount.Sub(uint128.FromInts(0, 1)))
+	fork := testSend(genesis, blocks.GenesisAmount.Sub(uint128.FromInts(0, 2)))
+	StoreBlock(send)
+
+	// Nothing is rolled back if the winner can't be stored
+	fork.Work = "0000000000000000"
+	if ConfirmWinner(fork) == nil {
+		t.Errorf("Confirmed a block with invalid work")
+	}
+	if FetchBlock(send.Hash()) == nil || FetchAccountInfo(genesis.Account).Head != send.Hash() {
+		t.Errorf("Failed confirmation changed the ledger")
+	}
+
+	fork.Work = blocks.GenerateWork(genesis)
+	if err := ConfirmWinner(fork); err != nil {
+		t.Fatalf("Failed to confirm winner: %s", err)
+	}
+	if FetchBlock(send.Hash()) != nil || !FetchBlock(fork.Hash()).IsConfirmed() {
+		t.Errorf("Winner didn't replace the fork")
+	}
+}
+
+func TestClose(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	Init(TestConfig)
+
+	err := Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if Close() != nil {
+		t.Errorf("Closing a closed store failed")
+	}
+
+	// The store reopens when it's used again
+	if FetchBlock(TestConfig.GenesisBlock.Hash()) == nil {
+		t.Errorf("Genesis block missing after reopening the store")
+	}
+	os.RemoveAll(TestConfig.Path)
+}
+
+func TestPending(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	Init(TestConfig)
+	genesis := blocks.TestGenesisBlock
+
+	pub, _ := address.GenerateKey()
+	destination := address.PubKeyToAddress(pub)
+	send := testSend(genesis, blocks.GenesisAmount.Sub(uint128.FromInts(0, 10)))
+	send.Destination = destination
+	if err := StoreBlock(send); err != nil {
+		t.Fatalf("Failed to store send: %s", err)
+	}
+
+	pending := FetchPending(destination, 0)
+	if len(pending) != 1 || pending[0].Hash != send.Hash() || pending[0].Source != genesis.Account || pending[0].Amount != uint128.FromInts(0, 10) {
+		t.Fatalf("Send not pending: %+v", pending)
+	}
+
+	open := &blocks.OpenBlock{SourceHash: send.Hash(), Representative: destination, Account: destination}
+	open.Work = blocks.GenerateWorkForHash(open.RootHash())
+	if err := StoreBlock(open); err != nil {
+		t.Fatalf("Failed to store open: %s", err)
+	}
+	if len(FetchPending(destination, 0)) != 0 {
+		t.Errorf("Received send still pending")
+	}
+	if GetAmount(open) != uint128.FromInts(0, 10) {
+		t.Errorf("Wrong amount for open block %s", GetAmount(open))
+	}
+
+	info := FetchBlockInfo(send.Hash())
+	if info == nil || info.Account != genesis.Account || info.Height != 2 || info.Timestamp.IsZero() {
+		t.Errorf("Wrong block info %+v", info)
+	}
+	if count, _ := BlockCount(); count != 3 {
+		t.Errorf("Wrong block count %d", count)
+	}
+	if reps := Representatives(); len(reps) != 2 || reps[destination] != uint128.FromInts(0, 10) {
+		t.Errorf("Wrong representatives %v", reps)
+	}
+	if accounts := Accounts(); len(accounts) != 2 || accounts[destination].Head != open.Hash() {
+		t.Errorf("Wrong accounts %v", accounts)
+	}
+
+	if err := RollbackBlock(open.Hash()); err != nil {
+		t.Fatalf("Failed to roll back open: %s", err)
+	}
+	if len(FetchPending(destination, 0)) != 1 {
+		t.Errorf("Send not pending after rolling back its receive")
+	}
+	if err := RollbackBlock(send.Hash()); err != nil {
+		t.Fatalf("Failed to roll back send: %s", err)
+	}
+	if len(FetchPending(destination, 0)) != 0 {
+		t.Errorf("Rolled back send still pending")
+	}
+	os.RemoveAll(TestConfig.Path)
+}
@@ -17,6 +17,7 @@ package uint128
 import (
 	"encoding/binary"
 	"encoding/hex"
+	"math/big"
 
 	"github.com/pkg/errors"
 )
@@ -39,6 +40,11 @@ func (u Uint128) String() string {
 	return hex.EncodeToString(u.GetBytes())
 }
 
+// Decimal returns a base 10 string representation, as used for raw amounts.
+func (u Uint128) Decimal() string {
+	return new(big.Int).SetBytes(u.GetBytes()).String()
+}
+
 // Equal returns whether or not the Uint128 are equivalent.
 func (u Uint128) Equal(o Uint128) bool {
 	return u.Hi == o.Hi && u.Lo == o.Lo
@@ -104,6 +110,19 @@ func FromString(s string) (Uint128, error) {
 	return FromBytes(bytes), nil
 }
 
+// FromDecimal parses a base 10 string as a 128-bit unsigned integer.
+func FromDecimal(s string) (Uint128, error) {
+	n, ok := new(big.Int).SetString(s, 10)
+	if !ok || n.Sign() < 0 || n.BitLen() > 128 {
+		return Uint128{}, errors.Errorf("could not decode %s as a 128-bit decimal", s)
+	}
+
+	bytes := make([]byte, 16)
+	b := n.Bytes()
+	copy(bytes[16-len(b):], b)
+	return FromBytes(bytes), nil
+}
+
 // FromInts takes in two unsigned 64-bit integers and constructs a Uint128.
 func FromInts(hi uint64, lo uint64) Uint128 {
 	return Uint128{hi, lo}
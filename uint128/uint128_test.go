@@ -60,6 +60,34 @@ func TestStringInvalidHex(t *testing.T) {
 	}
 }
 
+func TestDecimal(t *testing.T) {
+	testData := []struct {
+		num      Uint128
+		expected string
+	}{
+		{Uint128{0, 0}, "0"},
+		{Uint128{0, 1000}, "1000"},
+		{Uint128{1, 0}, "18446744073709551616"},
+		{Uint128{18446744073709551615, 18446744073709551615}, "340282366920938463463374607431768211455"},
+	}
+
+	for _, test := range testData {
+		if test.num.Decimal() != test.expected {
+			t.Errorf("expected %v to be %s but got %s", test.num, test.expected, test.num.Decimal())
+		}
+		res, err := FromDecimal(test.expected)
+		if err != nil || res != test.num {
+			t.Errorf("expected %s to parse as %v but got %v, %v", test.expected, test.num, res, err)
+		}
+	}
+
+	for _, invalid := range []string{"", "-1", "1.5", "abc", "340282366920938463463374607431768211456"} {
+		if _, err := FromDecimal(invalid); err == nil {
+			t.Errorf("expected %s to be invalid", invalid)
+		}
+	}
+}
+
 func TestSub(t *testing.T) {
 	testData := []struct {
 		num      Uint128
@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package wallet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/types"
+)
+
+type cachedWork struct {
+	Root types.BlockHash `json:"root"`
+	Work types.Work      `json:"work"`
+}
+
+// Precomputed work for each account's next block. Work is only valid for
+// the root it was generated for, so an entry is dropped as soon as the
+// account's frontier moves on.
+type WorkCache struct {
+	// File the cache is saved to after every change, or empty to only
+	// keep it in memory
+	Path string
+
+	mutex   sync.Mutex
+	entries map[types.Account]cachedWork
+}
+
+// The cache wallets use when they don't have their own
+var DefaultCache = NewWorkCache("")
+
+func NewWorkCache(path string) *WorkCache {
+	return &WorkCache{Path: path, entries: make(map[types.Account]cachedWork)}
+}
+
+// Loads a cache saved at path, or returns an empty one if there isn't one
+func LoadWorkCache(path string) (*WorkCache, error) {
+	c := NewWorkCache(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &c.entries)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid work cache")
+	}
+	return c, nil
+}
+
+// Returns cached work for an account's next block, if it was generated for
+// root and meets the threshold that block needs
+func (c *WorkCache) Get(account types.Account, root types.BlockHash, threshold blocks.Difficulty) (types.Work, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	entry, ok := c.entries[account]
+	if !ok {
+		return "", false
+	}
+	if entry.Root != root || blocks.WorkDifficulty(root, entry.Work) < threshold {
+		delete(c.entries, account)
+		c.save()
+		return "", false
+	}
+	return entry.Work, true
+}
+
+func (c *WorkCache) Put(account types.Account, root types.BlockHash, work types.Work) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[account] = cachedWork{Root: root, Work: work}
+	c.save()
+}
+
+// Drops an account's work, for when its frontier has changed
+func (c *WorkCache) Invalidate(account types.Account) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if _, ok := c.entries[account]; ok {
+		delete(c.entries, account)
+		c.save()
+	}
+}
+
+// Writes the cache to its file. The cache only saves time, so failures
+// are logged rather than returned.
+func (c *WorkCache) save() {
+	if c.Path == "" {
+		return
+	}
+	data, err := json.MarshalIndent(c.entries, "", "    ")
+	if err == nil {
+		err = os.MkdirAll(filepath.Dir(c.Path), 0700)
+	}
+	if err == nil {
+		tmp := c.Path + ".tmp"
+		err = ioutil.WriteFile(tmp, data, 0600)
+		if err == nil {
+			err = os.Rename(tmp, c.Path)
+		}
+	}
+	if err != nil {
+		logger.Warnf("Failed to save work cache: %s", err)
+	}
+}
@@ -0,0 +1,493 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package wallet
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang/crypto/argon2"
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/address"
+)
+
+const keystoreVersion = 1
+
+var ErrLocked = errors.New("Wallet is locked")
+var ErrWrongPassword = errors.New("Wrong wallet password")
+
+// Argon2id settings used to turn a password into the key encrypting a
+// keystore. They are saved with each file, so they can be raised without
+// breaking existing keystores.
+type kdfParams struct {
+	Name    string `json:"name"`
+	Salt    string `json:"salt"`
+	Time    uint32 `json:"time"`
+	Memory  uint32 `json:"memory"`
+	Threads uint8  `json:"threads"`
+}
+
+// Settings for new keystores, following the argon2 draft's recommendation
+// for interactive logins
+var defaultKdf = kdfParams{Name: "argon2id", Time: 1, Memory: 64 * 1024, Threads: 4}
+
+// The file a keystore is saved as. Only the KDF settings are in the clear;
+// the seeds and keys are sealed with AES-256-GCM.
+type keystoreFile struct {
+	Version    int       `json:"version"`
+	Kdf        kdfParams `json:"kdf"`
+	Nonce      string    `json:"nonce"`
+	Ciphertext string    `json:"ciphertext"`
+}
+
+// A BIP39 mnemonic and the passphrase it's used with
+type Mnemonic struct {
+	Words      string `json:"words"`
+	Passphrase string `json:"passphrase"`
+}
+
+type keystoreContents struct {
+	Seeds     []string   `json:"seeds"`
+	Mnemonics []Mnemonic `json:"mnemonics"`
+	Keys      []string   `json:"keys"`
+}
+
+// Seeds and private keys kept in a password encrypted file. The keystore
+// starts locked, and must be unlocked with its password before the
+// secrets can be read or changed.
+type Keystore struct {
+	Path string
+
+	mutex    sync.Mutex
+	file     keystoreFile
+	key      []byte
+	contents *keystoreContents
+	timer    *time.Timer
+}
+
+func randomBytes(n int) ([]byte, error) {
+	b := make([]byte, n)
+	_, err := rand.Read(b)
+	return b, err
+}
+
+func (p kdfParams) deriveKey(password string) ([]byte, error) {
+	salt, err := hex.DecodeString(p.Salt)
+	if err != nil || p.Name != "argon2id" || p.Time == 0 || p.Threads == 0 {
+		return nil, errors.New("Invalid keystore key derivation settings")
+	}
+	return argon2.IDKey([]byte(password), salt, p.Time, p.Memory, p.Threads, 32), nil
+}
+
+func newKdf() (kdfParams, error) {
+	salt, err := randomBytes(16)
+	if err != nil {
+		return kdfParams{}, err
+	}
+	p := defaultKdf
+	p.Salt = hex.EncodeToString(salt)
+	return p, nil
+}
+
+// The KDF settings are authenticated along with the secrets, so they
+// can't be weakened without the password
+func (f *keystoreFile) additionalData() []byte {
+	data, _ := json.Marshal(struct {
+		Version int       `json:"version"`
+		Kdf     kdfParams `json:"kdf"`
+	}{f.Version, f.Kdf})
+	return data
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func (f *keystoreFile) seal(key []byte, contents *keystoreContents) error {
+	plaintext, err := json.Marshal(contents)
+	if err != nil {
+		return err
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	nonce, err := randomBytes(gcm.NonceSize())
+	if err != nil {
+		return err
+	}
+	f.Nonce = hex.EncodeToString(nonce)
+	f.Ciphertext = hex.EncodeToString(gcm.Seal(nil, nonce, plaintext, f.additionalData()))
+	return nil
+}
+
+func (f *keystoreFile) open(key []byte) (*keystoreContents, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hex.DecodeString(f.Nonce)
+	if err != nil || len(nonce) != gcm.NonceSize() {
+		return nil, errors.New("Invalid keystore nonce")
+	}
+	ciphertext, err := hex.DecodeString(f.Ciphertext)
+	if err != nil {
+		return nil, errors.New("Invalid keystore ciphertext")
+	}
+
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, f.additionalData())
+	if err != nil {
+		return nil, ErrWrongPassword
+	}
+	var contents keystoreContents
+	err = json.Unmarshal(plaintext, &contents)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid keystore contents")
+	}
+	return &contents, nil
+}
+
+func parseKeystore(data []byte) (keystoreFile, error) {
+	var f keystoreFile
+	err := json.Unmarshal(data, &f)
+	if err != nil {
+		return f, errors.Wrap(err, "Invalid keystore")
+	}
+	if f.Version != keystoreVersion {
+		return f, errors.Errorf("Unsupported keystore version %d", f.Version)
+	}
+	return f, nil
+}
+
+// Creates an empty keystore encrypted with password. The keystore is left
+// unlocked.
+func CreateKeystore(path string, password string) (*Keystore, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, errors.Errorf("Keystore %s already exists", path)
+	}
+
+	kdf, err := newKdf()
+	if err != nil {
+		return nil, err
+	}
+	k := &Keystore{
+		Path:     path,
+		file:     keystoreFile{Version: keystoreVersion, Kdf: kdf},
+		contents: &keystoreContents{},
+	}
+	k.key, err = kdf.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	err = k.file.seal(k.key, k.contents)
+	if err != nil {
+		return nil, err
+	}
+	return k, k.save()
+}
+
+// Opens a keystore, which starts locked
+func OpenKeystore(path string) (*Keystore, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseKeystore(data)
+	if err != nil {
+		return nil, err
+	}
+	return &Keystore{Path: path, file: f}, nil
+}
+
+// Copies an exported keystore to path, checking it can be unlocked with
+// password first. The imported keystore is left locked.
+func ImportKeystore(path string, r io.Reader, password string) (*Keystore, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	f, err := parseKeystore(data)
+	if err != nil {
+		return nil, err
+	}
+	key, err := f.Kdf.deriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.open(key); err != nil {
+		return nil, err
+	}
+
+	k := &Keystore{Path: path, file: f}
+	return k, k.save()
+}
+
+func (k *Keystore) save() error {
+	data, err := json.MarshalIndent(k.file, "", "    ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(k.Path), 0700)
+	if err != nil {
+		return err
+	}
+
+	tmp := k.Path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, k.Path)
+}
+
+// The keystore's file as last saved. Keys are derived outside the lock, as
+// that's deliberately slow.
+func (k *Keystore) encrypted() keystoreFile {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.file
+}
+
+// Decrypts the keystore. It locks itself again after timeout, or stays
+// unlocked until Lock is called if timeout is zero.
+func (k *Keystore) Unlock(password string, timeout time.Duration) error {
+	f := k.encrypted()
+	key, err := f.Kdf.deriveKey(password)
+	if err != nil {
+		return err
+	}
+	contents, err := f.open(key)
+	if err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.key = key
+	k.contents = contents
+	if k.timer != nil {
+		k.timer.Stop()
+		k.timer = nil
+	}
+	if timeout > 0 {
+		k.timer = time.AfterFunc(timeout, k.Lock)
+	}
+	return nil
+}
+
+// Forgets the decrypted secrets and key
+func (k *Keystore) Lock() {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+
+	for i := range k.key {
+		k.key[i] = 0
+	}
+	k.key = nil
+	k.contents = nil
+	if k.timer != nil {
+		k.timer.Stop()
+		k.timer = nil
+	}
+}
+
+func (k *Keystore) Locked() bool {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	return k.contents == nil
+}
+
+// Re-encrypts the keystore with a new password, under a new salt
+func (k *Keystore) ChangePassword(old string, password string) error {
+	current := k.encrypted()
+	key, err := current.Kdf.deriveKey(old)
+	if err != nil {
+		return err
+	}
+	contents, err := current.open(key)
+	if err != nil {
+		return err
+	}
+
+	kdf, err := newKdf()
+	if err != nil {
+		return err
+	}
+	key, err = kdf.deriveKey(password)
+	if err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	f := keystoreFile{Version: keystoreVersion, Kdf: kdf}
+	err = f.seal(key, contents)
+	if err != nil {
+		return err
+	}
+	k.file = f
+	if k.contents != nil {
+		k.key = key
+	}
+	return k.save()
+}
+
+// Writes the encrypted keystore, which can only be imported with its
+// password
+func (k *Keystore) Export(w io.Writer) error {
+	data, err := json.MarshalIndent(k.encrypted(), "", "    ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Applies a change to the unlocked contents and saves them
+func (k *Keystore) update(fn func(c *keystoreContents) error) error {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.contents == nil {
+		return ErrLocked
+	}
+
+	updated := *k.contents
+	updated.Seeds = append([]string(nil), k.contents.Seeds...)
+	updated.Mnemonics = append([]Mnemonic(nil), k.contents.Mnemonics...)
+	updated.Keys = append([]string(nil), k.contents.Keys...)
+	err := fn(&updated)
+	if err != nil {
+		return err
+	}
+
+	f := k.file
+	err = f.seal(k.key, &updated)
+	if err != nil {
+		return err
+	}
+	k.file = f
+	k.contents = &updated
+	return k.save()
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func validSecret(s string) bool {
+	bytes, err := hex.DecodeString(s)
+	return err == nil && len(bytes) == 32
+}
+
+func (k *Keystore) AddSeed(seed string) error {
+	seed = strings.ToUpper(seed)
+	if !validSecret(seed) {
+		return errors.New("Invalid seed")
+	}
+	return k.update(func(c *keystoreContents) error {
+		if contains(c.Seeds, seed) {
+			return errors.New("Seed is already in the wallet")
+		}
+		c.Seeds = append(c.Seeds, seed)
+		return nil
+	})
+}
+
+func (k *Keystore) AddMnemonic(words string, passphrase string) error {
+	words = strings.Join(strings.Fields(words), " ")
+	if !address.ValidateMnemonic(words) {
+		return address.ErrInvalidMnemonic
+	}
+	return k.update(func(c *keystoreContents) error {
+		for _, m := range c.Mnemonics {
+			if m.Words == words && m.Passphrase == passphrase {
+				return errors.New("Mnemonic is already in the wallet")
+			}
+		}
+		c.Mnemonics = append(c.Mnemonics, Mnemonic{Words: words, Passphrase: passphrase})
+		return nil
+	})
+}
+
+func (k *Keystore) AddKey(private string) error {
+	private = strings.ToUpper(private)
+	if !validSecret(private) {
+		return errors.New("Invalid private key")
+	}
+	return k.update(func(c *keystoreContents) error {
+		if contains(c.Keys, private) {
+			return errors.New("Key is already in the wallet")
+		}
+		c.Keys = append(c.Keys, private)
+		return nil
+	})
+}
+
+func (k *Keystore) Seeds() ([]string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.contents == nil {
+		return nil, ErrLocked
+	}
+	return append([]string(nil), k.contents.Seeds...), nil
+}
+
+func (k *Keystore) Mnemonics() ([]Mnemonic, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.contents == nil {
+		return nil, ErrLocked
+	}
+	return append([]Mnemonic(nil), k.contents.Mnemonics...), nil
+}
+
+func (k *Keystore) Keys() ([]string, error) {
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if k.contents == nil {
+		return nil, ErrLocked
+	}
+	return append([]string(nil), k.contents.Keys...), nil
+}
@@ -0,0 +1,200 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package wallet
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+// How often the receiver looks through the ledger for pending sends it
+// hasn't been told about, e.g. ones stored while its queue was full
+var ReceiveRescanInterval = time.Minute
+
+// Accounts waiting to have their pending sends received
+const receiveQueueSize = 1024
+
+// Receives pending sends to wallet accounts automatically. Sends already
+// in the ledger are received when an account is added, and new ones as
+// the node stores them.
+type Receiver struct {
+	Node *node.Node
+	// Sends of less than this are left pending, so dust doesn't cost the
+	// wallet work
+	Minimum uint128.Uint128
+	// Representative for accounts the receiver opens, the account itself
+	// if empty
+	Representative types.Account
+
+	mutex     sync.Mutex
+	accounts  map[types.Account]*Wallet
+	queued    map[types.Account]bool
+	queue     chan types.Account
+	ctx       context.Context
+	cancel    context.CancelFunc
+	done      chan struct{}
+	unobserve func()
+}
+
+func NewReceiver(n *node.Node, minimum uint128.Uint128) *Receiver {
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &Receiver{
+		Node:     n,
+		Minimum:  minimum,
+		accounts: make(map[types.Account]*Wallet),
+		queued:   make(map[types.Account]bool),
+		queue:    make(chan types.Account, receiveQueueSize),
+		ctx:      ctx,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	r.unobserve = node.ObserveNewBlocks(r.blockStored)
+	return r
+}
+
+// Starts receiving for an account, beginning with the sends already
+// pending in the ledger
+func (r *Receiver) Add(w *Wallet) {
+	r.mutex.Lock()
+	r.accounts[w.Address()] = w
+	r.mutex.Unlock()
+	r.enqueue(w.Address())
+}
+
+func (r *Receiver) Start() {
+	go r.run()
+}
+
+// Stops receiving, waiting for a block being created to be published
+func (r *Receiver) Stop() {
+	r.unobserve()
+	r.cancel()
+	<-r.done
+}
+
+// Queues an account to have its pending sends received. Never blocks, as
+// it's called while the node is processing blocks; anything dropped is
+// picked up by the next rescan.
+func (r *Receiver) enqueue(account types.Account) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.ctx.Err() != nil || r.accounts[account] == nil || r.queued[account] {
+		return
+	}
+
+	select {
+	case r.queue <- account:
+		r.queued[account] = true
+	default:
+		logger.Warnf("Receive queue is full, %s will be received on the next rescan", account)
+	}
+}
+
+func (r *Receiver) blockStored(block blocks.Block) {
+	if send, ok := block.(*blocks.SendBlock); ok {
+		r.enqueue(send.Destination)
+	}
+}
+
+func (r *Receiver) run() {
+	defer close(r.done)
+	ticker := time.NewTicker(ReceiveRescanInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case account := <-r.queue:
+			r.mutex.Lock()
+			delete(r.queued, account)
+			w := r.accounts[account]
+			r.mutex.Unlock()
+			r.receiveAll(w)
+		case <-ticker.C:
+			r.mutex.Lock()
+			var accounts []types.Account
+			for account := range r.accounts {
+				accounts = append(accounts, account)
+			}
+			r.mutex.Unlock()
+			for _, account := range accounts {
+				r.enqueue(account)
+			}
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// Receives every pending send to an account which is at least the
+// minimum amount
+func (r *Receiver) receiveAll(w *Wallet) {
+	for _, pending := range store.FetchPending(w.Address(), 0) {
+		if pending.Amount.Compare(r.Minimum) < 0 {
+			continue
+		}
+		err := r.receive(w, pending.Hash)
+		if err != nil {
+			if r.ctx.Err() == nil {
+				logger.Warnf("Failed to receive %s to %s: %s", pending.Hash, w.Address(), err)
+			}
+			return
+		}
+	}
+}
+
+func (r *Receiver) receive(w *Wallet, source types.BlockHash) error {
+	block, err := w.ReceivePending(r.ctx, source, r.Representative)
+	if err != nil {
+		return err
+	}
+
+	// Blocks the node rejects outright are reported straight away
+	result := r.Node.Process(block)
+	select {
+	case res := <-result:
+		if res.Err != nil {
+			return res.Err
+		}
+		return nil
+	default:
+	}
+
+	logger.Infof("Received %s to %s in %s", source, w.Address(), block.Hash())
+	go func() {
+		res := <-result
+		if res.Err != nil {
+			logger.Warnf("Receive block %s failed: %s", res.Hash, res.Err)
+		}
+	}()
+	return nil
+}
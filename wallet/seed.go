@@ -0,0 +1,233 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package wallet
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/crypto/ed25519"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+// How many unused accounts in a row Scan looks past before deciding there
+// are no more, the same as most light wallets
+const DefaultGapLimit = 20
+
+// A deterministic wallet whose accounts are all derived from one seed by
+// index, so backing up the seed backs up every account. The seed is
+// either a reference wallet seed or a BIP39 mnemonic.
+type SeedWallet struct {
+	// Representative for accounts opened by Receive, the account itself if
+	// empty
+	Representative types.Account
+
+	seed     string
+	derive   func(index uint32) (ed25519.PublicKey, ed25519.PrivateKey)
+	accounts map[uint32]*Wallet
+	next     uint32
+}
+
+// Returns a new random seed as 64 hex digits
+func GenerateSeed() (string, error) {
+	seed := make([]byte, 32)
+	_, err := rand.Read(seed)
+	if err != nil {
+		return "", err
+	}
+	return strings.ToUpper(hex.EncodeToString(seed)), nil
+}
+
+func NewSeedWallet(seed string) (*SeedWallet, error) {
+	if bytes, err := hex.DecodeString(seed); err != nil || len(bytes) != 32 {
+		return nil, errors.New("Invalid seed")
+	}
+	seed = strings.ToUpper(seed)
+	return &SeedWallet{
+		seed: seed,
+		derive: func(index uint32) (ed25519.PublicKey, ed25519.PrivateKey) {
+			return address.KeypairFromSeed(seed, index)
+		},
+		accounts: make(map[uint32]*Wallet),
+	}, nil
+}
+
+// Creates a wallet from a BIP39 mnemonic and passphrase, deriving accounts
+// along 44'/165'/index' as hardware wallets do
+func NewMnemonicWallet(mnemonic string, passphrase string) (*SeedWallet, error) {
+	seed, err := address.MnemonicToSeed(mnemonic, passphrase)
+	if err != nil {
+		return nil, err
+	}
+	return &SeedWallet{
+		seed: strings.ToUpper(hex.EncodeToString(seed)),
+		derive: func(index uint32) (ed25519.PublicKey, ed25519.PrivateKey) {
+			return address.KeypairFromBip39Seed(seed, index)
+		},
+		accounts: make(map[uint32]*Wallet),
+	}, nil
+}
+
+// The seed accounts are derived from. For mnemonic wallets this is the 64
+// byte BIP39 seed.
+func (s *SeedWallet) Seed() string {
+	return s.seed
+}
+
+// The lowest index which hasn't been handed out by NewAccount or found in
+// use by Scan
+func (s *SeedWallet) NextIndex() uint32 {
+	return s.next
+}
+
+// Returns the account at an index, with its head at the account's
+// frontier in the ledger
+func (s *SeedWallet) Account(index uint32) *Wallet {
+	if w := s.accounts[index]; w != nil {
+		return w
+	}
+
+	w := fromKeypair(s.derive(index))
+	s.accounts[index] = &w
+	return &w
+}
+
+// Adds the account at the next unused index
+func (s *SeedWallet) NewAccount() *Wallet {
+	w := s.Account(s.next)
+	s.next++
+	return w
+}
+
+// The wallet's accounts in index order
+func (s *SeedWallet) Accounts() []*Wallet {
+	indexes := make([]int, 0, len(s.accounts))
+	for index := range s.accounts {
+		if index < s.next {
+			indexes = append(indexes, int(index))
+		}
+	}
+	sort.Ints(indexes)
+
+	accounts := make([]*Wallet, len(indexes))
+	for i, index := range indexes {
+		accounts[i] = s.accounts[uint32(index)]
+	}
+	return accounts
+}
+
+// Finds one of the wallet's accounts by address
+func (s *SeedWallet) Find(account types.Account) (*Wallet, error) {
+	for index, w := range s.accounts {
+		if index < s.next && w.Address() == account {
+			return w, nil
+		}
+	}
+	return nil, errors.Errorf("Account %s is not in the wallet", account)
+}
+
+func used(account types.Account) bool {
+	return store.FetchAccountInfo(account) != nil || len(store.FetchPending(account, 1)) > 0
+}
+
+// Looks through the ledger for accounts which have been opened or have
+// sends waiting for them, stopping after gap unused indexes in a row.
+// Every account up to the last used one is added to the wallet. Returns
+// the number of used accounts found.
+func (s *SeedWallet) Scan(gap uint32) int {
+	found := 0
+	unused := uint32(0)
+	for index := uint32(0); unused < gap; index++ {
+		w := s.Account(index)
+		if !used(w.Address()) {
+			unused++
+			continue
+		}
+
+		found++
+		unused = 0
+		if index >= s.next {
+			s.next = index + 1
+		}
+	}
+
+	// Drop the unused accounts derived past the end
+	for index := range s.accounts {
+		if index >= s.next {
+			delete(s.accounts, index)
+		}
+	}
+	return found
+}
+
+// Sends from one of the wallet's accounts, waiting for work if it isn't
+// ready
+func (s *SeedWallet) Send(ctx context.Context, from types.Account, to types.Account, amount uint128.Uint128) (*blocks.SendBlock, error) {
+	w, err := s.Find(from)
+	if err != nil {
+		return nil, err
+	}
+	w.lock()
+	defer w.unlock()
+	_, err = w.nextWork(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return w.send(to, amount)
+}
+
+// Receives a pending send to one of the wallet's accounts, opening the
+// account if this is its first block
+func (s *SeedWallet) Receive(ctx context.Context, account types.Account, source types.BlockHash) (blocks.Block, error) {
+	w, err := s.Find(account)
+	if err != nil {
+		return nil, err
+	}
+	return w.ReceivePending(ctx, source, s.Representative)
+}
+
+// Changes the representative of one of the wallet's accounts
+func (s *SeedWallet) Change(ctx context.Context, account types.Account, representative types.Account) (*blocks.ChangeBlock, error) {
+	w, err := s.Find(account)
+	if err != nil {
+		return nil, err
+	}
+	w.lock()
+	defer w.unlock()
+	_, err = w.nextWork(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return w.change(representative)
+}
@@ -0,0 +1,126 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package wallet
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/types"
+)
+
+// The block each send id created, for each account. Unlike the work cache
+// this has to be saved reliably, as forgetting an id could pay twice.
+type SendLog struct {
+	// File the log is saved to after every change, or empty to only keep
+	// it in memory
+	Path string
+
+	mutex   sync.Mutex
+	entries map[types.Account]map[string]types.BlockHash
+}
+
+// The log wallets use when they don't have their own
+var DefaultSends = NewSendLog("")
+
+func NewSendLog(path string) *SendLog {
+	return &SendLog{Path: path, entries: make(map[types.Account]map[string]types.BlockHash)}
+}
+
+// Loads a log saved at path, or returns an empty one if there isn't one
+func LoadSendLog(path string) (*SendLog, error) {
+	l := NewSendLog(path)
+	data, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return l, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	err = json.Unmarshal(data, &l.entries)
+	if err != nil {
+		return nil, errors.Wrap(err, "Invalid send log")
+	}
+	return l, nil
+}
+
+// Returns the block a send with this id created from the account
+func (l *SendLog) Get(account types.Account, id string) (types.BlockHash, bool) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	hash, ok := l.entries[account][id]
+	return hash, ok
+}
+
+func (l *SendLog) Put(account types.Account, id string, hash types.BlockHash) error {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.entries[account] == nil {
+		l.entries[account] = make(map[string]types.BlockHash)
+	}
+	l.entries[account][id] = hash
+	err := l.save()
+	if err != nil {
+		delete(l.entries[account], id)
+		return errors.Wrap(err, "Failed to save send log")
+	}
+	return nil
+}
+
+// Forgets an id, for when its send couldn't be made after all
+func (l *SendLog) Remove(account types.Account, id string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	delete(l.entries[account], id)
+	err := l.save()
+	if err != nil {
+		logger.Warnf("Failed to save send log: %s", err)
+	}
+}
+
+func (l *SendLog) save() error {
+	if l.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(l.entries, "", "    ")
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(l.Path), 0700)
+	if err != nil {
+		return err
+	}
+	tmp := l.Path + ".tmp"
+	err = ioutil.WriteFile(tmp, data, 0600)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, l.Path)
+}
@@ -27,23 +27,83 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package wallet
 
 import (
+	"context"
 	"encoding/hex"
+	"sync"
 
 	"github.com/pkg/errors"
 	"github.com/svaishnavy/crypto/ed25519"
 	"github.com/svaishnavy/nano/address"
 	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/logging"
+	"github.com/svaishnavy/nano/node"
 	"github.com/svaishnavy/nano/store"
 	"github.com/svaishnavy/nano/types"
 	"github.com/svaishnavy/nano/uint128"
+	"github.com/svaishnavy/nano/work"
 )
 
+var logger = logging.New("wallet")
+
+// A wallet for a single account. Its methods are safe to call from several
+// goroutines, and operations on the same account are serialised across
+// every wallet for it, so two can't both build on the same frontier.
+//
+// Blocks the wallet creates are stored in the ledger straight away, but
+// only reach the network if Node is set. Without it callers must publish
+// them with Node.Process.
 type Wallet struct {
 	privateKey ed25519.PrivateKey
 	PublicKey  ed25519.PublicKey
 	Head       blocks.Block
 	Work       *types.Work
 	PoWchan    chan types.Work
+	cancelPoW  context.CancelFunc
+	// Where proof of work comes from, work.Default if nil
+	WorkProvider work.Provider
+	// Where precomputed work is kept, DefaultCache if nil
+	Cache *WorkCache
+	// Where the ids of sends are recorded, DefaultSends if nil
+	Sends *SendLog
+	// Publishes created blocks until they're confirmed, if set
+	Node *node.Node
+}
+
+// A lock for each account, shared by all of its wallets. Locks are dropped
+// once nothing holds or waits for them, so accounts don't pile up.
+var accountLocks = struct {
+	sync.Mutex
+	locks map[types.Account]*accountLock
+}{locks: make(map[types.Account]*accountLock)}
+
+type accountLock struct {
+	sync.Mutex
+	users int
+}
+
+func (w *Wallet) lock() {
+	account := w.Address()
+	accountLocks.Lock()
+	l := accountLocks.locks[account]
+	if l == nil {
+		l = new(accountLock)
+		accountLocks.locks[account] = l
+	}
+	l.users++
+	accountLocks.Unlock()
+	l.Lock()
+}
+
+func (w *Wallet) unlock() {
+	account := w.Address()
+	accountLocks.Lock()
+	l := accountLocks.locks[account]
+	l.users--
+	if l.users == 0 {
+		delete(accountLocks.locks, account)
+	}
+	accountLocks.Unlock()
+	l.Unlock()
 }
 
 func (w *Wallet) Address() types.Account {
@@ -51,68 +111,289 @@ func (w *Wallet) Address() types.Account {
 }
 
 func New(private string) (w Wallet) {
-	w.PublicKey, w.privateKey = address.KeypairFromPrivateKey(private)
-	account := address.PubKeyToAddress(w.PublicKey)
+	return fromKeypair(address.KeypairFromPrivateKey(private))
+}
 
-	open := store.FetchOpen(account)
-	if open != nil {
-		w.Head = open
+func fromKeypair(public ed25519.PublicKey, private ed25519.PrivateKey) (w Wallet) {
+	w.PublicKey, w.privateKey = public, private
+	w.Head = frontier(w.Address())
+	return w
+}
+
+// The head of an account in the ledger, or nil if it hasn't been opened
+func frontier(account types.Account) blocks.Block {
+	info := store.FetchAccountInfo(account)
+	if info == nil {
+		return nil
 	}
+	return store.FetchBlock(info.Head)
+}
 
-	return w
+// Brings the wallet up to date with the account's frontier in the ledger,
+// which moves if another wallet with the same key creates blocks or our
+// own block loses an election. Returns true if the head changed.
+func (w *Wallet) Sync() bool {
+	w.lock()
+	defer w.unlock()
+	return w.sync()
+}
+
+func (w *Wallet) sync() bool {
+	head := frontier(w.Address())
+	if head == nil && w.Head == nil {
+		return false
+	}
+	if head != nil && w.Head != nil && head.Hash() == w.Head.Hash() {
+		return false
+	}
+
+	logger.Debugf("Wallet %s moved to the ledger's frontier", w.Address())
+	w.setHead(head)
+	return true
+}
+
+// The root the account's next block will be built on
+func (w *Wallet) root() types.BlockHash {
+	if w.Head == nil {
+		return types.BlockHash(hex.EncodeToString(w.PublicKey))
+	}
+	return w.Head.Hash()
+}
+
+// The difficulty the account's next block needs. Only an open is known in
+// advance, as anything else could be a send.
+func (w *Wallet) threshold() blocks.Difficulty {
+	if w.Head == nil {
+		return blocks.WorkThresholdFor(blocks.Open)
+	}
+	return blocks.WorkThreshold
+}
+
+func (w *Wallet) cache() *WorkCache {
+	if w.Cache == nil {
+		return DefaultCache
+	}
+	return w.Cache
+}
+
+func (w *Wallet) sends() *SendLog {
+	if w.Sends == nil {
+		return DefaultSends
+	}
+	return w.Sends
 }
 
-// Returns true if the wallet has prepared proof of work,
+// Takes work for the next block from the cache, if it has some
+func (w *Wallet) cachedWork() bool {
+	if w.Work != nil && blocks.WorkDifficulty(w.root(), *w.Work) >= w.threshold() {
+		return true
+	}
+	w.Work = nil
+	work, ok := w.cache().Get(w.Address(), w.root(), w.threshold())
+	if ok {
+		// Anything still being generated would only duplicate this
+		w.stopPoW()
+		w.Work = &work
+	}
+	return ok
+}
+
+// Returns true if the wallet has prepared proof of work, either cached or
+// finished generating, for its next block. Never waits for work.
 func (w *Wallet) HasPoW() bool {
+	w.lock()
+	defer w.unlock()
+
+	w.sync()
+	if w.cachedWork() {
+		return true
+	}
 	select {
-	case work := <-w.PoWchan:
-		w.Work = &work
+	case work, ok := <-w.PoWchan:
 		w.PoWchan = nil
+		if !ok {
+			return false
+		}
+		w.Work = &work
 		return true
 	default:
 		return false
 	}
 }
 
+// Returns work for the account's next block, waiting for it to be
+// generated if it isn't ready. Generation carries on in the background if
+// ctx is cancelled, so a later call can pick it up.
+func (w *Wallet) NextWork(ctx context.Context) (types.Work, error) {
+	w.lock()
+	defer w.unlock()
+	return w.nextWork(ctx)
+}
+
+func (w *Wallet) nextWork(ctx context.Context) (types.Work, error) {
+	w.sync()
+	if w.cachedWork() {
+		return *w.Work, nil
+	}
+	if w.PoWchan == nil {
+		err := w.generatePoWAsync()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	select {
+	case work, ok := <-w.PoWchan:
+		w.PoWchan = nil
+		if !ok {
+			return "", errors.Errorf("PoW generation was cancelled")
+		}
+		w.Work = &work
+		return work, nil
+	case <-ctx.Done():
+		return "", ctx.Err()
+	}
+}
+
+// Blocks until proof of work being generated is ready, or cancelled
 func (w *Wallet) WaitPoW() {
-	for !w.HasPoW() {
+	w.lock()
+	defer w.unlock()
+	if w.PoWchan != nil {
+		w.nextWork(context.Background())
+	}
+}
+
+// Stops generating proof of work
+func (w *Wallet) CancelPoW() {
+	w.lock()
+	defer w.unlock()
+	w.stopPoW()
+}
+
+func (w *Wallet) stopPoW() {
+	if w.cancelPoW != nil {
+		w.cancelPoW()
 	}
+	w.PoWchan = nil
 }
 
 func (w *Wallet) WaitingForPoW() bool {
+	w.lock()
+	defer w.unlock()
 	return w.PoWchan != nil
 }
 
 func (w *Wallet) GeneratePowSync() error {
-	err := w.GeneratePoWAsync()
-	if err != nil {
-		return err
-	}
-
-	w.WaitPoW()
-	return nil
+	_, err := w.NextWork(context.Background())
+	return err
 }
 
-// Triggers a goroutine to generate the next proof of work.
+// Triggers a goroutine to generate the next proof of work, which is saved
+// to the work cache once it's found.
 func (w *Wallet) GeneratePoWAsync() error {
+	w.lock()
+	defer w.unlock()
+	return w.generatePoWAsync()
+}
+
+func (w *Wallet) generatePoWAsync() error {
 	if w.PoWchan != nil {
 		return errors.Errorf("Already generating PoW")
 	}
 
-	w.PoWchan = make(chan types.Work)
+	account := w.Address()
+	root := w.root()
+	difficulty := w.threshold()
+	cache := w.cache()
+	provider := w.WorkProvider
+	if provider == nil {
+		provider = work.Default
+	}
 
-	go func(c chan types.Work, w *Wallet) {
-		if w.Head == nil {
-			c <- blocks.GenerateWorkForHash(types.BlockHash(hex.EncodeToString(w.PublicKey)))
-		} else {
-			c <- blocks.GenerateWork(w.Head)
+	ctx, cancel := context.WithCancel(context.Background())
+	w.cancelPoW = cancel
+	w.Work = nil
+	w.PoWchan = make(chan types.Work, 1)
+
+	go func(c chan types.Work) {
+		defer cancel()
+		work, err := provider.Generate(ctx, root, difficulty)
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.Errorf("Failed to generate work for %s: %s", root, err)
+			}
+			close(c)
+			return
 		}
-	}(w.PoWchan, w)
+		cache.Put(account, root, work)
+		c <- work
+	}(w.PoWchan)
 
 	return nil
 }
 
+// Starts generating work for the next block, unless it's already cached
+func (w *Wallet) precompute() {
+	w.stopPoW()
+	w.Work = nil
+	if !w.cachedWork() {
+		w.generatePoWAsync()
+	}
+}
+
+// Moves the wallet to a new frontier, such as one seen in the ledger, and
+// starts precomputing work for the block after it
+func (w *Wallet) SetHead(head blocks.Block) {
+	w.lock()
+	defer w.unlock()
+	w.setHead(head)
+}
+
+func (w *Wallet) setHead(head blocks.Block) {
+	if head == nil && w.Head == nil {
+		return
+	}
+	if head != nil && w.Head != nil && head.Hash() == w.Head.Hash() {
+		return
+	}
+	w.Head = head
+	w.cache().Invalidate(w.Address())
+	w.precompute()
+}
+
+// Stores a block the wallet has just created and moves on to it, so the
+// head only ever advances to blocks in the ledger, then publishes it if the
+// wallet has a node
+func (w *Wallet) created(block blocks.Block) error {
+	err := node.StoreBlock(block)
+	if err != nil {
+		return errors.Wrapf(err, "Failed to store %s block %s", block.Type(), block.Hash())
+	}
+	w.Head = block
+	logger.Debugf("Created %s block %s for %s", block.Type(), block.Hash(), w.Address())
+	w.precompute()
+
+	if w.Node != nil {
+		result := w.Node.Process(block)
+		go func() {
+			res := <-result
+			if res.Err != nil {
+				logger.Warnf("Block %s failed: %s", res.Hash, res.Err)
+			}
+		}()
+	}
+	return nil
+}
+
 func (w *Wallet) GetBalance() uint128.Uint128 {
+	w.lock()
+	defer w.unlock()
+	return w.balance()
+}
+
+func (w *Wallet) balance() uint128.Uint128 {
+	w.sync()
 	if w.Head == nil {
 		return uint128.FromInts(0, 0)
 	}
@@ -122,6 +403,13 @@ func (w *Wallet) GetBalance() uint128.Uint128 {
 }
 
 func (w *Wallet) Open(source types.BlockHash, representative types.Account) (*blocks.OpenBlock, error) {
+	w.lock()
+	defer w.unlock()
+	return w.open(source, representative)
+}
+
+func (w *Wallet) open(source types.BlockHash, representative types.Account) (*blocks.OpenBlock, error) {
+	w.sync()
 	if w.Head != nil {
 		return nil, errors.Errorf("Cannot open a non empty account")
 	}
@@ -140,6 +428,14 @@ func (w *Wallet) Open(source types.BlockHash, representative types.Account) (*bl
 		return nil, errors.Errorf("Could not find references send")
 	}
 
+	if send_block.Type() != blocks.Send {
+		return nil, errors.Errorf("Source block is not a send")
+	}
+
+	if send_block.(*blocks.SendBlock).Destination != w.Address() {
+		return nil, errors.Errorf("Send is not for this account")
+	}
+
 	common := blocks.CommonBlock{
 		Work:      *w.Work,
 		Signature: "",
@@ -158,11 +454,91 @@ func (w *Wallet) Open(source types.BlockHash, representative types.Account) (*bl
 		return nil, errors.Errorf("Invalid PoW")
 	}
 
-	w.Head = &block
+	err := w.created(&block)
+	if err != nil {
+		return nil, err
+	}
 	return &block, nil
 }
 
 func (w *Wallet) Send(destination types.Account, amount uint128.Uint128) (*blocks.SendBlock, error) {
+	w.lock()
+	defer w.unlock()
+	return w.send(destination, amount)
+}
+
+// Sends at most once for each id: repeating a send with the same id, e.g.
+// to retry after an error, returns the block it created the first time
+// instead of paying again. If that block isn't in the ledger, because we
+// stopped before storing it or it lost a fork and was rolled back, it can
+// never be paid, so the send is made again. Waits for work if it isn't
+// ready.
+func (w *Wallet) SendOnce(ctx context.Context, id string, destination types.Account, amount uint128.Uint128) (*blocks.SendBlock, error) {
+	w.lock()
+	defer w.unlock()
+
+	if id == "" {
+		return nil, errors.Errorf("Missing send id")
+	}
+	if hash, ok := w.sends().Get(w.Address(), id); ok {
+		if block, ok := store.FetchBlock(hash).(*blocks.SendBlock); ok {
+			return w.sent(id, block, destination, amount)
+		}
+		logger.Infof("Send %s for id %s isn't in the ledger, sending again", hash, id)
+	}
+
+	_, err := w.nextWork(ctx)
+	if err != nil {
+		return nil, err
+	}
+	block, err := w.build(destination, amount)
+	if err != nil {
+		return nil, err
+	}
+
+	// The id is recorded with the block's hash before the block is stored,
+	// so if we're interrupted in between a retry can tell whether it made
+	// it into the ledger
+	err = w.sends().Put(w.Address(), id, block.Hash())
+	if err != nil {
+		return nil, err
+	}
+	err = w.created(block)
+	if err != nil {
+		w.sends().Remove(w.Address(), id)
+		return nil, err
+	}
+	return block, nil
+}
+
+// Returns the block an earlier send with the same id created, as long as
+// it was for the same payment
+func (w *Wallet) sent(id string, block *blocks.SendBlock, destination types.Account, amount uint128.Uint128) (*blocks.SendBlock, error) {
+	previous := store.FetchBlock(block.PreviousBlockHash())
+	if previous == nil {
+		return nil, errors.Errorf("Send %s for id %s is no longer in the ledger", block.Hash(), id)
+	}
+	if block.Destination != destination || store.GetBalance(previous).Sub(block.Balance) != amount {
+		return nil, errors.Errorf("Send id %s was already used for a different send", id)
+	}
+	return block, nil
+}
+
+func (w *Wallet) send(destination types.Account, amount uint128.Uint128) (*blocks.SendBlock, error) {
+	block, err := w.build(destination, amount)
+	if err != nil {
+		return nil, err
+	}
+	err = w.created(block)
+	if err != nil {
+		return nil, err
+	}
+	return block, nil
+}
+
+// Builds and signs a send block on the current head
+func (w *Wallet) build(destination types.Account, amount uint128.Uint128) (*blocks.SendBlock, error) {
+	w.sync()
 	if w.Head == nil {
 		return nil, errors.Errorf("Cannot send from empty account")
 	}
@@ -171,7 +547,8 @@ func (w *Wallet) Send(destination types.Account, amount uint128.Uint128) (*block
 		return nil, errors.Errorf("No PoW")
 	}
 
-	if amount.Compare(w.GetBalance()) > 0 {
+	balance := w.balance()
+	if amount.Compare(balance) > 0 {
 		return nil, errors.Errorf("Tried to send more than balance")
 	}
 
@@ -183,17 +560,41 @@ func (w *Wallet) Send(destination types.Account, amount uint128.Uint128) (*block
 	block := blocks.SendBlock{
 		w.Head.Hash(),
 		destination,
-		w.GetBalance().Sub(amount),
+		balance.Sub(amount),
 		common,
 	}
 
 	block.Signature = block.Hash().Sign(w.privateKey)
-
-	w.Head = &block
 	return &block, nil
 }
 
 func (w *Wallet) Receive(source types.BlockHash) (*blocks.ReceiveBlock, error) {
+	w.lock()
+	defer w.unlock()
+	return w.receive(source)
+}
+
+// Receives a pending send, opening the account with representative if
+// this is its first block. Waits for work if it isn't ready.
+func (w *Wallet) ReceivePending(ctx context.Context, source types.BlockHash, representative types.Account) (blocks.Block, error) {
+	w.lock()
+	defer w.unlock()
+
+	_, err := w.nextWork(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if w.Head != nil {
+		return w.receive(source)
+	}
+	if representative == "" {
+		representative = w.Address()
+	}
+	return w.open(source, representative)
+}
+
+func (w *Wallet) receive(source types.BlockHash) (*blocks.ReceiveBlock, error) {
+	w.sync()
 	if w.Head == nil {
 		return nil, errors.Errorf("Cannot receive to empty account")
 	}
@@ -229,11 +630,21 @@ func (w *Wallet) Receive(source types.BlockHash) (*blocks.ReceiveBlock, error) {
 
 	block.Signature = block.Hash().Sign(w.privateKey)
 
-	w.Head = &block
+	err := w.created(&block)
+	if err != nil {
+		return nil, err
+	}
 	return &block, nil
 }
 
 func (w *Wallet) Change(representative types.Account) (*blocks.ChangeBlock, error) {
+	w.lock()
+	defer w.unlock()
+	return w.change(representative)
+}
+
+func (w *Wallet) change(representative types.Account) (*blocks.ChangeBlock, error) {
+	w.sync()
 	if w.Head == nil {
 		return nil, errors.Errorf("Cannot change on empty account")
 	}
@@ -255,6 +666,9 @@ func (w *Wallet) Change(representative types.Account) (*blocks.ChangeBlock, erro
 
 	block.Signature = block.Hash().Sign(w.privateKey)
 
-	w.Head = &block
+	err := w.created(&block)
+	if err != nil {
+		return nil, err
+	}
 	return &block, nil
 }
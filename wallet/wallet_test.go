@@ -27,17 +27,47 @@ OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
 package wallet
 
 import (
+	"bytes"
+	"context"
 	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/svaishnavy/nano/address"
 	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/node"
 	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
 	"github.com/svaishnavy/nano/uint128"
 )
 
-func TestNew(t *testing.T) {
+// Starts a test on an empty ledger, returning a function which removes it
+func testStore() func() {
+	os.RemoveAll(store.TestConfig.Path)
 	store.Init(store.TestConfig)
+	return func() { os.RemoveAll(store.TestConfig.Path) }
+}
+
+// Starts a node on a local port, so stopping it waits for the blocks it's
+// still publishing
+func startNode(t *testing.T) *node.Node {
+	n := node.NewNode()
+	n.ListenAddr = &net.UDPAddr{IP: net.ParseIP("127.0.0.1")}
+	if err := n.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	return n
+}
+
+func TestNew(t *testing.T) {
+	defer testStore()()
 
 	w := New(blocks.TestPrivateKey)
 	if w.GetBalance() != blocks.GenesisAmount {
@@ -47,7 +77,7 @@ func TestNew(t *testing.T) {
 
 func TestPoW(t *testing.T) {
 	blocks.WorkThreshold = 0xff00000000000000
-	store.Init(store.TestConfig)
+	defer testStore()()
 	w := New(blocks.TestPrivateKey)
 
 	if w.GeneratePoWAsync() != nil || !w.WaitingForPoW() {
@@ -71,12 +101,34 @@ func TestPoW(t *testing.T) {
 	if !blocks.ValidateBlockWork(send) {
 		t.Errorf("Invalid work")
 	}
+}
+
+func TestCancelPoW(t *testing.T) {
+	blocks.WorkThreshold = 0xffffffffffffffff
+	defer func() { blocks.WorkThreshold = 0xff00000000000000 }()
+	defer testStore()()
+	w := New(blocks.TestPrivateKey)
 
+	w.GeneratePoWAsync()
+	c := w.PoWchan
+	w.CancelPoW()
+
+	select {
+	case _, ok := <-c:
+		if ok {
+			t.Errorf("Found work at the maximum difficulty")
+		}
+	case <-time.After(5 * time.Second):
+		t.Errorf("PoW generation wasn't cancelled")
+	}
+	if w.WaitingForPoW() || w.Work != nil {
+		t.Errorf("Wallet still waiting for cancelled PoW")
+	}
 }
 
 func TestSend(t *testing.T) {
 	blocks.WorkThreshold = 0xff00000000000000
-	store.Init(store.TestConfig)
+	defer testStore()()
 	w := New(blocks.TestPrivateKey)
 
 	w.GeneratePowSync()
@@ -101,12 +153,11 @@ func TestSend(t *testing.T) {
 	if w.GetBalance() != blocks.GenesisAmount {
 		t.Errorf("Balance not updated after receive, %x != %x", w.GetBalance().GetBytes(), blocks.GenesisAmount.GetBytes())
 	}
-
 }
 
 func TestOpen(t *testing.T) {
 	blocks.WorkThreshold = 0xff00000000000000
-	store.Init(store.TestConfig)
+	defer testStore()()
 	amount := uint128.FromInts(1, 1)
 
 	sendW := New(blocks.TestPrivateKey)
@@ -114,10 +165,9 @@ func TestOpen(t *testing.T) {
 
 	_, priv := address.GenerateKey()
 	openW := New(hex.EncodeToString(priv))
-	send, _ := sendW.Send(openW.Address(), amount)
 	openW.GeneratePowSync()
 
-	_, err := openW.Open(send.Hash(), openW.Address())
+	_, err := openW.Open(types.BlockHash(strings.Repeat("AB", 32)), openW.Address())
 	if err == nil {
 		t.Errorf("Expected error for referencing unstored send")
 	}
@@ -126,7 +176,17 @@ func TestOpen(t *testing.T) {
 		t.Errorf("Open should start at zero balance")
 	}
 
-	store.StoreBlock(send)
+	if _, err = openW.Open(blocks.TestGenesisBlock.Hash(), openW.Address()); err == nil {
+		t.Errorf("Opened from a block which isn't a send")
+	}
+	_, otherPriv := address.GenerateKey()
+	other := New(hex.EncodeToString(otherPriv))
+	other.GeneratePowSync()
+
+	send, _ := sendW.Send(openW.Address(), amount)
+	if _, err = other.Open(send.Hash(), other.Address()); err == nil {
+		t.Errorf("Opened with a send to another account")
+	}
 	_, err = openW.Open(send.Hash(), openW.Address())
 	if err != nil {
 		t.Errorf("Open block failed: %s", err)
@@ -140,5 +200,444 @@ func TestOpen(t *testing.T) {
 	if err == nil {
 		t.Errorf("Expected error for creating duplicate open block")
 	}
+}
+
+func TestWorkCache(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	path := filepath.Join(os.TempDir(), "nano_work_cache_test.json")
+	defer os.Remove(path)
+	account := blocks.TestGenesisBlock.Account
+	root := blocks.TestGenesisBlock.Hash()
+
+	cache := NewWorkCache(path)
+	cache.Put(account, root, blocks.GenerateWorkForHash(root))
+
+	loaded, err := LoadWorkCache(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	work, ok := loaded.Get(account, root, blocks.WorkThreshold)
+	if !ok || blocks.WorkDifficulty(root, work) < blocks.WorkThreshold {
+		t.Errorf("Cached work wasn't saved")
+	}
+
+	// Work for an old frontier is dropped
+	if _, ok := loaded.Get(account, blocks.TestGenesisBlock.RootHash(), blocks.WorkThreshold); ok {
+		t.Errorf("Returned work for the wrong root")
+	}
+	if _, ok := loaded.Get(account, root, blocks.WorkThreshold); ok {
+		t.Errorf("Stale work wasn't invalidated")
+	}
+}
+
+func TestOpenWork(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	blocks.ReceiveWorkMultiplier = 1.0 / 64
+	defer func() { blocks.ReceiveWorkMultiplier = 1 }()
+	defer testStore()()
+
+	// Work which is only good enough for an open is used for an unopened
+	// account
+	_, priv := address.GenerateKey()
+	w := New(hex.EncodeToString(priv[:32]))
+	w.Cache = NewWorkCache("")
+	root := w.root()
+	var work types.Work
+	for {
+		work, _ = blocks.GenerateWorkContext(context.Background(), root, blocks.ReceiveWorkThreshold(), 1)
+		if blocks.WorkDifficulty(root, work) < blocks.WorkThreshold {
+			break
+		}
+	}
+	w.Cache.Put(w.Address(), root, work)
+	if !w.HasPoW() || *w.Work != work {
+		t.Errorf("Open work wasn't taken from the cache")
+	}
+}
+
+func TestNextWork(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	defer testStore()()
+	w := New(blocks.TestPrivateKey)
+	w.Cache = NewWorkCache("")
+
+	work, err := w.NextWork(context.Background())
+	if err != nil || blocks.WorkDifficulty(w.Head.Hash(), work) < blocks.WorkThreshold {
+		t.Fatalf("Invalid work %s %v", work, err)
+	}
+	send, _ := w.Send(blocks.TestGenesisBlock.Account, uint128.FromInts(0, 1))
+
+	// Work for the block after the send is precomputed
+	select {
+	case <-w.PoWchan:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Work wasn't precomputed")
+	}
+	w.PoWchan = nil
+	if _, ok := w.Cache.Get(w.Address(), send.Hash(), blocks.WorkThreshold); !ok {
+		t.Errorf("Precomputed work wasn't cached")
+	}
+	if work, _ = w.NextWork(context.Background()); blocks.WorkDifficulty(send.Hash(), work) < blocks.WorkThreshold {
+		t.Errorf("Invalid next work %s", work)
+	}
+
+	blocks.WorkThreshold = 0xffffffffffffffff
+	defer func() { blocks.WorkThreshold = 0xff00000000000000 }()
+	w.SetHead(blocks.TestGenesisBlock)
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if _, err := w.NextWork(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	w.CancelPoW()
+}
+
+func TestSync(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	defer testStore()()
+	send := func(w *Wallet, amount uint64) *blocks.SendBlock {
+		w.NextWork(context.Background())
+		block, err := w.Send(blocks.TestGenesisBlock.Account, uint128.FromInts(0, amount))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return block
+	}
+
+	w := New(blocks.TestPrivateKey)
+	for i := 0; i < 3; i++ {
+		send(&w, 1)
+	}
+
+	// Loading resolves the frontier rather than the open block
+	other := New(blocks.TestPrivateKey)
+	if other.Head.Hash() != w.Head.Hash() {
+		t.Fatalf("Loaded head %s, expected %s", other.Head.Hash(), w.Head.Hash())
+	}
+	if other.GetBalance() != w.GetBalance() {
+		t.Errorf("Loaded balance %x, expected %x", other.GetBalance().GetBytes(), w.GetBalance().GetBytes())
+	}
+
+	// Another instance with the same key moves the frontier
+	mine := send(&w, 1)
+	moved := send(&other, 2)
+	if moved.PreviousBlockHash() != mine.Hash() {
+		t.Errorf("Other instance forked the account")
+	}
+	if !w.Sync() || w.Head.Hash() != moved.Hash() {
+		t.Fatalf("Sync didn't pick up the new frontier")
+	}
+	next := send(&w, 1)
+	if next.PreviousBlockHash() != moved.Hash() {
+		t.Errorf("Send built on %s, expected %s", next.PreviousBlockHash(), moved.Hash())
+	}
+}
+
+func TestConcurrentSend(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	defer testStore()()
+	w := New(blocks.TestPrivateKey)
+	other := New(blocks.TestPrivateKey)
+
+	// Sends from two instances with the same key never share a previous
+	sent := make(chan *blocks.SendBlock, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(w *Wallet, i int) {
+			defer wg.Done()
+			w.HasPoW()
+			block, err := w.SendOnce(context.Background(), fmt.Sprint(i), blocks.TestGenesisBlock.Account, uint128.FromInts(0, 1))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			sent <- block
+		}([]*Wallet{&w, &other}[i%2], i)
+	}
+	wg.Wait()
+	close(sent)
+
+	previous := make(map[types.BlockHash]bool)
+	for block := range sent {
+		if previous[block.PreviousBlockHash()] {
+			t.Errorf("Forked on %s", block.PreviousBlockHash())
+		}
+		previous[block.PreviousBlockHash()] = true
+	}
+	if w.GetBalance() != blocks.GenesisAmount.Sub(uint128.FromInts(0, 10)) {
+		t.Errorf("Wrong balance after sends %x", w.GetBalance().GetBytes())
+	}
+}
+
+func TestSendOnce(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	defer testStore()()
+	path := filepath.Join(os.TempDir(), "nano_send_log_test.json")
+	defer os.Remove(path)
+	w := New(blocks.TestPrivateKey)
+	w.Sends = NewSendLog(path)
+	amount := uint128.FromInts(0, 1)
+	ctx := context.Background()
+
+	send, err := w.SendOnce(ctx, "a", blocks.TestGenesisBlock.Account, amount)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Retrying, even from a new instance, doesn't pay again
+	log, err := LoadSendLog(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := New(blocks.TestPrivateKey)
+	other.Sends = log
+	again, err := other.SendOnce(ctx, "a", blocks.TestGenesisBlock.Account, amount)
+	if err != nil || again.Hash() != send.Hash() {
+		t.Errorf("Repeated send created %v, %v", again, err)
+	}
+	if w.GetBalance() != blocks.GenesisAmount.Sub(amount) {
+		t.Errorf("Paid twice")
+	}
+
+	if _, err := w.SendOnce(ctx, "a", blocks.TestGenesisBlock.Account, uint128.FromInts(0, 2)); err == nil {
+		t.Errorf("Reused id for a different send")
+	}
+	next, err := w.SendOnce(ctx, "b", blocks.TestGenesisBlock.Account, amount)
+	if err != nil || next.PreviousBlockHash() != send.Hash() {
+		t.Fatalf("New id didn't send, %v", err)
+	}
+
+	// An id whose block never reached the ledger, e.g. after a crash, is
+	// sent again
+	w.Sends.Put(w.Address(), "c", types.BlockHash(strings.Repeat("0", 64)))
+	if resent, err := w.SendOnce(ctx, "c", blocks.TestGenesisBlock.Account, amount); err != nil || resent.PreviousBlockHash() != next.Hash() {
+		t.Errorf("Lost send wasn't sent again, %v", err)
+	}
+	if hash, _ := w.Sends.Get(w.Address(), "c"); hash != w.Head.Hash() {
+		t.Errorf("Resend wasn't recorded")
+	}
+
+	if len(accountLocks.locks) != 0 {
+		t.Errorf("Account locks weren't released")
+	}
+}
+
+func TestSeedWallet(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	defer testStore()()
+
+	if _, err := NewSeedWallet("1234"); err == nil {
+		t.Errorf("Accepted an invalid seed")
+	}
+	seed, _ := GenerateSeed()
+	s, err := NewSeedWallet(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	first := s.NewAccount()
+	public, _ := address.KeypairFromSeed(seed, 0)
+	if first.Address() != address.PubKeyToAddress(public) || s.NextIndex() != 1 {
+		t.Errorf("Wrong first account %s", first.Address())
+	}
+
+	// Fund an account past the next index, which a scan should find
+	public, _ = address.KeypairFromSeed(seed, 3)
+	destination := address.PubKeyToAddress(public)
+	genesis := New(blocks.TestPrivateKey)
+	genesis.GeneratePowSync()
+	send, _ := genesis.Send(destination, uint128.FromInts(0, 5))
+	store.StoreBlock(send)
+
+	if found := s.Scan(2); found != 0 {
+		t.Errorf("Found %d accounts within the gap limit", found)
+	}
+	if found := s.Scan(DefaultGapLimit); found != 1 || s.NextIndex() != 4 || len(s.Accounts()) != 4 {
+		t.Errorf("Scan found %d accounts, next index %d", found, s.NextIndex())
+	}
+
+	ctx := context.Background()
+	open, err := s.Receive(ctx, destination, send.Hash())
+	if err != nil || open.Type() != blocks.Open {
+		t.Fatalf("Failed to open account: %s", err)
+	}
+	store.StoreBlock(open)
+	change, err := s.Change(ctx, destination, genesis.Address())
+	if err != nil || change.PreviousBlockHash() != open.Hash() {
+		t.Errorf("Failed to change representative: %s", err)
+	}
+	if _, err := s.Send(ctx, genesis.Address(), destination, uint128.FromInts(0, 1)); err == nil {
+		t.Errorf("Sent from an account outside the wallet")
+	}
+	s.Account(3).CancelPoW()
+}
+
+func TestKeystore(t *testing.T) {
+	defaultKdf.Memory = 1024
+	defer func() { defaultKdf.Memory = 64 * 1024 }()
+	dir, _ := ioutil.TempDir("", "nano-keystore")
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "wallet.json")
+
+	k, err := CreateKeystore(path, "password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	seed, _ := GenerateSeed()
+	if k.AddSeed(seed) != nil || k.AddKey(blocks.TestPrivateKey) != nil {
+		t.Fatalf("Failed to add secrets")
+	}
+	if k.AddKey(blocks.TestPrivateKey) == nil || k.AddSeed("1234") == nil {
+		t.Errorf("Added a duplicate or invalid secret")
+	}
+	data, _ := ioutil.ReadFile(path)
+	if strings.Contains(strings.ToUpper(string(data)), seed) {
+		t.Errorf("Seed saved in the clear")
+	}
+
+	k, err = OpenKeystore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := k.Seeds(); err != ErrLocked {
+		t.Errorf("Read seeds while locked")
+	}
+	if k.Unlock("wrong", 0) != ErrWrongPassword {
+		t.Errorf("Unlocked with the wrong password")
+	}
+	if err := k.Unlock("password", 50*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if seeds, _ := k.Seeds(); len(seeds) != 1 || seeds[0] != seed {
+		t.Errorf("Wrong seeds %v", seeds)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if !k.Locked() {
+		t.Errorf("Keystore didn't lock after its timeout")
+	}
 
+	if k.ChangePassword("wrong", "new") == nil {
+		t.Errorf("Changed password without the old one")
+	}
+	if err := k.ChangePassword("password", "new"); err != nil {
+		t.Fatal(err)
+	}
+	var exported bytes.Buffer
+	k.Export(&exported)
+	if _, err := ImportKeystore(filepath.Join(dir, "copy.json"), bytes.NewReader(exported.Bytes()), "password"); err == nil {
+		t.Errorf("Imported with the old password")
+	}
+	imported, err := ImportKeystore(filepath.Join(dir, "copy.json"), bytes.NewReader(exported.Bytes()), "new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	imported.Unlock("new", 0)
+	if keys, _ := imported.Keys(); len(keys) != 1 || keys[0] != strings.ToUpper(blocks.TestPrivateKey) {
+		t.Errorf("Wrong imported keys %v", keys)
+	}
+}
+
+func TestMnemonicWallet(t *testing.T) {
+	defer testStore()()
+	mnemonic := "edge defense waste choose enrich upon flee junk siren film clown finish luggage leader kid quick brick print evidence swap drill paddle truly occur"
+	if _, err := NewMnemonicWallet("edge defense waste", ""); err == nil {
+		t.Errorf("Accepted an invalid mnemonic")
+	}
+	s, err := NewMnemonicWallet(mnemonic, "some password")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if w := s.Account(0); w.Address() != "nano_1pu7p5n3ghq1i1p4rhmek41f5add1uh34xpb94nkbxe8g4a6x1p69emk8y1d" {
+		t.Errorf("Wrong first account %s", w.Address())
+	}
+
+	defaultKdf.Memory = 1024
+	defer func() { defaultKdf.Memory = 64 * 1024 }()
+	dir, _ := ioutil.TempDir("", "nano-keystore")
+	defer os.RemoveAll(dir)
+	k, _ := CreateKeystore(filepath.Join(dir, "wallet.json"), "password")
+	if k.AddMnemonic(mnemonic, "some password") != nil || k.AddMnemonic("edge defense waste", "") == nil {
+		t.Errorf("Wrong mnemonic validation")
+	}
+	if mnemonics, _ := k.Mnemonics(); len(mnemonics) != 1 || mnemonics[0].Passphrase != "some password" {
+		t.Errorf("Wrong mnemonics %v", mnemonics)
+	}
+}
+
+func TestReceiver(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	defer testStore()()
+	node.ActiveElections = node.NewElections()
+	node.SetRepresentative(blocks.TestPrivateKey)
+	n := startNode(t)
+	defer n.Stop()
+
+	_, priv := address.GenerateKey()
+	w := New(hex.EncodeToString(priv[:32]))
+	genesis := New(blocks.TestPrivateKey)
+	send := func(amount uint64) {
+		genesis.GeneratePowSync()
+		block, _ := genesis.Send(w.Address(), uint128.FromInts(0, amount))
+		if result := <-n.Process(block); result.Err != nil {
+			t.Fatal(result.Err)
+		}
+	}
+	balance := func(expected uint64) {
+		for i := 0; i < 500; i++ {
+			if info := store.FetchAccountInfo(w.Address()); info != nil && info.Balance == uint128.FromInts(0, expected) {
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		t.Fatalf("Balance didn't reach %d", expected)
+	}
+
+	// One send is already in the ledger, the other arrives while running
+	send(5)
+	send(100)
+	r := NewReceiver(n, uint128.FromInts(0, 10))
+	r.Add(&w)
+	r.Start()
+	defer r.Stop()
+	balance(100)
+
+	send(50)
+	balance(150)
+	if pending := store.FetchPending(w.Address(), 0); len(pending) != 1 || pending[0].Amount != uint128.FromInts(0, 5) {
+		t.Errorf("Dust wasn't left pending: %v", pending)
+	}
+
+	// Once stopped, new sends aren't queued
+	r.Stop()
+	r.mutex.Lock()
+	r.ctx = context.Background()
+	r.mutex.Unlock()
+	send(20)
+	if len(r.queue) != 0 {
+		t.Errorf("Stopped receiver still observes new blocks")
+	}
+}
+
+func TestPublish(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	defer testStore()()
+	node.ActiveElections = node.NewElections()
+	node.SetRepresentative(blocks.TestPrivateKey)
+	w := New(blocks.TestPrivateKey)
+	w.Node = startNode(t)
+	defer w.Node.Stop()
+
+	w.GeneratePowSync()
+	send, err := w.Send(blocks.TestGenesisBlock.Account, uint128.FromInts(0, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 500; i++ {
+		if b := store.FetchBlock(send.Hash()); b != nil && b.IsConfirmed() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Published block wasn't confirmed")
 }
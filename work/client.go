@@ -0,0 +1,136 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package work
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/types"
+)
+
+// How long to wait for a work server to acknowledge a cancellation
+const cancelTimeout = 5 * time.Second
+
+// A request in the reference work server protocol. Every value is sent as
+// a string.
+type request struct {
+	Action     string `json:"action"`
+	Hash       string `json:"hash"`
+	Work       string `json:"work,omitempty"`
+	Difficulty string `json:"difficulty,omitempty"`
+	Multiplier string `json:"multiplier,omitempty"`
+}
+
+type response struct {
+	Work       string `json:"work,omitempty"`
+	Difficulty string `json:"difficulty,omitempty"`
+	Multiplier string `json:"multiplier,omitempty"`
+	Hash       string `json:"hash,omitempty"`
+	Valid      string `json:"valid,omitempty"`
+	Error      string `json:"error,omitempty"`
+}
+
+// Requests work from a remote work server, such as the reference
+// nano-work-server or another node running the work server command
+type Client struct {
+	URL string
+
+	http *http.Client
+}
+
+func NewClient(url string) *Client {
+	return &Client{URL: url, http: &http.Client{}}
+}
+
+func (c *Client) call(ctx context.Context, r request) (*response, error) {
+	body, err := json.Marshal(r)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest(http.MethodPost, c.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.http.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	var result response
+	err = json.NewDecoder(resp.Body).Decode(&result)
+	if err != nil {
+		return nil, errors.Wrapf(err, "Invalid response from work server %s", c.URL)
+	}
+	if result.Error != "" {
+		return nil, errors.Errorf("Work server %s failed: %s", c.URL, result.Error)
+	}
+	return &result, nil
+}
+
+// Asks the server for work. If ctx is cancelled first the server is told
+// to stop. Work the server returns is checked, as a misbehaving server
+// would otherwise only be noticed when peers reject the block.
+func (c *Client) Generate(ctx context.Context, root types.BlockHash, difficulty blocks.Difficulty) (types.Work, error) {
+	result, err := c.call(ctx, request{
+		Action:     "work_generate",
+		Hash:       string(root),
+		Difficulty: difficulty.String(),
+	})
+	if ctx.Err() != nil {
+		c.Cancel(root)
+		return "", ctx.Err()
+	}
+	if err != nil {
+		return "", err
+	}
+
+	work := types.Work(result.Work)
+	if blocks.WorkDifficulty(root, work) < difficulty {
+		return "", errors.Errorf("Work server %s returned invalid work %s", c.URL, work)
+	}
+	return work, nil
+}
+
+// Tells the server to stop generating work for root
+func (c *Client) Cancel(root types.BlockHash) error {
+	ctx, cancel := context.WithTimeout(context.Background(), cancelTimeout)
+	defer cancel()
+
+	_, err := c.call(ctx, request{Action: "work_cancel", Hash: string(root)})
+	if err != nil {
+		logger.Warnf("Failed to cancel work for %s: %s", root, err)
+	}
+	return err
+}
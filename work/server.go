@@ -0,0 +1,263 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package work
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/types"
+)
+
+// Requests larger than this are rejected
+const maxRequestSize = 1 << 16
+
+type job struct {
+	cancel context.CancelFunc
+}
+
+// An HTTP server generating work with a provider, speaking the same
+// protocol as the reference nano-work-server
+type Server struct {
+	Addr     string
+	Provider Provider
+
+	http     *http.Server
+	listener net.Listener
+	mutex    sync.Mutex
+	jobs     map[types.BlockHash][]*job
+}
+
+func NewServer(addr string, provider Provider) *Server {
+	return &Server{
+		Addr:     addr,
+		Provider: provider,
+		jobs:     make(map[types.BlockHash][]*job),
+	}
+}
+
+// Starts serving requests in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.http = &http.Server{Handler: s}
+
+	logger.Infof("Listening for work requests on %s", listener.Addr())
+	go func() {
+		err := s.http.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Errorf("Work server failed: %s", err)
+		}
+	}()
+	return nil
+}
+
+// Cancels any work being generated and stops the server
+func (s *Server) Stop() error {
+	if s.http == nil {
+		return nil
+	}
+	s.mutex.Lock()
+	for _, jobs := range s.jobs {
+		for _, j := range jobs {
+			j.cancel()
+		}
+	}
+	s.mutex.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	return s.http.Shutdown(ctx)
+}
+
+// The address the server is listening on, once started
+func (s *Server) ListenAddr() net.Addr {
+	return s.listener.Addr()
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		http.Error(w, "Work requests must be POSTed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, req.Body, maxRequestSize))
+	if err != nil {
+		writeResponse(w, response{Error: "Unable to read request"})
+		return
+	}
+	var r request
+	err = json.Unmarshal(body, &r)
+	if err != nil {
+		writeResponse(w, response{Error: "Unable to parse JSON"})
+		return
+	}
+
+	writeResponse(w, s.handle(req.Context(), r))
+}
+
+func writeResponse(w http.ResponseWriter, r response) {
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(r)
+	if err != nil {
+		logger.Warnf("Failed to write work response: %s", err)
+	}
+}
+
+func (s *Server) handle(ctx context.Context, r request) response {
+	root, err := parseRoot(r.Hash)
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+	switch r.Action {
+	case "work_generate":
+		return s.generate(ctx, root, r)
+	case "work_cancel":
+		s.cancel(root)
+		return response{}
+	case "work_validate":
+		return validate(root, r)
+	default:
+		return response{Error: "Unknown action"}
+	}
+}
+
+func parseRoot(s string) (types.BlockHash, error) {
+	bytes, err := hex.DecodeString(s)
+	if err != nil || len(bytes) != 32 {
+		return "", errors.New("Bad block hash")
+	}
+	return types.BlockHashFromBytes(bytes), nil
+}
+
+// Reads the requested difficulty, which is either given directly or as a
+// multiplier of the network's threshold
+func (r request) difficulty() (blocks.Difficulty, error) {
+	switch {
+	case r.Difficulty != "":
+		d, err := blocks.ParseDifficulty(r.Difficulty)
+		if err != nil {
+			return 0, errors.New("Bad difficulty")
+		}
+		return d, nil
+	case r.Multiplier != "":
+		multiplier, err := strconv.ParseFloat(r.Multiplier, 64)
+		if err != nil || multiplier <= 0 {
+			return 0, errors.New("Bad multiplier")
+		}
+		return blocks.FromMultiplier(multiplier, blocks.WorkThreshold), nil
+	default:
+		return blocks.WorkThreshold, nil
+	}
+}
+
+func describe(root types.BlockHash, work types.Work) response {
+	d := blocks.WorkDifficulty(root, work)
+	return response{
+		Work:       string(work),
+		Hash:       string(root),
+		Difficulty: d.String(),
+		Multiplier: strconv.FormatFloat(d.Multiplier(blocks.WorkThreshold), 'f', -1, 64),
+	}
+}
+
+func (s *Server) generate(ctx context.Context, root types.BlockHash, r request) response {
+	difficulty, err := r.difficulty()
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+
+	// Work stops when the client disconnects as well as when it's cancelled
+	ctx, cancel := context.WithCancel(ctx)
+	j := &job{cancel: cancel}
+	s.mutex.Lock()
+	s.jobs[root] = append(s.jobs[root], j)
+	s.mutex.Unlock()
+	defer s.finish(root, j)
+
+	work, err := s.Provider.Generate(ctx, root, difficulty)
+	if err != nil {
+		logger.Debugf("Work for %s stopped: %s", root, err)
+		return response{Error: "Cancelled"}
+	}
+	return describe(root, work)
+}
+
+func (s *Server) finish(root types.BlockHash, j *job) {
+	j.cancel()
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	jobs := s.jobs[root]
+	for i, other := range jobs {
+		if other == j {
+			jobs = append(jobs[:i], jobs[i+1:]...)
+			break
+		}
+	}
+	if len(jobs) == 0 {
+		delete(s.jobs, root)
+	} else {
+		s.jobs[root] = jobs
+	}
+}
+
+func (s *Server) cancel(root types.BlockHash) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, j := range s.jobs[root] {
+		j.cancel()
+	}
+}
+
+func validate(root types.BlockHash, r request) response {
+	if work, err := hex.DecodeString(r.Work); err != nil || len(work) != 8 {
+		return response{Error: "Bad work"}
+	}
+	difficulty, err := r.difficulty()
+	if err != nil {
+		return response{Error: err.Error()}
+	}
+
+	result := describe(root, types.Work(r.Work))
+	result.Valid = "0"
+	if blocks.WorkDifficulty(root, types.Work(r.Work)) >= difficulty {
+		result.Valid = "1"
+	}
+	return result
+}
@@ -0,0 +1,57 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package work
+
+import (
+	"context"
+
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/logging"
+	"github.com/svaishnavy/nano/types"
+)
+
+var logger = logging.New("work")
+
+// Something which can find proof of work for a root, such as the local
+// CPU or a remote work server
+type Provider interface {
+	// Returns work for root with at least the given difficulty, or an
+	// error if ctx is cancelled first
+	Generate(ctx context.Context, root types.BlockHash, difficulty blocks.Difficulty) (types.Work, error)
+}
+
+// The provider wallets use when they don't have their own
+var Default Provider = &Local{}
+
+// Generates work on this machine's CPUs
+type Local struct {
+	// Number of goroutines searching for work, one per CPU if zero
+	Workers int
+}
+
+func (l *Local) Generate(ctx context.Context, root types.BlockHash, difficulty blocks.Difficulty) (types.Work, error) {
+	return blocks.GenerateWorkContext(ctx, root, difficulty, l.Workers)
+}
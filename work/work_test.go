@@ -0,0 +1,103 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package work
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/svaishnavy/nano/blocks"
+)
+
+func TestClientServer(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	root := blocks.TestGenesisBlock.Hash()
+	server := NewServer("", &Local{})
+	listener := httptest.NewServer(server)
+	defer listener.Close()
+	client := NewClient(listener.URL)
+
+	work, err := client.Generate(context.Background(), root, blocks.WorkThreshold)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if blocks.WorkDifficulty(root, work) < blocks.WorkThreshold {
+		t.Errorf("Work server returned invalid work %s", work)
+	}
+
+	result, err := client.call(context.Background(), request{Action: "work_validate", Hash: string(root), Work: string(work)})
+	if err != nil || result.Valid != "1" || result.Multiplier == "" {
+		t.Errorf("Work wasn't validated %v %s", result, err)
+	}
+	if _, err := client.call(context.Background(), request{Action: "work_generate", Hash: "1234"}); err == nil {
+		t.Errorf("Accepted a bad hash")
+	}
+}
+
+func TestClientCancel(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	root := blocks.TestGenesisBlock.Hash()
+	server := NewServer("", &Local{Workers: 1})
+	listener := httptest.NewServer(server)
+	defer listener.Close()
+	client := NewClient(listener.URL)
+
+	// Nothing will meet the maximum difficulty, so this runs until cancelled
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err := client.Generate(ctx, root, 0xffffffffffffffff)
+	if err != context.DeadlineExceeded {
+		t.Errorf("Expected cancellation, got %v", err)
+	}
+
+	for i := 0; i < 100; i++ {
+		server.mutex.Lock()
+		running := len(server.jobs)
+		server.mutex.Unlock()
+		if running == 0 {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Errorf("Work wasn't cancelled on the server")
+}
+
+func TestClientRejectsInvalidWork(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(response{Work: "0000000000000000"})
+	}))
+	defer server.Close()
+
+	_, err := NewClient(server.URL).Generate(context.Background(), blocks.TestGenesisBlock.Hash(), 0xffffffffffffffff)
+	if err == nil {
+		t.Errorf("Accepted invalid work")
+	}
+}
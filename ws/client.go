@@ -0,0 +1,233 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package ws
+
+import (
+	"encoding/json"
+	"math/big"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/types"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+// Restricts a subscription to events concerning particular accounts. An
+// empty filter matches everything.
+type filter struct {
+	accounts map[types.Account]bool
+}
+
+func (f *filter) matches(accounts []types.Account) bool {
+	if len(f.accounts) == 0 {
+		return true
+	}
+	for _, account := range accounts {
+		if f.accounts[account] {
+			return true
+		}
+	}
+	return false
+}
+
+type client struct {
+	server *Server
+	conn   *websocket.Conn
+	send   chan []byte
+
+	mutex     sync.Mutex
+	topics    map[string]*filter
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+type subscribeOptions struct {
+	Accounts        []types.Account `json:"accounts"`
+	Representatives []types.Account `json:"representatives"`
+}
+
+type clientMessage struct {
+	Action  string           `json:"action"`
+	Topic   string           `json:"topic"`
+	Ack     bool             `json:"ack"`
+	Id      string           `json:"id"`
+	Options subscribeOptions `json:"options"`
+}
+
+type ackMessage struct {
+	Ack  string `json:"ack"`
+	Time string `json:"time"`
+	Id   string `json:"id,omitempty"`
+}
+
+var validTopics = map[string]bool{
+	TopicConfirmation:     true,
+	TopicNewBlock:         true,
+	TopicVote:             true,
+	TopicActiveDifficulty: true,
+}
+
+func (c *client) subscribed(topic string, accounts []types.Account) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	f := c.topics[topic]
+	return f != nil && f.matches(accounts)
+}
+
+// Queues a message without blocking, returning false if the client has
+// fallen too far behind
+func (c *client) queue(data []byte) bool {
+	select {
+	case c.send <- data:
+		return true
+	case <-c.done:
+		return true
+	default:
+		return false
+	}
+}
+
+func (c *client) queueJson(message interface{}) {
+	data, err := json.Marshal(message)
+	if err == nil && !c.queue(data) {
+		c.server.remove(c)
+	}
+}
+
+func (c *client) close() {
+	c.closeOnce.Do(func() {
+		close(c.done)
+		c.conn.Close()
+	})
+}
+
+func (c *client) readLoop() {
+	defer c.server.remove(c)
+
+	c.conn.SetReadLimit(maxMessageSize)
+	c.conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+	c.conn.SetPongHandler(func(string) error {
+		c.conn.SetReadDeadline(time.Now().Add(2 * pingInterval))
+		return nil
+	})
+
+	for {
+		_, data, err := c.conn.ReadMessage()
+		if err != nil {
+			return
+		}
+
+		var m clientMessage
+		err = json.Unmarshal(data, &m)
+		if err != nil {
+			c.queueJson(map[string]string{"error": "Unable to parse JSON"})
+			continue
+		}
+		c.handle(&m)
+	}
+}
+
+func (c *client) handle(m *clientMessage) {
+	switch m.Action {
+	case "subscribe":
+		if !validTopics[m.Topic] {
+			c.queueJson(map[string]string{"error": "Invalid topic"})
+			return
+		}
+
+		f := &filter{accounts: make(map[types.Account]bool)}
+		filtered := m.Options.Accounts
+		if m.Topic == TopicVote {
+			filtered = m.Options.Representatives
+		}
+		for _, account := range filtered {
+			if !address.ValidateAddress(account) {
+				c.queueJson(map[string]string{"error": "Invalid account " + string(account)})
+				return
+			}
+			// Filters match on the canonical form of the address
+			pub, _ := address.AddressToPub(account)
+			f.accounts[address.PubKeyToAddress(pub)] = true
+		}
+
+		c.mutex.Lock()
+		c.topics[m.Topic] = f
+		c.mutex.Unlock()
+
+	case "unsubscribe":
+		c.mutex.Lock()
+		delete(c.topics, m.Topic)
+		c.mutex.Unlock()
+
+	case "ping":
+		c.queueJson(ackMessage{Ack: "pong", Time: timestamp(), Id: m.Id})
+		return
+
+	default:
+		c.queueJson(map[string]string{"error": "Unknown action"})
+		return
+	}
+
+	if m.Ack {
+		c.queueJson(ackMessage{Ack: m.Action, Time: timestamp(), Id: m.Id})
+	}
+	// New difficulty subscribers get the current value straight away
+	if m.Action == "subscribe" && m.Topic == TopicActiveDifficulty {
+		c.queueJson(event{Topic: TopicActiveDifficulty, Time: timestamp(), Message: c.server.difficultyMessage()})
+	}
+}
+
+func (c *client) writeLoop() {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case data := <-c.send:
+			c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+			err := c.conn.WriteMessage(websocket.TextMessage, data)
+			if err != nil {
+				c.server.remove(c)
+				return
+			}
+		case <-ticker.C:
+			err := c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+			if err != nil {
+				c.server.remove(c)
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func formatAmount(u uint128.Uint128) string {
+	return new(big.Int).SetBytes(u.GetBytes()).String()
+}
@@ -0,0 +1,344 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package ws
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/logging"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/types"
+)
+
+var logger = logging.New("websocket")
+
+// How often the network's active difficulty is checked for changes
+var DifficultyInterval = time.Second
+
+// Topics clients can subscribe to
+const (
+	TopicConfirmation     = "confirmation"
+	TopicNewBlock         = "new_unconfirmed_block"
+	TopicVote             = "vote"
+	TopicActiveDifficulty = "active_difficulty"
+)
+
+const (
+	// Messages queued for a client beyond this are dropped along with the
+	// client, so a slow reader can't hold up the node
+	clientQueueSize = 256
+	pingInterval    = 30 * time.Second
+	writeTimeout    = 10 * time.Second
+	maxMessageSize  = 64 * 1024
+	// Node events waiting to be sent out
+	eventQueueSize = 1024
+)
+
+// A WebSocket server which pushes ledger events to subscribed clients.
+// Events are encoded and sent on the server's own goroutine, so the node
+// never waits on clients.
+type Server struct {
+	Addr string
+
+	upgrader   websocket.Upgrader
+	http       *http.Server
+	listener   net.Listener
+	mutex      sync.Mutex
+	clients    map[*client]bool
+	difficulty blocks.Difficulty
+	events     chan func()
+	done       chan struct{}
+	stopOnce   sync.Once
+	unobserve  []func()
+}
+
+// Creates a server and starts observing the node for events
+func NewServer(addr string) *Server {
+	s := &Server{
+		Addr:       addr,
+		clients:    make(map[*client]bool),
+		difficulty: blocks.WorkThreshold,
+		events:     make(chan func(), eventQueueSize),
+		done:       make(chan struct{}),
+		upgrader: websocket.Upgrader{
+			// Clients are services rather than browsers, so any origin is
+			// allowed as with the rpc server
+			CheckOrigin: func(r *http.Request) bool { return true },
+		},
+	}
+
+	go s.run()
+	s.unobserve = []func(){
+		node.ActiveElections.Observe(s.blockConfirmed),
+		node.ActiveElections.ObserveVotes(s.voteReceived),
+		node.ObserveNewBlocks(s.blockStored),
+	}
+	return s
+}
+
+// Starts accepting connections in the background
+func (s *Server) Start() error {
+	listener, err := net.Listen("tcp", s.Addr)
+	if err != nil {
+		return err
+	}
+	s.listener = listener
+	s.http = &http.Server{Handler: s}
+
+	logger.Infof("Listening for websocket connections on %s", listener.Addr())
+	go func() {
+		err := s.http.Serve(listener)
+		if err != nil && err != http.ErrServerClosed {
+			logger.Errorf("Websocket server failed: %s", err)
+		}
+	}()
+	return nil
+}
+
+// Stops observing the node, stops accepting connections and disconnects
+// every client
+func (s *Server) Stop() error {
+	s.stopOnce.Do(func() {
+		for _, unobserve := range s.unobserve {
+			unobserve()
+		}
+		close(s.done)
+	})
+
+	var err error
+	if s.http != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		err = s.http.Shutdown(ctx)
+	}
+
+	s.mutex.Lock()
+	clients := s.clients
+	s.clients = make(map[*client]bool)
+	s.mutex.Unlock()
+
+	for c := range clients {
+		c.close()
+	}
+	return err
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	conn, err := s.upgrader.Upgrade(w, r, nil)
+	if err != nil {
+		return
+	}
+
+	c := &client{
+		server: s,
+		conn:   conn,
+		send:   make(chan []byte, clientQueueSize),
+		done:   make(chan struct{}),
+		topics: make(map[string]*filter),
+	}
+
+	s.mutex.Lock()
+	s.clients[c] = true
+	s.mutex.Unlock()
+
+	go c.writeLoop()
+	go c.readLoop()
+}
+
+func (s *Server) remove(c *client) {
+	s.mutex.Lock()
+	delete(s.clients, c)
+	s.mutex.Unlock()
+	c.close()
+}
+
+func (s *Server) run() {
+	ticker := time.NewTicker(DifficultyInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case fn := <-s.events:
+			fn()
+		case <-ticker.C:
+			s.setActiveDifficulty(node.ActiveElections.Difficulty())
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// Queues an event to be sent by the server's goroutine. Never blocks, as
+// it's called while the node is processing blocks and votes.
+func (s *Server) post(fn func()) {
+	select {
+	case s.events <- fn:
+	default:
+		logger.Warnf("Websocket event queue is full, dropping an event")
+	}
+}
+
+type event struct {
+	Topic   string      `json:"topic"`
+	Time    string      `json:"time"`
+	Message interface{} `json:"message"`
+}
+
+func timestamp() string {
+	return strconv.FormatInt(time.Now().UnixNano()/int64(time.Millisecond), 10)
+}
+
+// Sends a message to every client subscribed to the topic whose filter
+// matches one of the accounts involved
+func (s *Server) broadcast(topic string, message interface{}, accounts ...types.Account) {
+	data, err := json.Marshal(event{Topic: topic, Time: timestamp(), Message: message})
+	if err != nil {
+		logger.Errorf("Failed to encode %s event: %s", topic, err)
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for c := range s.clients {
+		if c.subscribed(topic, accounts) && !c.queue(data) {
+			delete(s.clients, c)
+			go c.close()
+		}
+	}
+}
+
+// The accounts a block concerns: its own and a send's destination
+func blockAccounts(block blocks.Block) []types.Account {
+	accounts := []types.Account{store.FetchBlockAccount(block)}
+	if send, ok := block.(*blocks.SendBlock); ok {
+		accounts = append(accounts, send.Destination)
+	}
+	return accounts
+}
+
+func blockJson(block blocks.Block) json.RawMessage {
+	contents, err := blocks.ToJson(block)
+	if err != nil {
+		return nil
+	}
+	return json.RawMessage(contents)
+}
+
+type confirmationMessage struct {
+	Account          types.Account   `json:"account"`
+	Amount           string          `json:"amount"`
+	Hash             types.BlockHash `json:"hash"`
+	ConfirmationType string          `json:"confirmation_type"`
+	Block            json.RawMessage `json:"block"`
+}
+
+func (s *Server) blockConfirmed(block blocks.Block) {
+	s.post(func() {
+		accounts := blockAccounts(block)
+		s.broadcast(TopicConfirmation, confirmationMessage{
+			Account:          accounts[0],
+			Amount:           formatAmount(store.GetAmount(block)),
+			Hash:             block.Hash(),
+			ConfirmationType: "active_quorum",
+			Block:            blockJson(block),
+		}, accounts...)
+	})
+}
+
+func (s *Server) blockStored(block blocks.Block) {
+	s.post(func() {
+		s.broadcast(TopicNewBlock, blockJson(block), blockAccounts(block)...)
+	})
+}
+
+type voteMessage struct {
+	Account   types.Account     `json:"account"`
+	Signature string            `json:"signature"`
+	Sequence  string            `json:"sequence"`
+	Blocks    []types.BlockHash `json:"blocks"`
+	Type      string            `json:"type"`
+}
+
+func (s *Server) voteReceived(vote *node.MessageVote) {
+	// Read now, as the vote may be reused once we return
+	rep := address.PubKeyToAddress(vote.Account[:])
+	message := voteMessage{
+		Account:   rep,
+		Signature: strings.ToUpper(hex.EncodeToString(vote.Signature[:])),
+		Sequence:  strconv.FormatUint(vote.SequenceNumber(), 10),
+		Blocks:    []types.BlockHash{vote.ToBlock().Hash()},
+		Type:      "vote",
+	}
+	s.post(func() {
+		s.broadcast(TopicVote, message, rep)
+	})
+}
+
+type difficultyMessage struct {
+	NetworkMinimum string `json:"network_minimum"`
+	NetworkCurrent string `json:"network_current"`
+	Multiplier     string `json:"multiplier"`
+}
+
+func (s *Server) difficultyMessage() difficultyMessage {
+	s.mutex.Lock()
+	current := s.difficulty
+	s.mutex.Unlock()
+
+	minimum := blocks.WorkThreshold
+	return difficultyMessage{
+		NetworkMinimum: minimum.String(),
+		NetworkCurrent: current.String(),
+		Multiplier:     strconv.FormatFloat(current.Multiplier(minimum), 'f', -1, 64),
+	}
+}
+
+// Updates the work difficulty the network currently requires, notifying
+// subscribers if it's changed
+func (s *Server) setActiveDifficulty(difficulty blocks.Difficulty) {
+	s.mutex.Lock()
+	changed := s.difficulty != difficulty
+	s.difficulty = difficulty
+	s.mutex.Unlock()
+
+	if changed {
+		s.post(func() {
+			s.broadcast(TopicActiveDifficulty, s.difficultyMessage())
+		})
+	}
+}
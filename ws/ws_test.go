@@ -0,0 +1,164 @@
+/*
+Copyright (c) 2018 Frank Hamand
+All rights reserved.
+
+Redistribution and use in source and binary forms, with or without
+modification, are permitted provided that the following conditions are met:
+
+* Redistributions of source code must retain the above copyright notice, this
+  list of conditions and the following disclaimer.
+
+* Redistributions in binary form must reproduce the above copyright notice,
+  this list of conditions and the following disclaimer in the documentation
+  and/or other materials provided with the distribution.
+
+THIS SOFTWARE IS PROVIDED BY THE COPYRIGHT HOLDERS AND CONTRIBUTORS "AS IS"
+AND ANY EXPRESS OR IMPLIED WARRANTIES, INCLUDING, BUT NOT LIMITED TO, THE
+IMPLIED WARRANTIES OF MERCHANTABILITY AND FITNESS FOR A PARTICULAR PURPOSE ARE
+DISCLAIMED. IN NO EVENT SHALL THE COPYRIGHT HOLDER OR CONTRIBUTORS BE LIABLE
+FOR ANY DIRECT, INDIRECT, INCIDENTAL, SPECIAL, EXEMPLARY, OR CONSEQUENTIAL
+DAMAGES (INCLUDING, BUT NOT LIMITED TO, PROCUREMENT OF SUBSTITUTE GOODS OR
+SERVICES; LOSS OF USE, DATA, OR PROFITS; OR BUSINESS INTERRUPTION) HOWEVER
+CAUSED AND ON ANY THEORY OF LIABILITY, WHETHER IN CONTRACT, STRICT LIABILITY,
+OR TORT (INCLUDING NEGLIGENCE OR OTHERWISE) ARISING IN ANY WAY OUT OF THE USE
+OF THIS SOFTWARE, EVEN IF ADVISED OF THE POSSIBILITY OF SUCH DAMAGE.
+*/
+package ws
+
+import (
+	"context"
+	"fmt"
+	"net/http/httptest"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/svaishnavy/nano/address"
+	"github.com/svaishnavy/nano/blocks"
+	"github.com/svaishnavy/nano/node"
+	"github.com/svaishnavy/nano/store"
+	"github.com/svaishnavy/nano/uint128"
+)
+
+func dial(t *testing.T, url string) *websocket.Conn {
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(url, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+func receive(t *testing.T, conn *websocket.Conn) map[string]interface{} {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	var m map[string]interface{}
+	err := conn.ReadJSON(&m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+func TestSubscriptions(t *testing.T) {
+	blocks.WorkThreshold = 0xff00000000000000
+	store.Init(store.TestConfig)
+	defer os.RemoveAll(store.TestConfig.Path)
+
+	node.ActiveElections = node.NewElections()
+	DifficultyInterval = 10 * time.Millisecond
+	s := NewServer("")
+	server := httptest.NewServer(s)
+	defer server.Close()
+	defer s.Stop()
+
+	genesis := blocks.TestGenesisBlock
+	pub, _ := address.GenerateKey()
+	destination := address.PubKeyToAddress(pub)
+	send := &blocks.SendBlock{
+		PreviousHash: genesis.Hash(),
+		Destination:  destination,
+		Balance:      blocks.GenesisAmount.Sub(uint128.FromInts(0, 5)),
+	}
+	send.Work = blocks.GenerateWork(genesis)
+	err := store.StoreBlock(send)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dial(t, server.URL)
+	defer conn.Close()
+	conn.WriteJSON(map[string]interface{}{
+		"action":  "subscribe",
+		"topic":   TopicConfirmation,
+		"ack":     true,
+		"id":      "1",
+		"options": map[string]interface{}{"accounts": []string{string(destination)}},
+	})
+	if ack := receive(t, conn); ack["ack"] != "subscribe" || ack["id"] != "1" {
+		t.Fatalf("Unexpected ack %v", ack)
+	}
+
+	// Only the second block concerns the subscribed account
+	other, _ := address.GenerateKey()
+	unrelated := *send
+	unrelated.Destination = address.PubKeyToAddress(other)
+	s.blockConfirmed(&unrelated)
+	s.blockStored(send)
+	s.blockConfirmed(send)
+
+	m := receive(t, conn)
+	message, _ := m["message"].(map[string]interface{})
+	if m["topic"] != TopicConfirmation || message["hash"] != string(send.Hash()) {
+		t.Fatalf("Unexpected event %v", m)
+	}
+	if message["amount"] != "5" || message["account"] != string(genesis.Account) {
+		t.Errorf("Wrong confirmation details %v", message)
+	}
+
+	conn.WriteJSON(map[string]interface{}{"action": "subscribe", "topic": TopicActiveDifficulty})
+	m = receive(t, conn)
+	message, _ = m["message"].(map[string]interface{})
+	if m["topic"] != TopicActiveDifficulty || message["multiplier"] != "1" {
+		t.Errorf("Unexpected difficulty event %v", m)
+	}
+
+	// An election for a block with harder work raises the difficulty
+	harder := &blocks.SendBlock{
+		PreviousHash: send.Hash(),
+		Destination:  destination,
+		Balance:      send.Balance,
+	}
+	harder.Work, err = blocks.GenerateWorkContext(context.Background(), send.Hash(), blocks.FromMultiplier(2, blocks.WorkThreshold), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	node.ActiveElections.Start(harder)
+	m = receive(t, conn)
+	message, _ = m["message"].(map[string]interface{})
+	multiplier, _ := strconv.ParseFloat(fmt.Sprint(message["multiplier"]), 64)
+	if m["topic"] != TopicActiveDifficulty || multiplier < 2 {
+		t.Errorf("Unexpected difficulty change %v", m)
+	}
+
+	conn.WriteJSON(map[string]interface{}{"action": "subscribe", "topic": "nonsense"})
+	if m := receive(t, conn); m["error"] == nil {
+		t.Errorf("Subscribed to invalid topic")
+	}
+
+	// A stopped server no longer hears from the node
+	s.Stop()
+	next := &blocks.SendBlock{
+		PreviousHash: send.Hash(),
+		Destination:  destination,
+		Balance:      send.Balance.Sub(uint128.FromInts(0, 5)),
+	}
+	next.Work = blocks.GenerateWork(send)
+	if err := node.StoreBlock(next); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.events) != 0 {
+		t.Errorf("Stopped server still observed the node")
+	}
+}